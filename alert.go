@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"time"
+
+	"otn/alerts"
+	"otn/htmlconv"
+)
+
+// Привязка парсера оповещений к папкам и отправителям
+type AlertParserConfig struct {
+	Type    string   `json:"type"`    // zabbix, grafana, alertmanager
+	Folders []string `json:"folders"` // Имена папок
+	Senders []string `json:"senders"` // Адреса или части адресов отправителей
+
+	// Связывание RESOLVED с ранее отправленным PROBLEM: "" (выключено), "edit" или "reply"
+	Correlate string `json:"correlate"`
+	// Выражение для ключа корреляции по теме письма (по умолчанию - ID события)
+	SubjectKey string `json:"subject_key"`
+	subjectKey *regexp.Regexp
+}
+
+// Эмодзи для уровней важности
+var severityEmoji = map[string]string{
+	alerts.SeverityDisaster:      "🔥",
+	alerts.SeverityHigh:          "🔴",
+	alerts.SeverityAverage:       "🟠",
+	alerts.SeverityWarning:       "🟡",
+	alerts.SeverityInformation:   "🔵",
+	alerts.SeverityNotClassified: "⚪",
+}
+
+// Проверка настроек парсеров оповещений
+func validateAlertParsers() error {
+	for i := range config.AlertParsers {
+		p := &config.AlertParsers[i]
+		if _, ok := alerts.Get(p.Type); !ok {
+			return fmt.Errorf("Неизвестный тип парсера в alert_parsers %d: %s (допустимы: %s)",
+				i, p.Type, strings.Join(alerts.Names(), ", "))
+		}
+		if len(p.Folders) == 0 && len(p.Senders) == 0 {
+			return fmt.Errorf("В alert_parsers %d нужно указать folders или senders", i)
+		}
+		if p.Correlate != "" && p.Correlate != correlateEdit && p.Correlate != correlateReply {
+			return fmt.Errorf("Correlate в alert_parsers %d должно быть пустым, \"edit\" или \"reply\"", i)
+		}
+		if p.SubjectKey != "" {
+			re, err := regexp.Compile(p.SubjectKey)
+			if err != nil {
+				return fmt.Errorf("Некорректный subject_key в alert_parsers %d: %v", i, err)
+			}
+			p.subjectKey = re
+		}
+	}
+	return nil
+}
+
+// Возвращает парсер, настроенный для папки или отправителя письма
+func findAlertParser(folderName, senderEmail string) (*AlertParserConfig, alerts.Parser) {
+	senderEmail = strings.ToLower(senderEmail)
+	for i := range config.AlertParsers {
+		p := &config.AlertParsers[i]
+		matched := false
+		for _, f := range p.Folders {
+			if f == folderName {
+				matched = true
+				break
+			}
+		}
+		for _, s := range p.Senders {
+			if !matched && s != "" && strings.Contains(senderEmail, strings.ToLower(s)) {
+				matched = true
+			}
+		}
+		if matched {
+			parser, _ := alerts.Get(p.Type)
+			return p, parser
+		}
+	}
+	return nil, nil
+}
+
+// Формирует компактное уведомление об оповещении
+func formatAlertMessage(folder string, alert *alerts.Alert) string {
+	var msg strings.Builder
+	emoji := config.Telegram.UseEmojis
+
+	status := "PROBLEM"
+	if alert.Status == alerts.StatusResolved {
+		status = "RESOLVED"
+	}
+	if emoji {
+		if alert.Status == alerts.StatusResolved {
+			msg.WriteString("✅ ")
+		} else {
+			msg.WriteString(severityEmoji[alert.Severity] + " ")
+		}
+	}
+	msg.WriteString("<b>" + status + ":</b> " + html.EscapeString(alert.Trigger) + "\n")
+
+	if alert.Host != "" {
+		if emoji {
+			msg.WriteString("🖥 ")
+		}
+		msg.WriteString("<b>Хост:</b> " + html.EscapeString(alert.Host) + "\n")
+	}
+	if alert.RawSeverity != "" {
+		if emoji {
+			msg.WriteString("⚠️ ")
+		}
+		msg.WriteString("<b>Важность:</b> " + html.EscapeString(alert.RawSeverity) + "\n")
+	}
+	if alert.Details != "" {
+		msg.WriteString("<i>" + html.EscapeString(truncateByRunes(alert.Details, 500)) + "</i>\n")
+	}
+	if alert.EventID != "" {
+		msg.WriteString("<b>Событие:</b> <code>" + html.EscapeString(alert.EventID) + "</code>\n")
+	}
+	if emoji {
+		msg.WriteString("📥 ")
+	}
+	msg.WriteString("<b>Папка:</b> " + html.EscapeString(folder))
+
+	return msg.String()
+}
+
+const (
+	correlateEdit  = "edit"
+	correlateReply = "reply"
+)
+
+// Ключ корреляции: часть темы по subject_key или ID события
+func alertCorrelationKey(cfg *AlertParserConfig, alert *alerts.Alert, subject string) string {
+	key := alert.EventID
+	if cfg.subjectKey != nil {
+		m := cfg.subjectKey.FindStringSubmatch(subject)
+		switch {
+		case len(m) > 1:
+			key = m[1]
+		case len(m) == 1:
+			key = m[0]
+		default:
+			key = ""
+		}
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return ""
+	}
+	return cfg.Type + ":" + key
+}
+
+// Отправляет оповещение с ключом корреляции key. Уведомление о проблеме
+// запоминается, а уведомление о решении изменяет исходное сообщение или
+// отправляется ответом на него
+func sendCorrelated(msg telegramMessage, key, correlate, status string) (int, error) {
+	if key == "" {
+		return sendTelegram(msg)
+	}
+
+	if status == alerts.StatusProblem {
+		messageID, err := sendTelegram(msg)
+		if err == nil {
+			state.rememberAlert(key, SentMessage{
+				ChatID:    msg.ChatID,
+				MessageID: messageID,
+				Text:      msg.Text,
+				Time:      time.Now(),
+			})
+		}
+		return messageID, err
+	}
+
+	original, ok := state.lookupAlert(key)
+	if !ok {
+		return sendTelegram(msg)
+	}
+
+	if correlate == correlateEdit {
+		resolved := "<b>Решено</b> " + time.Now().Format("15:04 02.01.2006")
+		if config.Telegram.UseEmojis {
+			resolved = "✅ " + resolved
+		}
+		text := "<s>" + htmlconv.Truncate(original.Text, 3800) + "</s>\n" + resolved
+
+		err := editTelegramMessage(original.ChatID, original.MessageID, text, msg.Reserved)
+		if err == nil {
+			state.forgetAlert(key)
+			logMessage("Оповещение %s отмечено как решенное", key)
+			return original.MessageID, nil
+		}
+		// Сообщение могло быть удалено - отвечаем на него новым сообщением
+		logMessage("Не удалось изменить сообщение %d: %v", original.MessageID, err)
+	}
+
+	msg.ChatID = original.ChatID
+	msg.ReplyTo = original.MessageID
+	messageID, err := sendTelegram(msg)
+	if err == nil {
+		state.forgetAlert(key)
+	}
+	return messageID, err
+}
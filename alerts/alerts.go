@@ -0,0 +1,243 @@
+// Пакет alerts разбирает письма систем мониторинга (Zabbix, Grafana,
+// Prometheus Alertmanager) и извлекает из них структуру оповещения.
+package alerts
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Статусы оповещения
+const (
+	StatusProblem  = "PROBLEM"
+	StatusResolved = "RESOLVED"
+)
+
+// Нормализованные уровни важности (по шкале Zabbix)
+const (
+	SeverityNotClassified = "not_classified"
+	SeverityInformation   = "information"
+	SeverityWarning       = "warning"
+	SeverityAverage       = "average"
+	SeverityHigh          = "high"
+	SeverityDisaster      = "disaster"
+)
+
+// Alert - оповещение, извлеченное из письма
+type Alert struct {
+	Source      string // Имя парсера: zabbix, grafana, alertmanager
+	Status      string // PROBLEM или RESOLVED
+	Severity    string // Нормализованная важность
+	RawSeverity string // Важность в том виде, в котором она пришла в письме
+	Host        string
+	Trigger     string
+	EventID     string
+	Details     string // Дополнительные данные (operational data, summary)
+}
+
+// Parser извлекает оповещение из темы и текстового тела письма
+type Parser interface {
+	Name() string
+	Parse(subject, body string) (*Alert, error)
+}
+
+// ErrNotAlert - письмо не похоже на оповещение этого парсера
+var ErrNotAlert = errors.New("письмо не распознано как оповещение")
+
+var registry = map[string]Parser{}
+
+// Register добавляет парсер в реестр.
+func Register(p Parser) {
+	registry[p.Name()] = p
+}
+
+// Get возвращает парсер по имени.
+func Get(name string) (Parser, bool) {
+	p, ok := registry[strings.ToLower(name)]
+	return p, ok
+}
+
+// Names возвращает имена зарегистрированных парсеров.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register(zabbixParser{})
+	Register(labelsParser{name: "grafana"})
+	Register(labelsParser{name: "alertmanager"})
+}
+
+// NormalizeSeverity приводит важность из разных систем к шкале Zabbix.
+func NormalizeSeverity(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "disaster", "чрезвычайная", "fatal", "emergency", "page":
+		return SeverityDisaster
+	case "high", "высокая", "critical", "crit", "error":
+		return SeverityHigh
+	case "average", "средняя", "major":
+		return SeverityAverage
+	case "warning", "warn", "предупреждение", "minor":
+		return SeverityWarning
+	case "information", "info", "информация", "informational", "none", "ok":
+		return SeverityInformation
+	default:
+		return SeverityNotClassified
+	}
+}
+
+// Разбирает строки вида "Ключ: значение" в словарь с ключами в нижнем регистре
+func parseFields(body string) map[string]string {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "-"))
+		idx := strings.Index(line, ":")
+		if idx <= 0 || idx > 40 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(line[:idx]))
+		value := strings.TrimSpace(line[idx+1:])
+		if _, exists := fields[key]; !exists && value != "" {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// Возвращает первое непустое значение по списку возможных ключей
+func lookup(fields map[string]string, keys ...string) string {
+	for _, key := range keys {
+		if v := fields[key]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ---------------------------------------------------------------------------
+// Zabbix
+
+type zabbixParser struct{}
+
+func (zabbixParser) Name() string { return "zabbix" }
+
+var (
+	zabbixProblemSubject  = regexp.MustCompile(`(?i)^\s*(problem|проблема)\s*:\s*(.+)$`)
+	zabbixResolvedSubject = regexp.MustCompile(`(?i)^\s*(resolved|решено|решена|ok)(\s+(in|за)\s+[^:]+)?\s*:\s*(.+)$`)
+)
+
+func (zabbixParser) Parse(subject, body string) (*Alert, error) {
+	fields := parseFields(body)
+	alert := &Alert{Source: "zabbix"}
+
+	switch {
+	case zabbixResolvedSubject.MatchString(subject):
+		alert.Status = StatusResolved
+		alert.Trigger = zabbixResolvedSubject.FindStringSubmatch(subject)[4]
+	case zabbixProblemSubject.MatchString(subject):
+		alert.Status = StatusProblem
+		alert.Trigger = zabbixProblemSubject.FindStringSubmatch(subject)[2]
+	}
+
+	// Статус в теле письма точнее темы (если шаблон его содержит)
+	switch strings.ToUpper(lookup(fields, "trigger status", "status", "статус", "статус триггера")) {
+	case "PROBLEM", "ПРОБЛЕМА":
+		alert.Status = StatusProblem
+	case "OK", "RESOLVED", "РЕШЕНО", "РЕШЕНА":
+		alert.Status = StatusResolved
+	}
+	if alert.Status == "" {
+		if strings.Contains(strings.ToLower(body), "has been resolved") {
+			alert.Status = StatusResolved
+		} else if strings.Contains(strings.ToLower(body), "problem started") {
+			alert.Status = StatusProblem
+		}
+	}
+
+	if name := lookup(fields, "problem name", "trigger", "trigger name", "проблема", "триггер", "имя проблемы"); name != "" {
+		alert.Trigger = name
+	}
+	alert.Host = lookup(fields, "host", "узел", "узел сети", "хост")
+	alert.RawSeverity = lookup(fields, "severity", "trigger severity", "важность", "серьезность")
+	alert.Severity = NormalizeSeverity(alert.RawSeverity)
+	alert.EventID = lookup(fields, "original problem id", "event id", "problem id", "id события", "id проблемы")
+	alert.Details = lookup(fields, "operational data", "операционные данные")
+
+	alert.Trigger = strings.TrimSpace(alert.Trigger)
+	if alert.Status == "" || alert.Trigger == "" {
+		return nil, ErrNotAlert
+	}
+	return alert, nil
+}
+
+// ---------------------------------------------------------------------------
+// Grafana и Prometheus Alertmanager: оба присылают тему "[FIRING:N] ..." или
+// "[RESOLVED] ..." и список меток "alertname = ..." в теле письма
+
+type labelsParser struct {
+	name string
+}
+
+func (p labelsParser) Name() string { return p.name }
+
+var (
+	firingSubject = regexp.MustCompile(`(?i)\[(FIRING|RESOLVED)(:\d+)?\]\s*(.*)$`)
+	labelLine     = regexp.MustCompile(`^\s*[-•*]?\s*([A-Za-z_][A-Za-z0-9_]*)\s*[=:]\s*(.+?)\s*$`)
+)
+
+func (p labelsParser) Parse(subject, body string) (*Alert, error) {
+	m := firingSubject.FindStringSubmatch(subject)
+	if m == nil {
+		return nil, ErrNotAlert
+	}
+
+	alert := &Alert{Source: p.name, Status: StatusProblem}
+	if strings.EqualFold(m[1], "RESOLVED") {
+		alert.Status = StatusResolved
+	}
+
+	labels := make(map[string]string)
+	for _, line := range strings.Split(body, "\n") {
+		if lm := labelLine.FindStringSubmatch(line); lm != nil {
+			key := strings.ToLower(lm[1])
+			if _, exists := labels[key]; !exists {
+				labels[key] = lm[2]
+			}
+		}
+	}
+
+	alert.Trigger = labels["alertname"]
+	if alert.Trigger == "" {
+		// В теме после статуса идет имя правила, а в скобках - метки
+		name := strings.TrimSpace(m[3])
+		if idx := strings.Index(name, " ("); idx > 0 {
+			name = name[:idx]
+		}
+		alert.Trigger = name
+	}
+
+	alert.Host = lookup(labels, "instance", "host", "hostname", "node", "pod", "service")
+	alert.RawSeverity = lookup(labels, "severity", "priority", "level")
+	alert.Severity = NormalizeSeverity(alert.RawSeverity)
+	alert.Details = lookup(labels, "summary", "description", "message")
+	alert.EventID = lookup(labels, "fingerprint")
+	if alert.EventID == "" && alert.Trigger != "" {
+		// Без fingerprint оповещение однозначно определяется именем и узлом
+		alert.EventID = alert.Trigger
+		if alert.Host != "" {
+			alert.EventID += "/" + alert.Host
+		}
+	}
+
+	if alert.Trigger == "" {
+		return nil, ErrNotAlert
+	}
+	return alert, nil
+}
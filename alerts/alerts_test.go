@@ -0,0 +1,150 @@
+package alerts
+
+import (
+	"errors"
+	"testing"
+)
+
+func parse(t *testing.T, parser, subject, body string) *Alert {
+	t.Helper()
+	p, ok := Get(parser)
+	if !ok {
+		t.Fatalf("парсер %s не зарегистрирован", parser)
+	}
+	alert, err := p.Parse(subject, body)
+	if err != nil {
+		t.Fatalf("%s: Parse(%q): %v", parser, subject, err)
+	}
+	return alert
+}
+
+func checkAlert(t *testing.T, got *Alert, want Alert) {
+	t.Helper()
+	if *got != want {
+		t.Errorf("оповещение:\n got %+v\nwant %+v", *got, want)
+	}
+}
+
+func TestZabbix(t *testing.T) {
+	problem := parse(t, "zabbix", "Problem: High CPU utilization on db1",
+		"Problem started at 12:30:05 on 2024.05.20\n"+
+			"Problem name: High CPU utilization on db1\n"+
+			"Host: db1\n"+
+			"Severity: High\n"+
+			"Operational data: 95 %\n"+
+			"Original problem ID: 12345\n")
+	checkAlert(t, problem, Alert{Source: "zabbix", Status: StatusProblem, Severity: SeverityHigh, RawSeverity: "High",
+		Host: "db1", Trigger: "High CPU utilization on db1", EventID: "12345", Details: "95 %"})
+
+	resolved := parse(t, "zabbix", "Resolved in 5m 3s: High CPU utilization on db1",
+		"Problem has been resolved at 12:35:08 on 2024.05.20\n"+
+			"Problem name: High CPU utilization on db1\n"+
+			"Problem duration: 5m 3s\n"+
+			"Host: db1\n"+
+			"Severity: High\n"+
+			"Original problem ID: 12345\n")
+	checkAlert(t, resolved, Alert{Source: "zabbix", Status: StatusResolved, Severity: SeverityHigh, RawSeverity: "High",
+		Host: "db1", Trigger: "High CPU utilization on db1", EventID: "12345"})
+}
+
+func TestZabbixRussian(t *testing.T) {
+	problem := parse(t, "zabbix", "Проблема: Недоступен web1",
+		"Проблема: Недоступен web1\nУзел сети: web1\nВажность: Средняя\nID события: 777\n")
+	checkAlert(t, problem, Alert{Source: "zabbix", Status: StatusProblem, Severity: SeverityAverage, RawSeverity: "Средняя",
+		Host: "web1", Trigger: "Недоступен web1", EventID: "777"})
+
+	// Статус в теле письма важнее темы
+	resolved := parse(t, "zabbix", "Проблема: Недоступен web1",
+		"Статус: РЕШЕНО\nПроблема: Недоступен web1\nУзел сети: web1\nID события: 777\n")
+	if resolved.Status != StatusResolved {
+		t.Errorf("Status = %s, want %s", resolved.Status, StatusResolved)
+	}
+}
+
+func TestGrafana(t *testing.T) {
+	problem := parse(t, "grafana", "[FIRING:1] HighLatency (api-1 critical)",
+		"**Firing**\n\n"+
+			"Value: B=2.5\n"+
+			"Labels:\n"+
+			" - alertname = HighLatency\n"+
+			" - instance = api-1\n"+
+			" - severity = critical\n"+
+			"Annotations:\n"+
+			" - summary = Latency above 2s\n")
+	checkAlert(t, problem, Alert{Source: "grafana", Status: StatusProblem, Severity: SeverityHigh, RawSeverity: "critical",
+		Host: "api-1", Trigger: "HighLatency", EventID: "HighLatency/api-1", Details: "Latency above 2s"})
+
+	// Без меток в теле имя правила берется из темы
+	resolved := parse(t, "grafana", "[RESOLVED] HighLatency (api-1 critical)", "**Resolved**\n")
+	checkAlert(t, resolved, Alert{Source: "grafana", Status: StatusResolved, Severity: SeverityNotClassified,
+		Trigger: "HighLatency", EventID: "HighLatency"})
+}
+
+func TestAlertmanager(t *testing.T) {
+	body := "Labels:\n" +
+		"  alertname: InstanceDown\n" +
+		"  instance: node1:9100\n" +
+		"  severity: page\n" +
+		"  fingerprint: 5f2c8a\n" +
+		"Annotations:\n" +
+		"  description: node1:9100 has been down for more than 5 minutes\n"
+
+	problem := parse(t, "alertmanager", "[FIRING:2] InstanceDown (node1:9100)", body)
+	checkAlert(t, problem, Alert{Source: "alertmanager", Status: StatusProblem, Severity: SeverityDisaster, RawSeverity: "page",
+		Host: "node1:9100", Trigger: "InstanceDown", EventID: "5f2c8a", Details: "node1:9100 has been down for more than 5 minutes"})
+
+	resolved := parse(t, "alertmanager", "[RESOLVED] InstanceDown (node1:9100)", body)
+	if resolved.Status != StatusResolved || resolved.EventID != problem.EventID {
+		t.Errorf("Status = %s, EventID = %s", resolved.Status, resolved.EventID)
+	}
+}
+
+// Письмо, которое не удалось разобрать, парсер отклоняет ошибкой ErrNotAlert:
+// тогда processMessage отправляет его обычным уведомлением (formatMessage)
+func TestNotAlertFallsBack(t *testing.T) {
+	tests := []struct {
+		parser, subject, body string
+	}{
+		{"zabbix", "Еженедельный отчет", "Отчет во вложении.\nС уважением, Zabbix"},
+		{"zabbix", "Problem: ", "Host: db1\n"},
+		{"grafana", "Grafana: приглашение в организацию", "alertname = HighLatency\n"},
+		{"alertmanager", "[FIRING:1] ", "Labels:\n"},
+	}
+	for _, tt := range tests {
+		p, _ := Get(tt.parser)
+		alert, err := p.Parse(tt.subject, tt.body)
+		if !errors.Is(err, ErrNotAlert) || alert != nil {
+			t.Errorf("%s: Parse(%q) = %+v, %v; want ErrNotAlert", tt.parser, tt.subject, alert, err)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	if _, ok := Get("Zabbix"); !ok {
+		t.Error("Get должен искать без учета регистра")
+	}
+	if _, ok := Get("nagios"); ok {
+		t.Error("неизвестный парсер найден")
+	}
+	names := Names()
+	if len(names) != 3 || names[0] != "alertmanager" || names[1] != "grafana" || names[2] != "zabbix" {
+		t.Errorf("Names() = %v", names)
+	}
+}
+
+func TestNormalizeSeverity(t *testing.T) {
+	tests := map[string]string{
+		"Disaster":      SeverityDisaster,
+		" critical ":    SeverityHigh,
+		"Средняя":       SeverityAverage,
+		"warn":          SeverityWarning,
+		"info":          SeverityInformation,
+		"":              SeverityNotClassified,
+		"что-то другое": SeverityNotClassified,
+	}
+	for raw, want := range tests {
+		if got := NormalizeSeverity(raw); got != want {
+			t.Errorf("NormalizeSeverity(%q) = %s, want %s", raw, got, want)
+		}
+	}
+}
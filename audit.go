@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Запись журнала действий, выполненных из Telegram
+type AuditRecord struct {
+	Time    time.Time `json:"time"`
+	UserID  int64     `json:"user_id"`
+	User    string    `json:"user"`
+	Action  string    `json:"action"`
+	Folder  string    `json:"folder,omitempty"`
+	EntryID string    `json:"entry_id,omitempty"`
+	Result  string    `json:"result"`
+}
+
+var mutexAudit sync.Mutex
+
+const auditFileName = "audit.jsonl"
+
+// Добавляет запись в журнал действий (JSON Lines)
+func writeAudit(rec AuditRecord) {
+	mutexAudit.Lock()
+	defer mutexAudit.Unlock()
+
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+
+	file, err := os.OpenFile(dataFilePath(auditFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logMessage("Ошибка записи журнала действий: %v", err)
+		return
+	}
+	defer file.Close()
+
+	data, _ := json.Marshal(rec)
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		logMessage("Ошибка записи журнала действий: %v", err)
+	}
+}
+
+// Результат действия для журнала
+func auditResult(err error) string {
+	if err != nil {
+		return "error: " + err.Error()
+	}
+	return "ok"
+}
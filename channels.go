@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"otn/htmlconv"
+	"otn/mailsource"
+	"otn/notify"
+)
+
+// Маршрут в чат Telegram папки (chat_id или default_chat_id). Уведомления
+// идут по нему, если у папки не указаны channels
+const telegramRoute = "telegram"
+
+// Каналы доставки из раздела channels (заполняются после настройки HTTP-клиента)
+var notifiers = make(map[string]notify.Notifier)
+
+// Проверяет каналы и маршруты папок
+func validateChannels() error {
+	names := make(map[string]bool)
+	for i, channel := range config.Channels {
+		if err := channel.Validate(); err != nil {
+			return fmt.Errorf("Ошибка в channels %d: %v", i, err)
+		}
+		if channel.Name == telegramRoute {
+			return fmt.Errorf("Имя канала %q зарезервировано для чата папки", telegramRoute)
+		}
+		if names[channel.Name] {
+			return fmt.Errorf("Повторяющееся имя канала: %s", channel.Name)
+		}
+		names[channel.Name] = true
+	}
+
+	for i, folder := range config.Folders {
+		seen := make(map[string]bool)
+		for _, route := range folder.Channels {
+			if route != telegramRoute && !names[route] {
+				return fmt.Errorf("Канал %q папки %d не найден в channels", route, i)
+			}
+			if seen[route] {
+				return fmt.Errorf("Канал %q указан в папке %d дважды", route, i)
+			}
+			seen[route] = true
+		}
+	}
+	return nil
+}
+
+// Создает каналы доставки. Вызывается после initHTTPClient: запросы идут через прокси
+func initChannels() {
+	for _, cfg := range config.Channels {
+		if cfg.Type == notify.TypeTelegram && cfg.BotToken == "" {
+			cfg.BotToken = config.Telegram.BotToken
+		}
+		// Повторы выполняет очередь: канал, который ждет повтора, не задерживает
+		// отправку в остальные чаты и каналы
+		noRetries := 0
+		cfg.Retries = &noRetries
+		notifier, err := notify.New(cfg, httpClient)
+		if err != nil {
+			logMessage("Ошибка настройки канала %s: %v", cfg.Name, err)
+			continue
+		}
+		if tg, ok := notifier.(*notify.Telegram); ok && cfg.BotToken == config.Telegram.BotToken {
+			// Основной бот: ограничения частоты Telegram общие с уведомлениями папок.
+			// Отправку резервирует очередь (уведомления канала стоят в ней под
+			// чатом), после 429 чат откладывается
+			tg.Flood = pauseTelegramChat
+		}
+		notifiers[cfg.Name] = notifier
+	}
+}
+
+// Чат канала telegram из раздела channels, который использует основного бота
+func mainBotChannelChat(name string) (string, bool) {
+	for _, cfg := range config.Channels {
+		if cfg.Name == name && cfg.Type == notify.TypeTelegram &&
+			(cfg.BotToken == "" || cfg.BotToken == config.Telegram.BotToken) {
+			return cfg.ChatID, true
+		}
+	}
+	return "", false
+}
+
+// Маршруты уведомлений папки: по умолчанию только ее чат Telegram
+func (f Folder) routes() []string {
+	if len(f.Channels) == 0 {
+		return []string{telegramRoute}
+	}
+	return f.Channels
+}
+
+// Отправляет уведомление в канал из раздела channels одной попыткой, время
+// которой ограничено таймаутом HTTP-клиента канала
+func sendToChannel(ctx context.Context, name string, n notify.Notification) error {
+	notifier, ok := notifiers[name]
+	if !ok {
+		return fmt.Errorf("канал %s не настроен", name)
+	}
+	if err := notifier.Notify(ctx, n); err != nil {
+		return err
+	}
+	logMessage("Уведомление отправлено в канал %s", name)
+	return nil
+}
+
+// Уведомление для каналов кроме чата папки. Тело передается без разметки:
+// каждый канал форматирует его сам
+func channelNotification(mail mailsource.Message, subject, body string, folderConfig Folder) notify.Notification {
+	return notify.Notification{
+		ID:          mail.ID,
+		Folder:      mail.Folder,
+		SenderName:  mail.SenderName,
+		SenderEmail: mail.SenderEmail,
+		Subject:     subject,
+		Body:        plainBody(body, mail.IsHTML, folderConfig),
+		Importance:  mail.Importance,
+		Received:    mail.Received,
+		Attachments: mail.Attachments,
+	}
+}
+
+// Готовит тело письма без разметки: очищает и обрезает его по message_length
+func plainBody(body string, isHTML bool, folderConfig Folder) string {
+	if folderConfig.MessageLength == 0 {
+		return ""
+	}
+	if isHTML {
+		body = htmlconv.StripTags(htmlconv.Convert(body))
+	}
+
+	body = folderConfig.cleaner.Clean(body)
+	if len(config.CutText) != 0 {
+		re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(config.CutText))
+		if index := re.FindStringIndex(body); index != nil {
+			body = body[:index[0]]
+		}
+	}
+	if folderConfig.MessageLength > 0 {
+		body = truncateByRunes(body, folderConfig.MessageLength)
+	}
+	return strings.TrimSpace(body)
+}
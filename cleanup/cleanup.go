@@ -0,0 +1,187 @@
+// Пакет cleanup очищает тело письма перед отправкой: удаляет цепочки
+// цитирования (Outlook, Gmail, русские клиенты), подписи и юридические
+// дисклеймеры. Работает построчно и подходит как для обычного текста,
+// так и для текста с разметкой Telegram (теги при сравнении игнорируются).
+package cleanup
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Options - настройки очистки для папки
+type Options struct {
+	Quotes      bool     `json:"quotes"`      // Удалять цитируемую переписку
+	Signatures  bool     `json:"signatures"`  // Удалять подписи
+	Disclaimers []string `json:"disclaimers"` // Регулярные выражения дисклеймеров
+}
+
+// Cleaner - подготовленный к работе набор правил
+type Cleaner struct {
+	opts        Options
+	disclaimers []*regexp.Regexp
+}
+
+// New компилирует регулярные выражения дисклеймеров.
+func New(opts Options) (*Cleaner, error) {
+	c := &Cleaner{opts: opts}
+	for i, expr := range opts.Disclaimers {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("некорректное выражение дисклеймера %d: %v", i, err)
+		}
+		c.disclaimers = append(c.disclaimers, re)
+	}
+	return c, nil
+}
+
+var (
+	tagPattern = regexp.MustCompile(`<[^>]*>`)
+
+	// Разделители, после которых всегда идет исходное письмо
+	separatorPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^-{2,}\s*(original message|исходное сообщение|пересылаемое сообщение|forwarded message)\s*-{2,}$`),
+		regexp.MustCompile(`^_{10,}$`),
+	}
+
+	// Заголовок цитаты Outlook: "From:/От:" и в следующих строках "Sent:/Date:/Отправлено:/Дата:"
+	headerFromPattern = regexp.MustCompile(`(?i)^\*?(from|от|de|von)\s*:\*?\s*\S`)
+	headerNextPattern = regexp.MustCompile(`(?i)^\*?(sent|date|to|subject|отправлено|дата|кому|тема|envoyé|gesendet)\s*:`)
+
+	// Заголовок цитаты Gmail и подобных клиентов
+	wrotePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^on .+ wrote:$`),
+		regexp.MustCompile(`(?i)^.+ \d{1,2}:\d{2}.* wrote:$`),
+		regexp.MustCompile(`(?i)^.+ (написал|написала|пишет)\(?а?\)?:$`),
+		regexp.MustCompile(`(?i)^.+ г\. в \d{1,2}:\d{2}, .+:$`),
+	}
+
+	// Начало подписи
+	signatureDelimiter = regexp.MustCompile(`^--\s?$`)
+	valedictionPattern = regexp.MustCompile(`(?i)^(с уважением|с наилучшими пожеланиями|всего доброго|всего наилучшего|спасибо|best regards|kind regards|regards|best wishes|cheers|thanks|thank you|sincerely)[,.!]?$`)
+	// \b в Go учитывает только латиницу, поэтому конец фразы - пробел или конец строки
+	mobilePattern = regexp.MustCompile(`(?i)^(sent from my|отправлено с|отправлено из|get outlook for|получить outlook для)(\s|$)`)
+)
+
+// Подпись ищется только в последних строках письма
+const signatureWindow = 12
+
+// Clean применяет к телу письма включенные правила.
+func (c *Cleaner) Clean(body string) string {
+	if c == nil {
+		return body
+	}
+
+	for _, re := range c.disclaimers {
+		body = re.ReplaceAllString(body, "")
+	}
+
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	lines := strings.Split(body, "\n")
+
+	if c.opts.Quotes {
+		lines = cutQuotes(lines)
+	}
+	if c.opts.Signatures {
+		lines = cutSignature(lines)
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Возвращает видимый текст строки без тегов и лишних пробелов
+func plain(line string) string {
+	line = tagPattern.ReplaceAllString(line, "")
+	line = html.UnescapeString(line)
+	line = strings.ReplaceAll(line, " ", " ")
+	return strings.TrimSpace(line)
+}
+
+func cutQuotes(lines []string) []string {
+	content := false // Выше есть текст самого письма, а не только заголовки цитаты
+	for i, line := range lines {
+		text := plain(line)
+		if text == "" {
+			continue
+		}
+
+		header := isQuoteHeader(text) || (headerFromPattern.MatchString(text) && hasHeaderNearby(lines, i+1))
+		if header && content {
+			return lines[:i]
+		}
+		// Письмо, которое целиком состоит из пересылаемого сообщения, не обрезаем:
+		// иначе в уведомлении не останется ничего полезного
+		if !header && !headerNextPattern.MatchString(text) && !headerFromPattern.MatchString(text) {
+			content = true
+		}
+	}
+
+	// Строки, начинающиеся с ">", - классическое цитирование
+	result := lines[:0]
+	for _, line := range lines {
+		text := plain(line)
+		if strings.HasPrefix(text, ">") {
+			continue
+		}
+		result = append(result, line)
+	}
+	return result
+}
+
+func isQuoteHeader(text string) bool {
+	for _, re := range separatorPatterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	for _, re := range wrotePatterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Проверяет, что в ближайших строках есть еще одно поле заголовка письма
+func hasHeaderNearby(lines []string, from int) bool {
+	for j := from; j < len(lines) && j < from+4; j++ {
+		if headerNextPattern.MatchString(plain(lines[j])) {
+			return true
+		}
+	}
+	return false
+}
+
+func cutSignature(lines []string) []string {
+	start := len(lines) - signatureWindow
+	if start < 0 {
+		start = 0
+	}
+
+	// Строки "Отправлено с iPhone" удаляем в любом месте окна
+	result := append([]string(nil), lines[:start]...)
+	for _, line := range lines[start:] {
+		if !mobilePattern.MatchString(plain(line)) {
+			result = append(result, line)
+		}
+	}
+	lines = result
+
+	start = len(lines) - signatureWindow
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(lines); i++ {
+		text := plain(lines[i])
+		if signatureDelimiter.MatchString(text) || valedictionPattern.MatchString(text) {
+			// Не удаляем все письмо, если оно состоит только из подписи
+			if i == 0 {
+				return lines
+			}
+			return lines[:i]
+		}
+	}
+	return lines
+}
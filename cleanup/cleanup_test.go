@@ -0,0 +1,216 @@
+package cleanup
+
+import (
+	"strings"
+	"testing"
+)
+
+func newCleaner(t *testing.T, opts Options) *Cleaner {
+	t.Helper()
+	c, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func lines(s ...string) string {
+	return strings.Join(s, "\n")
+}
+
+func TestQuotes(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"Outlook", lines(
+			"Согласовано, отгружайте.",
+			"",
+			"From: Ivan Petrov <ivan@example.com>",
+			"Sent: Monday, May 20, 2024 10:15 AM",
+			"To: Ops <ops@example.com>",
+			"Subject: Отгрузка",
+			"",
+			"Прошу согласовать отгрузку.",
+		), "Согласовано, отгружайте."},
+		{"Outlook по-русски", lines(
+			"Принято.",
+			"",
+			"*От:* Иван Петров <ivan@example.com>",
+			"*Отправлено:* 20 мая 2024 г. 10:15",
+			"*Кому:* Ops",
+			"",
+			"Прошу согласовать отгрузку.",
+		), "Принято."},
+		{"Original Message", lines(
+			"Сделано.",
+			"-----Original Message-----",
+			"From: ivan@example.com",
+			"Текст исходного письма",
+		), "Сделано."},
+		{"Исходное сообщение", lines(
+			"Сделано.",
+			"-------- Исходное сообщение --------",
+			"От: ivan@example.com",
+		), "Сделано."},
+		{"Gmail", lines(
+			"Да, вечером посмотрю.",
+			"",
+			"On Mon, May 20, 2024 at 10:15 AM Ivan Petrov <ivan@example.com> wrote:",
+			"> Посмотри, пожалуйста, отчет.",
+		), "Да, вечером посмотрю."},
+		{"Gmail по-русски", lines(
+			"Да, вечером посмотрю.",
+			"",
+			"пн, 20 мая 2024 г. в 10:15, Иван Петров <ivan@example.com>:",
+			"> Посмотри, пожалуйста, отчет.",
+		), "Да, вечером посмотрю."},
+		{"написал", lines(
+			"Спасибо, получил.",
+			"20.05.2024 10:15, Иван Петров пишет:",
+			"> Отправляю отчет.",
+		), "Спасибо, получил."},
+		{"строки с >", lines(
+			"> Когда будет готово?",
+			"Завтра к обеду.",
+			"> Спасибо!",
+		), "Завтра к обеду."},
+		{"HTML-разметка", lines(
+			"<b>Готово.</b>",
+			"<i>From:</i> ivan@example.com",
+			"<i>Sent:</i> Monday",
+			"Исходное письмо",
+		), "<b>Готово.</b>"},
+	}
+
+	c := newCleaner(t, Options{Quotes: true})
+	for _, tt := range tests {
+		if got := c.Clean(tt.body); got != tt.want {
+			t.Errorf("%s: Clean =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuotesKeepText(t *testing.T) {
+	c := newCleaner(t, Options{Quotes: true})
+	tests := []struct {
+		name string
+		body string
+	}{
+		// Письмо целиком из пересылаемого сообщения не обрезается
+		{"только пересылка", lines(
+			"-----Original Message-----",
+			"From: ivan@example.com",
+			"Sent: Monday",
+			"Отчет во вложении",
+		)},
+		// "От:" без следующих полей заголовка - обычный текст
+		{"От: в тексте", lines(
+			"Новая заявка.",
+			"От: отдела продаж, срочно",
+			"Сумма: 1000 руб.",
+		)},
+	}
+	for _, tt := range tests {
+		if got := c.Clean(tt.body); got != tt.body {
+			t.Errorf("%s: Clean =\n%s\nwant без изменений", tt.name, got)
+		}
+	}
+}
+
+func TestSignatures(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"разделитель --", lines(
+			"Сервер перезагружен.",
+			"-- ",
+			"Иван Петров",
+			"+7 495 123-45-67",
+		), "Сервер перезагружен."},
+		{"С уважением", lines(
+			"Счет оплачен.",
+			"",
+			"С уважением,",
+			"Иван Петров",
+			"Бухгалтерия",
+		), "Счет оплачен."},
+		{"Best regards", lines(
+			"Invoice attached.",
+			"Best regards",
+			"John",
+		), "Invoice attached."},
+		{"Отправлено с iPhone", lines(
+			"Буду через 10 минут.",
+			"",
+			"Отправлено с iPhone",
+		), "Буду через 10 минут."},
+		{"Get Outlook", lines(
+			"Ok",
+			"Get Outlook for Android",
+		), "Ok"},
+		{"только подпись", lines(
+			"С уважением,",
+			"Иван",
+		), lines(
+			"С уважением,",
+			"Иван",
+		)},
+	}
+
+	c := newCleaner(t, Options{Signatures: true})
+	for _, tt := range tests {
+		if got := c.Clean(tt.body); got != tt.want {
+			t.Errorf("%s: Clean =\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSignatureOnlyAtEnd(t *testing.T) {
+	// "Спасибо" в начале длинного письма - не подпись
+	body := []string{"Спасибо"}
+	for i := 0; i < signatureWindow+2; i++ {
+		body = append(body, "строка отчета")
+	}
+	c := newCleaner(t, Options{Signatures: true})
+	if got := c.Clean(lines(body...)); got != lines(body...) {
+		t.Errorf("письмо обрезано:\n%s", got)
+	}
+}
+
+func TestDisclaimers(t *testing.T) {
+	c := newCleaner(t, Options{Disclaimers: []string{
+		`(?is)Данное сообщение и любые приложения.*$`,
+		`(?i)This e-mail is confidential[^\n]*`,
+	}})
+	body := lines(
+		"Отчет во вложении.",
+		"This e-mail is confidential and intended solely for the addressee.",
+		"",
+		"Данное сообщение и любые приложения к нему",
+		"предназначены только для адресата.",
+	)
+	if got := c.Clean(body); got != "Отчет во вложении." {
+		t.Errorf("Clean =\n%s", got)
+	}
+
+	if _, err := New(Options{Disclaimers: []string{"("}}); err == nil {
+		t.Error("некорректное выражение должно быть ошибкой")
+	}
+}
+
+func TestAllOptions(t *testing.T) {
+	c := newCleaner(t, Options{Quotes: true, Signatures: true})
+	body := "Готово.\r\n\r\nС уважением,\r\nИван\r\n\r\n-----Original Message-----\r\nFrom: ops@example.com\r\nСделайте, пожалуйста."
+	if got := c.Clean(body); got != "Готово." {
+		t.Errorf("Clean = %q", got)
+	}
+
+	var nilCleaner *Cleaner
+	if got := nilCleaner.Clean(body); got != body {
+		t.Error("nil Cleaner не должен менять текст")
+	}
+}
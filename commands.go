@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+
+	"otn/notify"
+)
+
+const commandsHelp = `<b>Команды:</b>
+/status — состояние программы
+/pause [папка] — приостановить пересылку (всю или из папки)
+/resume [папка] — возобновить пересылку
+/folders — отслеживаемые папки и число непрочитанных писем
+/mute &lt;папка&gt; &lt;время&gt; — не присылать уведомления из папки, например /mute Zabbix 2h (0 — включить обратно)
+/test — отправить тестовое уведомление во все чаты и каналы`
+
+// Обработка команды оператора
+func handleCommand(msg *tgMessage) {
+	fields := strings.Fields(msg.Text)
+	command := strings.ToLower(fields[0])
+	// В группах команда может быть вида /status@bot_name
+	if idx := strings.Index(command, "@"); idx > 0 {
+		command = command[:idx]
+	}
+	args := strings.TrimSpace(strings.TrimPrefix(msg.Text, fields[0]))
+
+	logMessage("Команда %s от %s", command, userDisplayName(*msg.From))
+
+	var reply string
+	switch command {
+	case "/start", "/help":
+		reply = commandsHelp
+	case "/status":
+		reply = commandStatus()
+	case "/pause":
+		reply = commandPause(args, true)
+	case "/resume":
+		reply = commandPause(args, false)
+	case "/folders":
+		reply = commandFolders()
+	case "/mute":
+		reply = commandMute(args)
+	case "/test":
+		reply = commandTest()
+	default:
+		reply = "Неизвестная команда\n\n" + commandsHelp
+	}
+
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	if _, err := sendTelegramReply(reply, chatID, msg.MessageID); err != nil {
+		logMessage("Ошибка ответа на команду %s: %v", command, err)
+	}
+}
+
+func commandStatus() string {
+	s := stats.snapshot()
+	var msg strings.Builder
+
+	msg.WriteString("<b>Состояние OTN</b>\n")
+	msg.WriteString("Работает: " + formatDuration(time.Since(s.StartTime)) + "\n")
+	if s.LastPoll.IsZero() {
+		msg.WriteString("Последняя проверка почты: еще не было\n")
+	} else {
+		msg.WriteString("Последняя проверка почты: " + s.LastPoll.Format("15:04:05 02.01.2006") +
+			" (" + formatDuration(time.Since(s.LastPoll)) + " назад)\n")
+	}
+	msg.WriteString(fmt.Sprintf("Отправлено уведомлений: %d\n", s.Forwarded))
+	msg.WriteString(fmt.Sprintf("Ошибок отправки: %d\n", s.SendFailures))
+	msg.WriteString(fmt.Sprintf("В очереди на отправку: %d\n", queueDepth()))
+	msg.WriteString(fmt.Sprintf("Ошибок Outlook: %d\n", s.PollFailures))
+	if s.LastError != "" {
+		msg.WriteString("Последняя ошибка (" + s.LastErrorTime.Format("15:04:05 02.01") + "): " +
+			html.EscapeString(truncateByRunes(s.LastError, 300)) + "\n")
+	}
+
+	if len(s.Sources) > 0 {
+		msg.WriteString("\n<b>Источники:</b>\n")
+		for _, src := range configuredSources {
+			msg.WriteString(formatSourceStatus(src.name, s.Sources[src.name]) + "\n")
+		}
+	}
+
+	if state.isPaused("") {
+		msg.WriteString("\n⏸ Пересылка приостановлена\n")
+	}
+	for _, folder := range config.Folders {
+		paused, mutedUntil := state.folderStatus(folder.label())
+		switch {
+		case paused:
+			msg.WriteString("⏸ " + html.EscapeString(folder.label()) + ": приостановлена\n")
+		case time.Now().Before(mutedUntil):
+			msg.WriteString("🔕 " + html.EscapeString(folder.label()) + ": без уведомлений до " +
+				mutedUntil.Format("15:04 02.01") + "\n")
+		}
+	}
+
+	return msg.String()
+}
+
+// Строка о состоянии источника писем для /status
+func formatSourceStatus(name string, src sourceStats) string {
+	var line string
+	switch src.Status {
+	case sourceRunning:
+		line = "🟢 " + html.EscapeString(name) + ": работает"
+	case sourceRestarting:
+		line = "🔴 " + html.EscapeString(name) + ": перезапуск"
+	case sourceDisabled:
+		return "⚪ " + html.EscapeString(name) + ": выключен"
+	default:
+		line = "⚪ " + html.EscapeString(name) + ": остановлен"
+	}
+	line += fmt.Sprintf(", отправлено %d, ошибок отправки %d", src.Forwarded, src.SendFailures)
+	if src.Restarts > 0 {
+		line += fmt.Sprintf(", перезапусков %d", src.Restarts)
+	}
+	if src.LastError != "" {
+		line += "\n    Последняя ошибка (" + src.LastErrorTime.Format("15:04:05 02.01") + "): " +
+			html.EscapeString(truncateByRunes(src.LastError, 200))
+	}
+	return line
+}
+
+func commandPause(folder string, paused bool) string {
+	if folder != "" {
+		if _, ok := findFolderConfig(folder); !ok {
+			return "Папка не найдена: " + html.EscapeString(folder)
+		}
+	}
+
+	state.setPaused(folder, paused)
+
+	target := "всех папок"
+	if folder != "" {
+		target = "папки " + html.EscapeString(folder)
+	}
+	if paused {
+		logMessage("Пересылка %s приостановлена", target)
+		return "⏸ Пересылка " + target + " приостановлена"
+	}
+	logMessage("Пересылка %s возобновлена", target)
+	return "▶️ Пересылка " + target + " возобновлена"
+}
+
+func commandMute(args string) string {
+	// Имя папки может содержать пробелы, поэтому длительность - последнее слово
+	idx := strings.LastIndex(args, " ")
+	if idx < 0 {
+		return "Использование: /mute &lt;папка&gt; &lt;время&gt;, например /mute Zabbix 2h"
+	}
+	folder, durationText := strings.TrimSpace(args[:idx]), args[idx+1:]
+
+	if _, ok := findFolderConfig(folder); !ok {
+		return "Папка не найдена: " + html.EscapeString(folder)
+	}
+
+	duration, err := parseDuration(durationText)
+	if err != nil {
+		return "Некорректное время: " + html.EscapeString(durationText) + " (примеры: 30m, 2h, 1d)"
+	}
+
+	if duration == 0 {
+		state.setMuted(folder, time.Time{})
+		logMessage("Уведомления из папки %s включены", folder)
+		return "🔔 Уведомления из папки " + html.EscapeString(folder) + " включены"
+	}
+
+	until := time.Now().Add(duration)
+	state.setMuted(folder, until)
+	logMessage("Уведомления из папки %s отключены до %s", folder, until.Format("15:04 02.01.2006"))
+	return "🔕 Уведомления из папки " + html.EscapeString(folder) + " отключены до " + until.Format("15:04 02.01.2006")
+}
+
+func commandFolders() string {
+	var msg strings.Builder
+	msg.WriteString("<b>Отслеживаемые папки:</b>\n")
+
+	// Число непрочитанных берем из Outlook, только если он отслеживается
+	unread := make(map[string]int)
+	outlook := outlookEnabled()
+	var err error
+	if outlook {
+		err = withOutlook(func(ns *ole.IDispatch) error {
+			folders := getTargetFolders(ns)
+			for name, folder := range folders {
+				if v, err := oleutil.GetProperty(folder, "UnReadItemCount"); err == nil {
+					unread[name] = int(v.Val)
+				}
+				folder.Release()
+			}
+			return nil
+		})
+	}
+
+	for _, folder := range config.Folders {
+		chatID := folder.ChatID
+		if chatID == "" {
+			chatID = config.Telegram.DefaultChatID
+		}
+		line := "• " + html.EscapeString(folder.label())
+		if folder.Title != "" {
+			line += " (" + html.EscapeString(folder.Name) + ")"
+		}
+		var targets []string
+		for _, route := range folder.routes() {
+			if route == telegramRoute {
+				route = chatID
+			}
+			targets = append(targets, html.EscapeString(route))
+		}
+		line += " → " + strings.Join(targets, ", ")
+		if folder.IMAPAccount != "" {
+			line += ", IMAP " + html.EscapeString(folder.IMAPAccount)
+		} else if folder.GraphAccount != "" {
+			line += ", Graph " + html.EscapeString(folder.GraphAccount)
+		} else if folder.DropDir != "" {
+			line += ", файлы " + html.EscapeString(folder.DropDir)
+		} else if count, ok := unread[folder.Name]; ok {
+			// Для recursive: true учитываем подпапки
+			subfolders := 0
+			if folder.Recursive {
+				for name, n := range unread {
+					if strings.HasPrefix(name, folder.Name+"/") {
+						count += n
+						subfolders++
+					}
+				}
+			}
+			line += fmt.Sprintf(", не прочитано: %d", count)
+			if subfolders > 0 {
+				line += fmt.Sprintf(" (подпапок: %d)", subfolders)
+			}
+		} else if outlook && err == nil {
+			line += ", папка не найдена"
+		}
+		msg.WriteString(line + "\n")
+	}
+
+	if err != nil {
+		msg.WriteString("\nНе удалось получить данные из Outlook: " + html.EscapeString(err.Error()))
+	}
+	return msg.String()
+}
+
+func commandTest() string {
+	chats := map[string]bool{config.Telegram.DefaultChatID: true}
+	for _, folder := range config.Folders {
+		if folder.ChatID != "" {
+			chats[folder.ChatID] = true
+		}
+	}
+
+	ids := make([]string, 0, len(chats))
+	for chatID := range chats {
+		ids = append(ids, chatID)
+	}
+	sort.Strings(ids)
+
+	text := "Тестовое уведомление OTN"
+	if config.Telegram.UseEmojis {
+		text = "🧪 " + text
+	}
+
+	var result strings.Builder
+	result.WriteString("<b>Результат теста:</b>\n")
+	for _, chatID := range ids {
+		if _, err := sendTelegramMessage(text, chatID); err != nil {
+			result.WriteString("❌ " + html.EscapeString(chatID) + ": " + html.EscapeString(truncateByRunes(err.Error(), 200)) + "\n")
+		} else {
+			result.WriteString("✅ " + html.EscapeString(chatID) + "\n")
+		}
+	}
+
+	notification := notify.Notification{
+		ID:         "test",
+		Folder:     "OTN",
+		SenderName: "OTN",
+		Subject:    "Тестовое уведомление OTN",
+		Received:   time.Now(),
+	}
+	for _, channel := range config.Channels {
+		if err := sendToChannel(context.Background(), channel.Name, notification); err != nil {
+			result.WriteString("❌ " + html.EscapeString(channel.Name) + ": " + html.EscapeString(truncateByRunes(err.Error(), 200)) + "\n")
+		} else {
+			result.WriteString("✅ " + html.EscapeString(channel.Name) + "\n")
+		}
+	}
+	return result.String()
+}
+
+// Разбирает длительность: 30m, 2h, 1h30m, а также дни - 1d
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "0" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("некорректное число дней: %s", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("некорректная длительность: %s", s)
+	}
+	return d, nil
+}
+
+// Форматирует длительность для людей: "2д 3ч 15м"
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dд %dч %dм", days, hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dч %dм", hours, minutes)
+	default:
+		return fmt.Sprintf("%dм", minutes)
+	}
+}
@@ -0,0 +1,162 @@
+// Пакет cron разбирает расписания в формате cron из пяти полей
+// (минута, час, день месяца, месяц, день недели) и проверяет,
+// попадает ли момент времени в расписание.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule - разобранное расписание. Каждое поле хранит набор допустимых значений.
+type Schedule struct {
+	minute  map[int]bool
+	hour    map[int]bool
+	dom     map[int]bool
+	month   map[int]bool
+	dow     map[int]bool
+	domStar bool
+	dowStar bool
+}
+
+// Синонимы для часто используемых расписаний
+var aliases = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 1",
+	"@monthly": "0 0 1 * *",
+}
+
+// Parse разбирает строку расписания, например "0 9 * * 1-5" или "@daily".
+// Поддерживаются "*", списки "1,3,5", диапазоны "1-5" и шаги "*/15", "0-30/10".
+// День недели: 0-7, где 0 и 7 - воскресенье.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if alias, ok := aliases[strings.ToLower(spec)]; ok {
+		spec = alias
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("расписание должно состоять из 5 полей, получено %d: %q", len(fields), spec)
+	}
+
+	var (
+		s   Schedule
+		err error
+	)
+	if s.minute, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("минуты: %v", err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("часы: %v", err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("день месяца: %v", err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("месяц: %v", err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("день недели: %v", err)
+	}
+	// 7 - тоже воскресенье
+	if s.dow[7] {
+		s.dow[0] = true
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+
+	return &s, nil
+}
+
+// Matches сообщает, попадает ли минута t в расписание.
+func (s *Schedule) Matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[int(t.Weekday())]
+
+	// Как в классическом cron: если заданы оба поля дня, достаточно совпадения любого
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dowMatch
+	case s.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// Next возвращает ближайшую минуту строго после t, попадающую в расписание.
+// Если такой минуты нет в течение 5 лет, возвращается нулевое время.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.Matches(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+func parseField(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		if part == "" {
+			return nil, fmt.Errorf("пустой элемент в %q", field)
+		}
+
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("некорректный шаг в %q", part)
+			}
+			step = n
+			part = part[:idx]
+		}
+
+		lo, hi := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, errA := strconv.Atoi(bounds[0])
+			b, errB := strconv.Atoi(bounds[1])
+			if errA != nil || errB != nil {
+				return nil, fmt.Errorf("некорректный диапазон %q", part)
+			}
+			lo, hi = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("некорректное значение %q", part)
+			}
+			lo, hi = n, n
+			// "5/10" означает "с 5 до конца с шагом 10"
+			if step > 1 {
+				hi = max
+			}
+		}
+
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("значение %q вне диапазона %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+
+	return values, nil
+}
@@ -0,0 +1,117 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"1-x * * * *",
+		"@yearly",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): ожидалась ошибка", spec)
+		}
+	}
+}
+
+// Понедельник, 20 мая 2024
+func at(day, hour, minute int) time.Time {
+	return time.Date(2024, time.May, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", at(20, 10, 7).Add(30 * time.Second), at(20, 10, 8)},
+
+		// Шаги
+		{"*/15 * * * *", at(20, 10, 7), at(20, 10, 15)},
+		{"*/15 * * * *", at(20, 10, 15), at(20, 10, 30)},
+		{"*/15 * * * *", at(20, 10, 50), at(20, 11, 0)},
+		{"5/20 * * * *", at(20, 10, 7), at(20, 10, 25)},
+		{"0-30/10 * * * *", at(20, 10, 25), at(20, 10, 30)},
+		{"0-30/10 * * * *", at(20, 10, 31), at(20, 11, 0)},
+
+		// Списки и диапазоны
+		{"0 8,12,18 * * *", at(20, 12, 0), at(20, 18, 0)},
+		{"0 8,12,18 * * *", at(20, 19, 0), at(21, 8, 0)},
+		{"0 9 * * 1-5", at(24, 10, 0), at(27, 9, 0)},
+		{"30 9-11 * * *", at(20, 11, 30), at(21, 9, 30)},
+
+		// День недели: 0 и 7 - воскресенье
+		{"0 0 * * 0", at(20, 0, 0), at(26, 0, 0)},
+		{"0 0 * * 7", at(20, 0, 0), at(26, 0, 0)},
+
+		// День месяца: в июне нет 31 числа
+		{"0 0 31 * *", at(31, 0, 0), time.Date(2024, time.July, 31, 0, 0, 0, 0, time.UTC)},
+
+		// Синонимы
+		{"@daily", at(20, 10, 0), at(21, 0, 0)},
+		{"@WEEKLY", at(20, 10, 0), at(27, 0, 0)},
+		{"@monthly", at(20, 10, 0), time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.spec, err)
+		}
+		if got := s.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q: Next(%s) = %s, want %s", tt.spec, tt.from.Format(time.RFC3339), got.Format(time.RFC3339), tt.want.Format(time.RFC3339))
+		}
+	}
+}
+
+// Если заданы и день месяца, и день недели, достаточно совпадения любого из них
+func TestDayOfMonthOrWeek(t *testing.T) {
+	s, err := Parse("0 0 13 * 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 13 июня 2024 - четверг, 14 июня - пятница
+	from := time.Date(2024, time.June, 10, 0, 0, 0, 0, time.UTC)
+	want := []time.Time{
+		time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.June, 14, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.June, 21, 0, 0, 0, 0, time.UTC),
+	}
+	for _, w := range want {
+		from = s.Next(from)
+		if !from.Equal(w) {
+			t.Fatalf("Next = %s, want %s", from.Format(time.RFC3339), w.Format(time.RFC3339))
+		}
+	}
+
+	if s.Matches(time.Date(2024, time.June, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Error("среда 12 июня не должна совпадать")
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(at(20, 0, 0)); !got.IsZero() {
+		t.Errorf("30 февраля: Next = %s, want нулевое время", got)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"otn/alerts"
+	"otn/mailsource"
+	"otn/notify"
+	"otn/outbox"
+)
+
+// Очередь исходящих уведомлений. Обработка письма только ставит уведомления
+// в очередь, а отправляет их отдельная горутина: медленный прокси или
+// недоступный канал не задерживают опрос Outlook и других источников
+var outboxQueue *outbox.Queue
+
+// Пауза перед повторной отправкой: удваивается после каждой неудачи до outboxRetryMax
+const (
+	outboxRetryMin = 5 * time.Second
+	outboxRetryMax = 5 * time.Minute
+)
+
+// Сколько часов уведомление может ждать в очереди по умолчанию
+const defaultQueueMaxAgeHours = 24
+
+// Уведомление в очереди: доставка письма по одному маршруту папки
+type queuedNotification struct {
+	Route   string `json:"route"` // telegram или имя канала из channels
+	Source  string `json:"source"`
+	Folder  string `json:"folder"`
+	Subject string `json:"subject"`
+
+	// Маршрут telegram
+	Message        *telegramMessage `json:"message,omitempty"`
+	ConversationID string           `json:"conversation_id,omitempty"`
+	AlertKey       string           `json:"alert_key,omitempty"`    // Ключ корреляции оповещения
+	AlertStatus    string           `json:"alert_status,omitempty"` // PROBLEM или RESOLVED
+	Correlate      string           `json:"correlate,omitempty"`
+	Remember       *NotifiedMail    `json:"remember,omitempty"` // Письмо для кнопок и ответов на уведомление
+
+	// Остальные каналы
+	Notification *notify.Notification `json:"notification,omitempty"`
+
+	History   *HistoryRecord   `json:"history,omitempty"`    // Запись в историю после доставки
+	AfterSend *afterSendAction `json:"after_send,omitempty"` // Действие с письмом после доставки
+	MailID    string           `json:"mail_id,omitempty"`    // Идентификатор письма в источнике
+}
+
+// Получатель уведомления: уведомления одному получателю отправляются по порядку.
+// Каналы telegram основного бота - такой же чат, как основной маршрут: они
+// делят с ним очередь и ограничения частоты (см. reserveTelegramLimit)
+func (n queuedNotification) dest() string {
+	if n.Route == telegramRoute {
+		return "telegram:" + n.Message.ChatID
+	}
+	if chatID, ok := mainBotChannelChat(n.Route); ok {
+		return "telegram:" + chatID
+	}
+	return "channel:" + n.Route
+}
+
+// Получатель для журнала
+func (n queuedNotification) target() string {
+	if n.Route == telegramRoute {
+		return "Telegram"
+	}
+	return "канал " + n.Route
+}
+
+// Открывает очередь рядом с исполняемым файлом
+func initOutbox() error {
+	queue, err := outbox.Open(dataFilePath("outbox"), logMessage)
+	if err != nil {
+		return err
+	}
+	outboxQueue = queue
+	if n := queue.Len(); n > 0 {
+		logMessage("Уведомлений в очереди: %d", n)
+	}
+
+	// Письма, уведомления о которых еще в очереди, не ставим в нее повторно:
+	// список обработанных писем после перезапуска пуст
+	mutexMsg.Lock()
+	defer mutexMsg.Unlock()
+	for _, item := range queue.Items() {
+		var n queuedNotification
+		if json.Unmarshal(item.Data, &n) == nil && n.MailID != "" {
+			processedEmails[n.MailID] = true
+		}
+	}
+	return nil
+}
+
+// Ставит уведомления письма в очередь: либо все, либо ни одного
+func enqueueNotifications(items []queuedNotification) error {
+	entries := make([]outbox.Entry, 0, len(items))
+	for _, n := range items {
+		entries = append(entries, outbox.Entry{Dest: n.dest(), Data: n})
+	}
+	_, err := outboxQueue.Push(entries...)
+	return err
+}
+
+// Запускает отправку уведомлений из очереди. После паники отправка перезапускается
+func startOutbox(ctx context.Context) {
+	safeGo(func() {
+		for ctx.Err() == nil {
+			if err := runRecovered(ctx, runOutbox); err != nil {
+				logMessage("Очередь уведомлений: %v. Перезапуск через %v", err, outboxRetryMin)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(outboxRetryMin):
+			}
+		}
+	})
+}
+
+// Отправляет уведомления из очереди, пока не отменен ctx
+func runOutbox(ctx context.Context) error {
+	// Уведомления, для которых отправка в чат Telegram уже зарезервирована
+	reserved := make(map[string]bool)
+	for ctx.Err() == nil {
+		item, wait, ok := outboxQueue.Next(time.Now())
+		if ok {
+			// Резервируем отправку один раз. Если чат ждет лимита Telegram,
+			// откладываем уведомление до зарезервированного времени, не
+			// задерживая остальные чаты и каналы
+			if !reserved[item.ID] {
+				if delay := reserveTelegramLimit(item.Dest); delay > 0 {
+					reserved[item.ID] = true
+					outboxQueue.Postpone(item.ID, delay)
+					continue
+				}
+			}
+			delete(reserved, item.ID)
+			deliverItem(ctx, item)
+			continue
+		}
+
+		// Ждем нового уведомления или времени ближайшего повтора
+		var (
+			timer *time.Timer
+			retry <-chan time.Time
+		)
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			retry = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-outboxQueue.Wait():
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	return nil
+}
+
+// Отправляет элемент очереди. После временной ошибки элемент откладывается,
+// а слишком старый или отклоненный получателем переносится в *.failed
+func deliverItem(ctx context.Context, item outbox.Item) {
+	var n queuedNotification
+	if err := json.Unmarshal(item.Data, &n); err != nil || (n.Route == telegramRoute && n.Message == nil) ||
+		(n.Route != telegramRoute && n.Notification == nil) {
+		logMessage("Поврежденное уведомление в очереди %s удалено", item.ID)
+		outboxQueue.Done(item.ID)
+		return
+	}
+
+	err := deliverQueued(ctx, n)
+	if err == nil {
+		if err := outboxQueue.Done(item.ID); err != nil {
+			logMessage("Ошибка удаления уведомления из очереди: %v", err)
+		}
+		return
+	}
+
+	stats.sendFailed(n.Source, n.Folder, err)
+	if permanentDeliveryError(err) {
+		// Повтор не поможет, а уведомление задерживало бы все следующие в тот же чат или канал
+		logMessage("Ошибка отправки в %s: %v. Уведомление «%s» отклонено и перенесено в %s.failed",
+			n.target(), err, n.Subject, item.ID)
+		failItem(item.ID, err)
+		return
+	}
+
+	maxAge := time.Duration(config.QueueMaxAgeHours) * time.Hour
+	if maxAge <= 0 {
+		maxAge = defaultQueueMaxAgeHours * time.Hour
+	}
+	if time.Since(item.Created) > maxAge {
+		logMessage("Ошибка отправки в %s: %v. Уведомление «%s» не доставлено за %v и перенесено в %s.failed",
+			n.target(), err, n.Subject, maxAge, item.ID)
+		failItem(item.ID, err)
+		return
+	}
+
+	delay := outboxRetryMin
+	for i := 0; i < item.Attempts && delay < outboxRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > outboxRetryMax {
+		delay = outboxRetryMax
+	}
+	if wait := notify.RetryAfter(err); wait > delay {
+		// Канал попросил подождать дольше (Retry-After)
+		delay = wait
+	}
+	logMessage("Ошибка отправки в %s: %v. Повтор через %v", n.target(), err, delay)
+	if err := outboxQueue.Retry(item.ID, delay, err); err != nil {
+		logMessage("Ошибка записи в очередь: %v", err)
+	}
+}
+
+// Убирает недоставленное уведомление из очереди
+func failItem(id string, cause error) {
+	if err := outboxQueue.Fail(id, cause); err != nil {
+		logMessage("Ошибка записи в очередь: %v", err)
+		outboxQueue.Done(id)
+	}
+}
+
+// Ошибка, которая не изменится от повтора: Bot API или канал ответили 4xx,
+// кроме 429 (неверная разметка, слишком длинный текст, чат не найден).
+// Повторяются только сетевые ошибки, 429 и 5xx
+func permanentDeliveryError(err error) bool {
+	var statusErr *telegramStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Status >= 400 && statusErr.Status < 500 && statusErr.Status != http.StatusTooManyRequests
+	}
+	return notify.Permanent(err)
+}
+
+// Отправляет уведомление по его маршруту
+func deliverQueued(ctx context.Context, n queuedNotification) error {
+	if n.Route == telegramRoute {
+		var (
+			messageID int
+			err       error
+		)
+		msg := *n.Message
+		msg.Reserved = true // Отправку зарезервировал runOutbox
+		if n.AlertKey != "" {
+			messageID, err = sendCorrelated(msg, n.AlertKey, n.Correlate, n.AlertStatus)
+		} else {
+			messageID, err = sendThreaded(msg, n.ConversationID)
+		}
+		if err != nil {
+			return err
+		}
+		logMessage("Сообщение успешно отправлено в Telegram: %s", n.Subject)
+		newID := runAfterSend(n)
+		if n.Remember != nil {
+			// Запоминаем письмо, чтобы кнопки и ответы на уведомление могли найти его в Outlook
+			remember := *n.Remember
+			remember.Time = time.Now()
+			if newID != "" {
+				remember.EntryID = newID
+			}
+			state.rememberNotification(n.Message.ChatID, messageID, remember)
+		}
+		recordDelivered(n, newID)
+		return nil
+	}
+
+	if err := sendToChannel(ctx, n.Route, *n.Notification); err != nil {
+		return err
+	}
+	recordDelivered(n, runAfterSend(n))
+	return nil
+}
+
+// Письмо считается пересланным один раз - по уведомлению с записью в историю
+func recordDelivered(n queuedNotification, newID string) {
+	if n.History == nil {
+		return
+	}
+	stats.forwarded(n.Source, n.Folder)
+	rec := *n.History
+	rec.Time = time.Now()
+	if newID != "" {
+		rec.EntryID = newID
+	}
+	recordHistory(rec)
+}
+
+// Выполняет действие after_send после доставки уведомления. Ошибка действия
+// не делает уведомление недоставленным и только пишется в журнал. Возвращает
+// новый идентификатор письма, если оно перемещено
+func runAfterSend(n queuedNotification) string {
+	if n.AfterSend == nil || n.MailID == "" {
+		return ""
+	}
+	newID, err := actOnMail(n.Source, n.MailID, *n.AfterSend)
+	if err != nil {
+		logMessage("Ошибка действия after_send (%s) для папки %s: %v", n.AfterSend.Kind, n.Folder, err)
+		return ""
+	}
+	if newID != "" {
+		// Письмо перемещено: запоминаем новый идентификатор, чтобы не отправить его повторно
+		mutexMsg.Lock()
+		processedEmails[newID] = true
+		mutexMsg.Unlock()
+	}
+	return newID
+}
+
+// Выполняет действие над письмом id через его источник
+func actOnMail(source, id string, action afterSendAction) (string, error) {
+	actor, err := sourceActor(source)
+	if err != nil {
+		return "", err
+	}
+	var newID string
+	err = actor.Act(context.Background(), id, func(actions mailsource.Actions) error {
+		var actionErr error
+		newID, actionErr = applyAfterSend(actions, action)
+		return actionErr
+	})
+	return newID, err
+}
+
+// Число уведомлений в очереди
+func queueDepth() int {
+	if outboxQueue == nil {
+		return 0
+	}
+	return outboxQueue.Len()
+}
+
+// Заполняет корреляцию оповещения системы мониторинга: уведомление о решении
+// изменит уведомление о проблеме или будет отправлено ответом на него
+func setAlertCorrelation(n *queuedNotification, cfg *AlertParserConfig, alert *alerts.Alert, subject string) {
+	if cfg.Correlate == "" {
+		return
+	}
+	n.AlertKey = alertCorrelationKey(cfg, alert, subject)
+	n.AlertStatus = alert.Status
+	n.Correlate = cfg.Correlate
+}
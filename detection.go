@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// Способы обнаружения новых писем
+const (
+	detectionUnread       = "unread"        // Непрочитанные письма (по умолчанию)
+	detectionReceivedTime = "received_time" // Письма, полученные после сохраненной отметки времени
+)
+
+// Формат даты для фильтра Restrict. Outlook сравнивает время с точностью до минуты
+const restrictDateLayout = "01/02/2006 03:04 PM"
+
+func validateDetection(folder *Folder) error {
+	switch folder.Detection {
+	case "", detectionUnread:
+		if folder.StartFromNow {
+			return fmt.Errorf("start_from_now используется только с detection %q", detectionReceivedTime)
+		}
+	case detectionReceivedTime:
+	default:
+		return fmt.Errorf("неизвестный способ обнаружения %q (допустимы: %s, %s)", folder.Detection, detectionUnread, detectionReceivedTime)
+	}
+	return nil
+}
+
+// Обрабатывает письма, полученные после сохраненной отметки времени папки.
+// Отметка сдвигается только до последнего письма, уведомление о котором доставлено
+func processFolderByWatermark(folder *ole.IDispatch, folderName string, folderConfig Folder) {
+	cycleStart := time.Now()
+
+	watermark, seenIDs, ok := state.watermark(folderName)
+	if !ok {
+		// Первый запуск: либо начинаем с текущего момента, либо отправляем непрочитанные письма
+		if folderConfig.StartFromNow {
+			state.setWatermark(folderName, cycleStart, nil)
+			logMessage("Папка %s: отслеживаются письма, полученные после %s", folderName, cycleStart.Format("02.01.2006 15:04:05"))
+			return
+		}
+		if processUnread(folder, folderName) {
+			state.setWatermark(folderName, cycleStart, nil)
+		}
+		return
+	}
+
+	items := oleutil.MustCallMethod(folder, "Items").ToIDispatch()
+	defer items.Release()
+
+	filter := fmt.Sprintf("[ReceivedTime] >= '%s'", watermark.Truncate(time.Minute).Format(restrictDateLayout))
+	filtered := oleutil.MustCallMethod(items, "Restrict", filter).ToIDispatch()
+	defer filtered.Release()
+
+	// Обрабатываем письма по порядку получения, чтобы отметка не перескочила через неотправленное
+	oleutil.MustCallMethod(filtered, "Sort", "[ReceivedTime]")
+
+	newWatermark, newIDs, failed := watermark, seenIDs, false
+	for _, item := range collectItems(filtered) {
+		received, err := itemReceivedTime(item)
+		if err != nil {
+			logMessage("Ошибка получения ReceivedTime: %v", err)
+			item.Release()
+			continue
+		}
+		// Письмо с тем же временем, что и отметка, могло прийти после прошлой
+		// проверки: пропускаем только те, что уже обработаны (они в seenIDs)
+		entryID := oleutil.MustGetProperty(item, "EntryID").ToString()
+		if received.After(watermark) || (received.Equal(watermark) && !slices.Contains(seenIDs, entryID)) {
+			if !processEmail(item, folderName) {
+				failed = true
+			} else if !failed {
+				switch {
+				case received.After(newWatermark):
+					newWatermark, newIDs = received, []string{entryID}
+				case received.Equal(newWatermark):
+					newIDs = append(slices.Clone(newIDs), entryID)
+				}
+			}
+		}
+		item.Release()
+	}
+
+	if newWatermark.After(watermark) || len(newIDs) != len(seenIDs) {
+		state.setWatermark(folderName, newWatermark, newIDs)
+	}
+}
+
+// Возвращает время получения письма в локальном часовом поясе
+func itemReceivedTime(item *ole.IDispatch) (time.Time, error) {
+	v, err := oleutil.GetProperty(item, "ReceivedTime")
+	if err != nil {
+		return time.Time{}, err
+	}
+	t, ok := v.Value().(time.Time)
+	if !ok {
+		return time.Time{}, fmt.Errorf("неожиданный тип ReceivedTime")
+	}
+	// go-ole возвращает локальное время Outlook с пометкой UTC
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.Local), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+)
+
+// Через сколько папки находятся заново, даже если кэш исправен
+// (чтобы подхватить новые подпапки для recursive: true и папки, не найденные ранее)
+const folderCacheTTL = 15 * time.Minute
+
+// Идентификаторы найденной папки для быстрого получения через GetFolderFromID
+type folderID struct {
+	EntryID string
+	StoreID string
+}
+
+// Кэш найденных папок между циклами проверки. Используется только из mainLogic
+type folderCache struct {
+	fingerprint string
+	resolvedAt  time.Time
+	folders     map[string]folderID
+}
+
+var targetFolderCache folderCache
+
+// Возвращает отслеживаемые папки: из кэша по EntryID/StoreID, а если кэш устарел,
+// изменилась конфигурация или папка недоступна - поиском заново
+func (c *folderCache) get(ns *ole.IDispatch) (map[string]*ole.IDispatch, bool) {
+	fingerprint := foldersFingerprint()
+	if c.fingerprint == fingerprint && len(c.folders) > 0 && time.Since(c.resolvedAt) < folderCacheTTL {
+		if folders, ok := c.open(ns); ok {
+			return folders, true
+		}
+	}
+
+	folders := getTargetFolders(ns)
+
+	// Кэш действителен, только если в нем все найденные папки: иначе папка без
+	// EntryID/StoreID перестала бы проверяться до истечения folderCacheTTL
+	c.fingerprint = ""
+	c.folders = make(map[string]folderID, len(folders))
+	for name, folder := range folders {
+		entryID, err1 := oleutil.GetProperty(folder, "EntryID")
+		storeID, err2 := oleutil.GetProperty(folder, "StoreID")
+		if err1 != nil || err2 != nil {
+			logDebug("Не удалось получить EntryID/StoreID папки %s, папки не кэшируются", name)
+			c.folders = nil
+			return folders, false
+		}
+		c.folders[name] = folderID{EntryID: entryID.ToString(), StoreID: storeID.ToString()}
+	}
+	c.fingerprint = fingerprint
+	c.resolvedAt = time.Now()
+	return folders, false
+}
+
+// Открывает папки из кэша. При первой ошибке освобождает уже открытые и возвращает false
+func (c *folderCache) open(ns *ole.IDispatch) (map[string]*ole.IDispatch, bool) {
+	folders := make(map[string]*ole.IDispatch, len(c.folders))
+	for name, id := range c.folders {
+		folderVar, err := oleutil.CallMethod(ns, "GetFolderFromID", id.EntryID, id.StoreID)
+		if err != nil {
+			logDebug("Папка %s недоступна по EntryID, поиск папок заново: %v", name, err)
+			for _, folder := range folders {
+				folder.Release()
+			}
+			return nil, false
+		}
+		folders[name] = folderVar.ToIDispatch()
+	}
+	return folders, true
+}
+
+// Отпечаток настроек, влияющих на поиск папок
+func foldersFingerprint() string {
+	type folderRef struct {
+		Name      string
+		EntryID   string
+		StoreID   string
+		Recursive bool
+		Include   []string
+		Exclude   []string
+	}
+
+	refs := make([]folderRef, len(config.Folders))
+	for i, f := range config.Folders {
+		refs[i] = folderRef{f.Name, f.EntryID, f.StoreID, f.Recursive, f.Include, f.Exclude}
+	}
+	data, _ := json.Marshal(refs)
+	return string(data)
+}
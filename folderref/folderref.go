@@ -0,0 +1,352 @@
+// Пакет folderref разбирает ссылки на папки почтового ящика и находит
+// папки в дереве хранилищ. Поддерживаются полные пути ("Ящик/Входящие/Zabbix"
+// или "\\Ящик\Входящие\Zabbix" в формате FolderPath Outlook), имена
+// хранилищ, специальные папки (@inbox, @sent, ...) и поиск по имени.
+// Дерево папок описывается интерфейсами, поэтому логика не зависит от COM.
+package folderref
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Folder - папка почтового ящика
+type Folder interface {
+	Name() string
+	Subfolders() ([]Folder, error)
+	// Release освобождает папку, если она не возвращается вызывающему коду
+	Release()
+}
+
+// Store - хранилище (почтовый ящик или файл данных)
+type Store interface {
+	DisplayName() string
+	Root() (Folder, error)
+	// DefaultFolder возвращает специальную папку хранилища по номеру OlDefaultFolders
+	DefaultFolder(id int) (Folder, error)
+	Release()
+}
+
+// Tree - все хранилища профиля
+type Tree interface {
+	Stores() ([]Store, error)
+	// DefaultFolder возвращает специальную папку хранилища по умолчанию
+	DefaultFolder(id int) (Folder, error)
+}
+
+// Номера специальных папок (OlDefaultFolders)
+var wellKnown = map[string]int{
+	"deleted": 3,
+	"outbox":  4,
+	"sent":    5,
+	"inbox":   6,
+	"drafts":  16,
+	"junk":    23,
+}
+
+// Имена, которые исторически означали папку «Входящие» хранилища по умолчанию
+var legacyAliases = map[string]string{
+	"Входящие": "@inbox",
+}
+
+// Ref - разобранная ссылка на папку
+type Ref struct {
+	Parts []string // Компоненты пути; специальная папка - компонент с префиксом "@"
+	Path  bool     // Ссылка задана путем, а не одним именем
+}
+
+// Parse разбирает ссылку на папку. Разделитель пути - "/" (символ "/" в имени
+// экранируется как "\/") или "\", если ссылка начинается с "\\" как FolderPath Outlook.
+func Parse(ref string) (Ref, error) {
+	ref = strings.TrimSpace(ref)
+	if alias, ok := legacyAliases[ref]; ok {
+		ref = alias
+	}
+
+	var parts []string
+	if strings.HasPrefix(ref, `\\`) {
+		parts = strings.Split(strings.TrimPrefix(ref, `\\`), `\`)
+	} else {
+		parts = splitEscaped(strings.TrimPrefix(ref, "/"))
+	}
+
+	for i, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			return Ref{}, fmt.Errorf("пустой компонент пути в %q", ref)
+		}
+		if strings.HasPrefix(part, "@") {
+			if _, ok := wellKnown[strings.ToLower(part[1:])]; !ok {
+				return Ref{}, fmt.Errorf("неизвестная специальная папка %s (допустимы: %s)", part, wellKnownList())
+			}
+			part = strings.ToLower(part)
+		}
+		parts[i] = part
+	}
+
+	return Ref{Parts: parts, Path: len(parts) > 1 || strings.HasPrefix(parts[0], "@")}, nil
+}
+
+// Делит строку по "/" с учетом экранирования "\/"
+func splitEscaped(s string) []string {
+	var (
+		parts   []string
+		current strings.Builder
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '/':
+			current.WriteByte('/')
+			i++
+		case s[i] == '/':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(s[i])
+		}
+	}
+	return append(parts, current.String())
+}
+
+func wellKnownList() string {
+	names := []string{"@inbox", "@sent", "@drafts", "@deleted", "@outbox", "@junk"}
+	return strings.Join(names, ", ")
+}
+
+// Resolve находит папку по ссылке. Правила:
+//   - "@inbox/Zabbix" - специальная папка хранилища по умолчанию и путь внутри нее;
+//   - "Ящик/Входящие/Zabbix" или "Ящик/@inbox/Zabbix" - путь от корня хранилища с таким именем;
+//   - "Входящие/Zabbix" - путь от корня любого хранилища, если он найден ровно в одном;
+//   - "Zabbix" - поиск по имени во всех хранилищах, имя должно быть уникальным.
+//
+// Имена сравниваются без учета регистра.
+func Resolve(tree Tree, ref string) (Folder, error) {
+	r, err := Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	first := r.Parts[0]
+	if strings.HasPrefix(first, "@") {
+		start, err := tree.DefaultFolder(wellKnown[first[1:]])
+		if err != nil {
+			return nil, fmt.Errorf("специальная папка %s не найдена: %v", first, err)
+		}
+		return descend(start, r.Parts[1:])
+	}
+
+	stores, err := tree.Stores()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, s := range stores {
+			s.Release()
+		}
+	}()
+
+	// Путь, начинающийся с имени хранилища
+	if r.Path {
+		for _, store := range stores {
+			if !strings.EqualFold(store.DisplayName(), first) {
+				continue
+			}
+			rest := r.Parts[1:]
+			var start Folder
+			if len(rest) > 0 && strings.HasPrefix(rest[0], "@") {
+				start, err = store.DefaultFolder(wellKnown[rest[0][1:]])
+				rest = rest[1:]
+			} else {
+				start, err = store.Root()
+			}
+			if err != nil {
+				return nil, fmt.Errorf("папка не найдена в хранилище %s: %v", store.DisplayName(), err)
+			}
+			return descend(start, rest)
+		}
+	}
+
+	// Путь или имя без хранилища: должно быть ровно одно совпадение
+	var matches []match
+	for _, store := range stores {
+		root, err := store.Root()
+		if err != nil {
+			continue
+		}
+		if r.Path {
+			if folder, err := descend(root, r.Parts); err == nil {
+				matches = append(matches, match{folder, store.DisplayName() + "/" + strings.Join(r.Parts, "/")})
+			}
+		} else {
+			found, err := findByName(root, first, store.DisplayName())
+			root.Release()
+			if err != nil {
+				releaseMatches(matches)
+				return nil, err
+			}
+			matches = append(matches, found...)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("папка '%s' не найдена", ref)
+	case 1:
+		return matches[0].folder, nil
+	}
+
+	paths := make([]string, len(matches))
+	for i, m := range matches {
+		paths[i] = m.path
+	}
+	releaseMatches(matches)
+	return nil, fmt.Errorf("папка '%s' найдена в нескольких местах, укажите полный путь: %s", ref, strings.Join(paths, ", "))
+}
+
+type match struct {
+	folder Folder
+	path   string
+}
+
+func releaseMatches(matches []match) {
+	for _, m := range matches {
+		m.folder.Release()
+	}
+}
+
+// Спускается от папки по компонентам пути. Исходная папка освобождается,
+// если возвращается не она
+func descend(start Folder, parts []string) (Folder, error) {
+	current := start
+	for _, part := range parts {
+		subfolders, err := current.Subfolders()
+		if err != nil {
+			current.Release()
+			return nil, err
+		}
+
+		var next Folder
+		for _, sub := range subfolders {
+			if next == nil && strings.EqualFold(sub.Name(), part) {
+				next = sub
+			} else {
+				sub.Release()
+			}
+		}
+		if next == nil {
+			name := current.Name()
+			current.Release()
+			return nil, fmt.Errorf("в папке '%s' нет подпапки '%s'", name, part)
+		}
+		current.Release()
+		current = next
+	}
+	return current, nil
+}
+
+// Рекурсивно ищет все папки с указанным именем
+func findByName(parent Folder, name, path string) ([]match, error) {
+	subfolders, err := parent.Subfolders()
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []match
+	for _, sub := range subfolders {
+		subPath := path + "/" + sub.Name()
+		found, err := findByName(sub, name, subPath)
+		if err != nil {
+			sub.Release()
+			releaseMatches(matches)
+			return nil, err
+		}
+		matches = append(matches, found...)
+
+		if strings.EqualFold(sub.Name(), name) {
+			matches = append(matches, match{sub, subPath})
+		} else {
+			sub.Release()
+		}
+	}
+	return matches, nil
+}
+
+// Filter отбирает подпапки по именам. Шаблоны - glob (path.Match): "*", "?", "[...]"
+type Filter struct {
+	Include []string // Подпапки, которые нужно отслеживать (пусто - все)
+	Exclude []string // Подпапки, которые нужно пропустить вместе с их содержимым
+}
+
+// Validate проверяет синтаксис шаблонов
+func (f Filter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("некорректный шаблон %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Entry - найденная папка и ее путь для отображения
+type Entry struct {
+	Folder Folder
+	Path   string
+}
+
+// Subtree возвращает папку root (под именем rootPath) и все ее подпапки, отобранные фильтром.
+// Исключенная подпапка пропускается вместе с вложенными; подпапки, не попавшие
+// в Include, не отслеживаются, но их вложенные папки проверяются.
+// Все возвращенные папки должен освободить вызывающий код.
+func Subtree(root Folder, rootPath string, filter Filter) ([]Entry, error) {
+	entries := []Entry{{Folder: root, Path: rootPath}}
+	if err := collect(root, rootPath, filter, &entries); err != nil {
+		for _, e := range entries[1:] {
+			e.Folder.Release()
+		}
+		return nil, err
+	}
+	return entries, nil
+}
+
+func collect(parent Folder, parentPath string, filter Filter, entries *[]Entry) error {
+	subfolders, err := parent.Subfolders()
+	if err != nil {
+		return err
+	}
+
+	for i, sub := range subfolders {
+		name := sub.Name()
+		if matchAny(filter.Exclude, name) {
+			sub.Release()
+			continue
+		}
+
+		subPath := parentPath + "/" + name
+		included := len(filter.Include) == 0 || matchAny(filter.Include, name)
+		if included {
+			*entries = append(*entries, Entry{Folder: sub, Path: subPath})
+		}
+
+		err := collect(sub, subPath, filter, entries)
+		if !included {
+			sub.Release()
+		}
+		if err != nil {
+			for _, rest := range subfolders[i+1:] {
+				rest.Release()
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package folderref
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Узел поддельного дерева папок
+type node struct {
+	name     string
+	children []*node
+}
+
+func dir(name string, children ...*node) *node {
+	return &node{name: name, children: children}
+}
+
+type fakeStore struct {
+	name     string
+	root     *node
+	defaults map[int]*node
+}
+
+// Поддельное дерево считает открытые объекты, чтобы проверить освобождение
+type fakeTree struct {
+	stores []*fakeStore
+	open   int
+}
+
+type folderHandle struct {
+	n    *node
+	tree *fakeTree
+}
+
+func (h *folderHandle) Name() string { return h.n.name }
+func (h *folderHandle) Release()     { h.tree.open-- }
+
+func (h *folderHandle) Subfolders() ([]Folder, error) {
+	var result []Folder
+	for _, child := range h.n.children {
+		result = append(result, h.tree.handle(child))
+	}
+	return result, nil
+}
+
+type storeHandle struct {
+	s    *fakeStore
+	tree *fakeTree
+}
+
+func (h *storeHandle) DisplayName() string   { return h.s.name }
+func (h *storeHandle) Release()              { h.tree.open-- }
+func (h *storeHandle) Root() (Folder, error) { return h.tree.handle(h.s.root), nil }
+
+func (h *storeHandle) DefaultFolder(id int) (Folder, error) {
+	n, ok := h.s.defaults[id]
+	if !ok {
+		return nil, errors.New("нет такой папки")
+	}
+	return h.tree.handle(n), nil
+}
+
+func (t *fakeTree) handle(n *node) *folderHandle {
+	t.open++
+	return &folderHandle{n: n, tree: t}
+}
+
+func (t *fakeTree) Stores() ([]Store, error) {
+	var result []Store
+	for _, s := range t.stores {
+		t.open++
+		result = append(result, &storeHandle{s: s, tree: t})
+	}
+	return result, nil
+}
+
+func (t *fakeTree) DefaultFolder(id int) (Folder, error) {
+	h := &storeHandle{s: t.stores[0], tree: t}
+	return h.DefaultFolder(id)
+}
+
+func newTree() *fakeTree {
+	personalInbox := dir("Входящие", dir("Zabbix"), dir("Отчеты"))
+	personalSent := dir("Отправленные")
+	personal := &fakeStore{
+		name: "ivan@example.com",
+		root: dir("ivan@example.com", personalInbox, personalSent, dir("Архив", dir("2024"))),
+		defaults: map[int]*node{
+			6: personalInbox,
+			5: personalSent,
+		},
+	}
+
+	sharedInbox := dir("Inbox", dir("Zabbix"), dir("Slash/Name"))
+	shared := &fakeStore{
+		name:     "Shared Ops",
+		root:     dir("Shared Ops", sharedInbox, dir("Sent Items")),
+		defaults: map[int]*node{6: sharedInbox},
+	}
+
+	return &fakeTree{stores: []*fakeStore{personal, shared}}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want *node // nil - ожидается ошибка
+		path string
+	}{
+		{ref: "@inbox", path: "ivan@example.com/Входящие"},
+		{ref: "@INBOX/zabbix", path: "ivan@example.com/Входящие/Zabbix"},
+		{ref: "@sent", path: "ivan@example.com/Отправленные"},
+		{ref: "Входящие", path: "ivan@example.com/Входящие"},
+		{ref: "Shared Ops/Inbox/Zabbix", path: "Shared Ops/Inbox/Zabbix"},
+		{ref: "shared ops/@inbox/Zabbix", path: "Shared Ops/Inbox/Zabbix"},
+		{ref: `\\Shared Ops\Inbox\Zabbix`, path: "Shared Ops/Inbox/Zabbix"},
+		{ref: `Shared Ops/Inbox/Slash\/Name`, path: "Shared Ops/Inbox/Slash/Name"},
+		{ref: "Отчеты", path: "ivan@example.com/Входящие/Отчеты"},
+		{ref: "Архив/2024", path: "ivan@example.com/Архив/2024"},
+		{ref: "Sent Items", path: "Shared Ops/Sent Items"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			tree := newTree()
+			folder, err := Resolve(tree, tt.ref)
+			if err != nil {
+				t.Fatalf("Resolve(%q): %v", tt.ref, err)
+			}
+			if got := pathOf(tree, folder.(*folderHandle).n); got != tt.path {
+				t.Errorf("Resolve(%q) = %s, want %s", tt.ref, got, tt.path)
+			}
+			folder.Release()
+			if tree.open != 0 {
+				t.Errorf("не освобождено объектов: %d", tree.open)
+			}
+		})
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		ref     string
+		wantErr string
+	}{
+		{"Zabbix", "найдена в нескольких местах"},
+		{"Нет такой", "не найдена"},
+		{"Shared Ops/Inbox/Нет", "нет подпапки"},
+		{"@calendar", "неизвестная специальная папка"},
+		{"Shared Ops//Inbox", "пустой компонент"},
+		{"Shared Ops/@sent", "не найдена в хранилище"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			tree := newTree()
+			_, err := Resolve(tree, tt.ref)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Resolve(%q) error = %v, want %q", tt.ref, err, tt.wantErr)
+			}
+			if tree.open != 0 {
+				t.Errorf("не освобождено объектов: %d", tree.open)
+			}
+		})
+	}
+}
+
+func TestResolveAmbiguousListsPaths(t *testing.T) {
+	_, err := Resolve(newTree(), "Zabbix")
+	if err == nil {
+		t.Fatal("ожидалась ошибка")
+	}
+	for _, path := range []string{"ivan@example.com/Входящие/Zabbix", "Shared Ops/Inbox/Zabbix"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("в ошибке нет пути %s: %v", path, err)
+		}
+	}
+}
+
+// Возвращает полный путь узла в дереве
+func pathOf(tree *fakeTree, target *node) string {
+	var walk func(n *node, path string) string
+	walk = func(n *node, path string) string {
+		if n == target {
+			return path
+		}
+		for _, child := range n.children {
+			if p := walk(child, path+"/"+child.name); p != "" {
+				return p
+			}
+		}
+		return ""
+	}
+	for _, s := range tree.stores {
+		if p := walk(s.root, s.name); p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+func TestSubtree(t *testing.T) {
+	clients := dir("Clients",
+		dir("Project Alpha", dir("Архив"), dir("Project Alpha Sub")),
+		dir("Project Beta"),
+		dir("Old", dir("Project Gamma")),
+		dir("Прочее"),
+	)
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{
+			name: "все подпапки",
+			want: []string{
+				"Clients",
+				"Clients/Project Alpha",
+				"Clients/Project Alpha/Архив",
+				"Clients/Project Alpha/Project Alpha Sub",
+				"Clients/Project Beta",
+				"Clients/Old",
+				"Clients/Old/Project Gamma",
+				"Clients/Прочее",
+			},
+		},
+		{
+			name:   "include",
+			filter: Filter{Include: []string{"project *"}},
+			want: []string{
+				"Clients",
+				"Clients/Project Alpha",
+				"Clients/Project Alpha/Project Alpha Sub",
+				"Clients/Project Beta",
+				"Clients/Old/Project Gamma",
+			},
+		},
+		{
+			name:   "exclude отсекает вложенные папки",
+			filter: Filter{Include: []string{"Project*"}, Exclude: []string{"Old", "*Sub"}},
+			want: []string{
+				"Clients",
+				"Clients/Project Alpha",
+				"Clients/Project Beta",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := &fakeTree{}
+			entries, err := Subtree(tree.handle(clients), "Clients", tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, e := range entries {
+				got = append(got, e.Path)
+				e.Folder.Release()
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Subtree() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+			if tree.open != 0 {
+				t.Errorf("не освобождено объектов: %d", tree.open)
+			}
+		})
+	}
+}
+
+func TestFilterValidate(t *testing.T) {
+	if err := (Filter{Include: []string{"Project*"}}).Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+	if err := (Filter{Exclude: []string{"[abc"}}).Validate(); err == nil {
+		t.Error("ожидалась ошибка для некорректного шаблона")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Запись в истории доставки уведомлений
+type HistoryRecord struct {
+	Time    time.Time `json:"time"`
+	Folder  string    `json:"folder"`
+	Sender  string    `json:"sender"`
+	Subject string    `json:"subject"`
+	EntryID string    `json:"entry_id"`
+	ChatID  string    `json:"chat_id"`
+}
+
+// Хранилище истории: записи держим в памяти и дописываем в файл построчно (JSON Lines)
+type deliveryHistory struct {
+	mutex     sync.Mutex
+	path      string
+	retention time.Duration
+	records   []HistoryRecord
+}
+
+var history *deliveryHistory
+
+const defaultHistoryDays = 7
+
+// Возвращает полный путь к файлу данных рядом с исполняемым файлом
+func dataFilePath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	exePath, err := os.Executable()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(filepath.Dir(exePath), name)
+}
+
+// Загружает историю из файла и отбрасывает устаревшие записи
+func initHistory(filename string, days int) error {
+	if days <= 0 {
+		days = defaultHistoryDays
+	}
+
+	history = &deliveryHistory{
+		path:      dataFilePath(filename),
+		retention: time.Duration(days) * 24 * time.Hour,
+	}
+
+	file, err := os.Open(history.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("ошибка открытия файла истории: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var rec HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // Пропускаем поврежденные строки
+		}
+		history.records = append(history.records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("ошибка чтения файла истории: %v", err)
+	}
+
+	return history.compact()
+}
+
+// Раз в сутки удаляет устаревшие записи истории, пока не отменен ctx.
+// Работает независимо от сводок: история пишется и без них
+func runHistoryCompaction(ctx context.Context) {
+	if history == nil {
+		return
+	}
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := history.compact(); err != nil {
+				logMessage("%v", err)
+			}
+		}
+	}
+}
+
+// Добавляет запись в историю
+func recordHistory(rec HistoryRecord) {
+	if history == nil {
+		return
+	}
+
+	history.mutex.Lock()
+	defer history.mutex.Unlock()
+
+	history.records = append(history.records, rec)
+
+	file, err := os.OpenFile(history.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		logMessage("Ошибка записи истории: %v", err)
+		return
+	}
+	defer file.Close()
+
+	data, _ := json.Marshal(rec)
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		logMessage("Ошибка записи истории: %v", err)
+	}
+}
+
+// Возвращает копию записей, попавших в интервал [from, to)
+func (h *deliveryHistory) between(from, to time.Time) []HistoryRecord {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	var result []HistoryRecord
+	for _, rec := range h.records {
+		if !rec.Time.Before(from) && rec.Time.Before(to) {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+// Удаляет записи старше срока хранения и перезаписывает файл
+func (h *deliveryHistory) compact() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	cutoff := time.Now().Add(-h.retention)
+	kept := h.records[:0]
+	for _, rec := range h.records {
+		if rec.Time.After(cutoff) {
+			kept = append(kept, rec)
+		}
+	}
+	h.records = kept
+
+	tmpPath := h.path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("ошибка сжатия истории: %v", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, rec := range h.records {
+		data, _ := json.Marshal(rec)
+		writer.Write(append(data, '\n'))
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("ошибка сжатия истории: %v", err)
+	}
+	file.Close()
+
+	return os.Rename(tmpPath, h.path)
+}
@@ -0,0 +1,577 @@
+// Пакет htmlconv преобразует HTML-тело письма в подмножество HTML,
+// которое поддерживает Telegram Bot API (parse_mode=HTML):
+// b, i, u, s, a, code, pre и blockquote. Остальная разметка удаляется,
+// таблицы линеаризуются, изображения заменяются текстовыми заглушками.
+package htmlconv
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Сопоставление тегов письма тегам Telegram
+var inlineTags = map[atom.Atom]string{
+	atom.B:      "b",
+	atom.Strong: "b",
+	atom.I:      "i",
+	atom.Em:     "i",
+	atom.Cite:   "i",
+	atom.U:      "u",
+	atom.Ins:    "u",
+	atom.S:      "s",
+	atom.Strike: "s",
+	atom.Del:    "s",
+	atom.Code:   "code",
+	atom.Tt:     "code",
+	atom.Kbd:    "code",
+	atom.Samp:   "code",
+}
+
+// Элементы, содержимое которых не выводится
+var skipTags = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Title:    true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Object:   true,
+	atom.Iframe:   true,
+	atom.Svg:      true,
+	atom.Meta:     true,
+	atom.Link:     true,
+	atom.Select:   true,
+}
+
+// Блочные элементы, которые начинаются с новой строки
+var blockTags = map[atom.Atom]bool{
+	atom.Div:        true,
+	atom.Section:    true,
+	atom.Article:    true,
+	atom.Header:     true,
+	atom.Footer:     true,
+	atom.Center:     true,
+	atom.Address:    true,
+	atom.Ul:         true,
+	atom.Ol:         true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Dd:         true,
+	atom.Table:      true,
+	atom.Form:       true,
+	atom.Fieldset:   true,
+	atom.Figure:     true,
+	atom.Figcaption: true,
+}
+
+// Допустимые схемы ссылок
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+	"tg":     true,
+	"ftp":    true,
+}
+
+// Заглушки для изображений
+const (
+	ImagePlaceholder      = "[изображение]"
+	ImagePlaceholderTitle = "[изображение: %s]"
+	horizontalRule        = "——————————"
+)
+
+// Convert преобразует HTML в текст с разметкой Telegram.
+func Convert(src string) string {
+	doc, err := html.Parse(strings.NewReader(src))
+	if err != nil {
+		// html.Parse ошибается только при ошибке чтения, но на всякий случай
+		return escapeText(src)
+	}
+
+	c := &converter{}
+	c.walk(doc)
+	return c.result()
+}
+
+type openTag struct {
+	name    string
+	attrs   string
+	emitted bool
+}
+
+type converter struct {
+	out strings.Builder
+
+	// Открытые теги Telegram. Тег выводится только перед первым видимым текстом,
+	// чтобы не оставлять пустых сущностей, которые Telegram отклоняет.
+	stack []*openTag
+
+	pre          int  // Глубина вложенности в <pre>
+	pendingSpace bool // Нужно вывести пробел перед следующим словом
+	newlines     int  // Сколько переводов строки стоит в конце вывода
+	started      bool // Был ли выведен хоть какой-то текст
+
+	lists []listState
+}
+
+type listState struct {
+	ordered bool
+	index   int
+}
+
+func (c *converter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		c.text(n.Data)
+		return
+	case html.CommentNode, html.DoctypeNode:
+		return
+	case html.ElementNode:
+		c.element(n)
+		return
+	}
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+func (c *converter) children(n *html.Node) {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c.walk(child)
+	}
+}
+
+func (c *converter) element(n *html.Node) {
+	if skipTags[n.DataAtom] || n.Data == "xml" {
+		return
+	}
+
+	if tag, ok := inlineTags[n.DataAtom]; ok {
+		c.push(tag, "")
+		c.children(n)
+		c.pop()
+		return
+	}
+
+	switch n.DataAtom {
+	case atom.Br:
+		c.newline(1)
+
+	case atom.Hr:
+		c.newline(1)
+		c.raw(horizontalRule)
+		c.newline(1)
+
+	case atom.Img:
+		alt := strings.TrimSpace(attr(n, "alt"))
+		if alt == "" {
+			alt = strings.TrimSpace(attr(n, "title"))
+		}
+		if alt != "" {
+			c.word(fmt.Sprintf(ImagePlaceholderTitle, alt))
+		} else {
+			c.word(ImagePlaceholder)
+		}
+
+	case atom.A:
+		href := safeHref(attr(n, "href"))
+		if href == "" || c.inside("a") {
+			c.children(n)
+			return
+		}
+		c.push("a", ` href="`+escapeAttr(href)+`"`)
+		c.children(n)
+		c.pop()
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		c.newline(2)
+		c.push("b", "")
+		c.children(n)
+		c.pop()
+		c.newline(2)
+
+	case atom.Pre:
+		c.newline(1)
+		c.push("pre", "")
+		c.pre++
+		c.children(n)
+		c.pre--
+		c.pop()
+		c.newline(1)
+
+	case atom.Blockquote:
+		c.newline(1)
+		c.push("blockquote", "")
+		c.children(n)
+		c.pop()
+		c.newline(1)
+
+	case atom.Ul, atom.Ol:
+		c.newline(1)
+		c.lists = append(c.lists, listState{ordered: n.DataAtom == atom.Ol})
+		c.children(n)
+		c.lists = c.lists[:len(c.lists)-1]
+		c.newline(1)
+
+	case atom.Li:
+		c.newline(1)
+		marker := "•"
+		if len(c.lists) > 0 {
+			list := &c.lists[len(c.lists)-1]
+			list.index++
+			if list.ordered {
+				marker = fmt.Sprintf("%d.", list.index)
+			}
+			if depth := len(c.lists) - 1; depth > 0 {
+				c.raw(strings.Repeat("  ", depth))
+			}
+		}
+		c.raw(marker + " ")
+		c.children(n)
+		c.newline(1)
+
+	case atom.Tr:
+		// Строка таблицы выводится одной строкой, ячейки разделяются " | "
+		c.newline(1)
+		first := true
+		for cell := n.FirstChild; cell != nil; cell = cell.NextSibling {
+			if cell.Type != html.ElementNode || (cell.DataAtom != atom.Td && cell.DataAtom != atom.Th) {
+				c.walk(cell)
+				continue
+			}
+			if isEmpty(cell) {
+				continue
+			}
+			if !first {
+				c.raw(" | ")
+			}
+			first = false
+			if cell.DataAtom == atom.Th {
+				c.push("b", "")
+				c.children(cell)
+				c.pop()
+			} else {
+				c.children(cell)
+			}
+			c.pendingSpace = false
+		}
+		c.newline(1)
+
+	case atom.Td, atom.Th:
+		// Ячейка вне строки таблицы
+		c.children(n)
+		c.pendingSpace = true
+
+	case atom.P:
+		// Пустой абзац - это пустая строка. Абзацы Word (class="MsoNormal")
+		// идут без отступов, поэтому отделяются одним переводом строки.
+		if isEmpty(n) {
+			c.newline(2)
+			return
+		}
+		c.newline(1)
+		c.children(n)
+		if strings.HasPrefix(attr(n, "class"), "Mso") {
+			c.newline(1)
+		} else {
+			c.newline(2)
+		}
+
+	default:
+		if blockTags[n.DataAtom] {
+			c.newline(1)
+			c.children(n)
+			c.newline(1)
+			return
+		}
+		c.children(n)
+	}
+}
+
+// Выводит текстовый узел
+func (c *converter) text(data string) {
+	if c.pre > 0 {
+		c.flushTags()
+		c.writeText(data)
+		return
+	}
+
+	if data == "" {
+		return
+	}
+	if isSpace(rune(data[0])) {
+		c.pendingSpace = true
+	}
+	words := strings.Fields(data)
+	for i, word := range words {
+		if i > 0 {
+			c.pendingSpace = true
+		}
+		c.word(escapeText(word))
+	}
+	if len(words) > 0 && isSpace(rune(data[len(data)-1])) {
+		c.pendingSpace = true
+	}
+}
+
+// Выводит слово (уже экранированное), добавляя отложенный пробел
+func (c *converter) word(escaped string) {
+	if c.pendingSpace && c.newlines == 0 && c.started {
+		c.out.WriteByte(' ')
+	}
+	c.pendingSpace = false
+	c.flushTags()
+	c.out.WriteString(escaped)
+	c.newlines = 0
+	c.started = true
+}
+
+// Выводит служебный текст (маркеры списков, разделители) без экранирования
+func (c *converter) raw(s string) {
+	c.pendingSpace = false
+	c.flushTags()
+	c.out.WriteString(s)
+	c.newlines = 0
+	c.started = true
+}
+
+func (c *converter) writeText(s string) {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	c.out.WriteString(escapeText(s))
+	if s != "" {
+		c.started = true
+		c.newlines = 0
+		for i := len(s) - 1; i >= 0 && s[i] == '\n'; i-- {
+			c.newlines++
+		}
+	}
+}
+
+// Гарантирует, что в конце вывода стоит не меньше n переводов строки
+func (c *converter) newline(n int) {
+	c.pendingSpace = false
+	if !c.started || c.pre > 0 {
+		return
+	}
+	for c.newlines < n {
+		c.out.WriteByte('\n')
+		c.newlines++
+	}
+}
+
+func (c *converter) push(name, attrs string) {
+	if c.inside(name) {
+		// Повторная вложенность одинаковых тегов не нужна
+		c.stack = append(c.stack, &openTag{name: "", emitted: false})
+		return
+	}
+	c.stack = append(c.stack, &openTag{name: name, attrs: attrs})
+}
+
+func (c *converter) pop() {
+	top := c.stack[len(c.stack)-1]
+	c.stack = c.stack[:len(c.stack)-1]
+	if top.emitted {
+		c.out.WriteString("</" + top.name + ">")
+	}
+}
+
+func (c *converter) inside(name string) bool {
+	for _, t := range c.stack {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Выводит открывающие теги, которые еще не были выведены
+func (c *converter) flushTags() {
+	for _, t := range c.stack {
+		if t.name != "" && !t.emitted {
+			c.out.WriteString("<" + t.name + t.attrs + ">")
+			t.emitted = true
+		}
+	}
+}
+
+var manyNewlines = regexp.MustCompile(`\n{3,}`)
+
+func (c *converter) result() string {
+	// Закрываем теги, оставшиеся открытыми (на случай некорректного документа)
+	for len(c.stack) > 0 {
+		c.pop()
+	}
+	s := manyNewlines.ReplaceAllString(c.out.String(), "\n\n")
+	return strings.TrimSpace(s)
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func safeHref(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil || !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return ""
+	}
+	return href
+}
+
+// Telegram понимает только &lt;, &gt;, &amp; и &quot;, поэтому экранируем минимально
+func escapeText(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	return strings.ReplaceAll(s, ">", "&gt;")
+}
+
+func escapeAttr(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, `"`, "&quot;")
+	s = strings.ReplaceAll(s, "<", "&lt;")
+	return strings.ReplaceAll(s, ">", "&gt;")
+}
+
+// Проверяет, есть ли в узле видимое содержимое
+func isEmpty(n *html.Node) bool {
+	empty := true
+	var visit func(*html.Node)
+	visit = func(n *html.Node) {
+		if !empty {
+			return
+		}
+		switch {
+		case n.Type == html.TextNode && strings.TrimSpace(strings.ReplaceAll(n.Data, " ", " ")) != "":
+			empty = false
+		case n.Type == html.ElementNode && (n.DataAtom == atom.Img || n.DataAtom == atom.Hr):
+			empty = false
+		case n.Type == html.ElementNode && skipTags[n.DataAtom]:
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			visit(child)
+		}
+	}
+	visit(n)
+	return empty
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\f'
+}
+
+// Truncate обрезает размеченный текст до maxRunes видимых символов,
+// не разрывая теги и HTML-сущности, и закрывает оставшиеся открытыми теги.
+// Если текст обрезан, в конец добавляется "...".
+func Truncate(s string, maxRunes int) string {
+	var (
+		out   strings.Builder
+		stack []string
+		count int
+	)
+
+	for i := 0; i < len(s); {
+		switch s[i] {
+		case '<':
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				// Незакрытый тег в конце строки отбрасываем
+				return closeTags(&out, stack)
+			}
+			tag := s[i : i+end+1]
+			name, closing := tagName(tag)
+			if closing {
+				if len(stack) > 0 && stack[len(stack)-1] == name {
+					stack = stack[:len(stack)-1]
+					out.WriteString(tag)
+				}
+			} else if name != "" {
+				stack = append(stack, name)
+				out.WriteString(tag)
+			}
+			i += end + 1
+			continue
+
+		case '&':
+			if count >= maxRunes {
+				out.WriteString("...")
+				return closeTags(&out, stack)
+			}
+			if end := strings.IndexByte(s[i:], ';'); end > 0 && end < 10 {
+				out.WriteString(s[i : i+end+1])
+				i += end + 1
+				count++
+				continue
+			}
+		}
+
+		if count >= maxRunes {
+			out.WriteString("...")
+			return closeTags(&out, stack)
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		out.WriteString(s[i : i+size])
+		i += size
+		count++
+	}
+
+	return closeTags(&out, stack)
+}
+
+// StripTags удаляет теги и раскрывает HTML-сущности, возвращая видимый текст.
+func StripTags(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '<' {
+			end := strings.IndexByte(s[i:], '>')
+			if end < 0 {
+				break
+			}
+			i += end + 1
+			continue
+		}
+		next := strings.IndexByte(s[i:], '<')
+		if next < 0 {
+			next = len(s) - i
+		}
+		out.WriteString(s[i : i+next])
+		i += next
+	}
+	return html.UnescapeString(out.String())
+}
+
+func closeTags(out *strings.Builder, stack []string) string {
+	for i := len(stack) - 1; i >= 0; i-- {
+		out.WriteString("</" + stack[i] + ">")
+	}
+	return out.String()
+}
+
+func tagName(tag string) (name string, closing bool) {
+	tag = strings.TrimPrefix(tag, "<")
+	tag = strings.TrimSuffix(tag, ">")
+	if strings.HasPrefix(tag, "/") {
+		closing = true
+		tag = tag[1:]
+	}
+	if idx := strings.IndexAny(tag, " \t\n/"); idx >= 0 {
+		tag = tag[:idx]
+	}
+	return strings.ToLower(tag), closing
+}
@@ -0,0 +1,79 @@
+package htmlconv
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "перезаписать эталонные файлы testdata/*.golden")
+
+func TestConvertGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("нет входных файлов в testdata")
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".html")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := Convert(string(src))
+
+			golden := strings.TrimSuffix(input, ".html") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("нет эталона (запустите go test -update): %v", err)
+			}
+			if got+"\n" != string(want) {
+				t.Errorf("результат отличается от %s:\n--- получено ---\n%s\n--- ожидалось ---\n%s", golden, got, want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		max  int
+		want string
+	}{
+		{"короткий текст", "<b>abc</b>", 10, "<b>abc</b>"},
+		{"закрывает теги", "<b>abc<i>def</i></b>", 4, "<b>abc<i>d...</i></b>"},
+		{"сущность как один символ", "a &amp; b", 3, "a &amp;..."},
+		{"ссылка", `<a href="https://x/?a=1&amp;b=2">link</a> tail`, 2, `<a href="https://x/?a=1&amp;b=2">li...</a>`},
+		{"незакрытый тег в конце", "abc<a hre", 10, "abc"},
+		{"кириллица", "привет мир", 6, "привет..."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Truncate(tt.in, tt.max); got != tt.want {
+				t.Errorf("Truncate(%q, %d) = %q, ожидалось %q", tt.in, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStripTags(t *testing.T) {
+	got := StripTags(`<b>Тема</b> &amp; <a href="https://x">ссылка</a>`)
+	if want := "Тема & ссылка"; got != want {
+		t.Errorf("StripTags = %q, ожидалось %q", got, want)
+	}
+}
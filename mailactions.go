@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"github.com/scjalliance/comshim"
+
+	"otn/mailsource"
+)
+
+// Действия над письмом в почтовом источнике
+type mailActions interface {
+	MarkRead(entryID string) error
+	Flag(entryID string) error
+	// Move перемещает письмо и возвращает его новый EntryID
+	Move(entryID, folderName string) (string, error)
+	// ReplyAll отправляет ответ всем участникам переписки
+	ReplyAll(entryID, text string) error
+}
+
+// Почтовый источник Outlook: письма находятся по EntryID через пространство имен MAPI
+type outlookSource struct{}
+
+var mailSource mailActions = outlookSource{}
+
+// Константы Outlook
+const (
+	olMarkNoDate = 4 // MarkAsTask без срока
+	olFormatHTML = 2 // BodyFormat для HTML-писем
+)
+
+// Подключается к Outlook и выполняет действие в пространстве имен MAPI
+func withOutlook(fn func(ns *ole.IDispatch) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ошибка Outlook: %v", r)
+		}
+	}()
+
+	comshim.Add(1)
+	defer comshim.Done()
+
+	outlook, ns, err := initializeOutlook()
+	if err != nil {
+		return err
+	}
+	defer releaseObjects(outlook, ns)
+
+	return fn(ns)
+}
+
+// Находит письмо по EntryID и выполняет над ним действие
+func (outlookSource) withItem(entryID string, fn func(ns, item *ole.IDispatch) error) error {
+	return withOutlook(func(ns *ole.IDispatch) error {
+		itemVar, err := oleutil.CallMethod(ns, "GetItemFromID", entryID)
+		if err != nil {
+			return fmt.Errorf("письмо не найдено: %v", err)
+		}
+		item := itemVar.ToIDispatch()
+		defer item.Release()
+
+		return fn(ns, item)
+	})
+}
+
+func (o outlookSource) MarkRead(entryID string) error {
+	return o.withItem(entryID, func(_, item *ole.IDispatch) error {
+		return markItemRead(item)
+	})
+}
+
+func (o outlookSource) Flag(entryID string) error {
+	return o.withItem(entryID, func(_, item *ole.IDispatch) error {
+		if _, err := oleutil.CallMethod(item, "MarkAsTask", olMarkNoDate); err != nil {
+			return fmt.Errorf("ошибка установки флажка: %v", err)
+		}
+		if _, err := oleutil.CallMethod(item, "Save"); err != nil {
+			return fmt.Errorf("ошибка сохранения письма: %v", err)
+		}
+		return nil
+	})
+}
+
+func (o outlookSource) Move(entryID, folderName string) (newEntryID string, err error) {
+	err = o.withItem(entryID, func(ns, item *ole.IDispatch) error {
+		id, err := moveItem(ns, item, folderName)
+		newEntryID = id
+		return err
+	})
+	return newEntryID, err
+}
+
+func (o outlookSource) ReplyAll(entryID, text string) error {
+	return o.withItem(entryID, func(_, item *ole.IDispatch) error {
+		replyVar, err := oleutil.CallMethod(item, "ReplyAll")
+		if err != nil {
+			return fmt.Errorf("ошибка создания ответа: %v", err)
+		}
+		reply := replyVar.ToIDispatch()
+		defer reply.Release()
+
+		// Текст ответа вставляем перед цитатой исходного письма
+		format, _ := oleutil.GetProperty(reply, "BodyFormat")
+		if format != nil && format.Val == olFormatHTML {
+			htmlBody := oleutil.MustGetProperty(reply, "HTMLBody").ToString()
+			_, err = oleutil.PutProperty(reply, "HTMLBody", insertIntoHTMLBody(htmlBody, textToHTML(text)))
+		} else {
+			body := oleutil.MustGetProperty(reply, "Body").ToString()
+			_, err = oleutil.PutProperty(reply, "Body", text+"\r\n\r\n"+body)
+		}
+		if err != nil {
+			return fmt.Errorf("ошибка заполнения ответа: %v", err)
+		}
+
+		if _, err := oleutil.CallMethod(reply, "Send"); err != nil {
+			return fmt.Errorf("ошибка отправки ответа: %v", err)
+		}
+		return nil
+	})
+}
+
+// Преобразует текст в HTML-абзац с сохранением переводов строк
+func textToHTML(text string) string {
+	escaped := html.EscapeString(text)
+	escaped = strings.ReplaceAll(escaped, "\r\n", "\n")
+	return "<div>" + strings.ReplaceAll(escaped, "\n", "<br>") + "</div><br>"
+}
+
+// Вставляет фрагмент сразу после открывающего тега <body>
+func insertIntoHTMLBody(body, fragment string) string {
+	lower := strings.ToLower(body)
+	start := strings.Index(lower, "<body")
+	if start < 0 {
+		return fragment + body
+	}
+	end := strings.Index(lower[start:], ">")
+	if end < 0 {
+		return fragment + body
+	}
+	pos := start + end + 1
+	return body[:pos] + fragment + body[pos:]
+}
+
+// Действие над письмом после успешной отправки уведомления (after_send)
+type afterSendAction struct {
+	Kind string `json:"kind"`          // mark_read, move_to или categorize; пусто - ничего не делать
+	Arg  string `json:"arg,omitempty"` // Папка для move_to или категория для categorize
+}
+
+// Разбирает значение after_send: none, mark_read, move_to:<папка>, categorize:<категория>
+func parseAfterSend(value string) (afterSendAction, error) {
+	kind, arg, _ := strings.Cut(strings.TrimSpace(value), ":")
+	kind = strings.TrimSpace(kind)
+	arg = strings.TrimSpace(arg)
+
+	switch kind {
+	case "", "none":
+		return afterSendAction{}, nil
+	case "mark_read":
+		return afterSendAction{Kind: kind}, nil
+	case "move_to", "categorize":
+		if arg == "" {
+			return afterSendAction{}, fmt.Errorf("для %s нужно указать значение после двоеточия", kind)
+		}
+		return afterSendAction{Kind: kind, Arg: arg}, nil
+	}
+	return afterSendAction{}, fmt.Errorf("неизвестное действие %q (допустимы: none, mark_read, move_to:<папка>, categorize:<категория>)", value)
+}
+
+// Выполняет действие after_send над письмом источника. Для move_to возвращает
+// новый идентификатор письма, если источник его сообщает
+func applyAfterSend(actions mailsource.Actions, action afterSendAction) (string, error) {
+	switch action.Kind {
+	case "mark_read":
+		return "", actions.MarkRead()
+	case "move_to":
+		return actions.Move(action.Arg)
+	case "categorize":
+		return "", actions.Categorize(action.Arg)
+	}
+	return "", nil
+}
+
+// Act выполняет действия after_send над письмом Outlook по EntryID
+func (o outlookSource) Act(_ context.Context, entryID string, fn func(mailsource.Actions) error) error {
+	return o.withItem(entryID, func(ns, item *ole.IDispatch) error {
+		return fn(&outlookItemActions{ns: ns, item: item})
+	})
+}
+
+// Действия after_send над найденным письмом Outlook
+type outlookItemActions struct {
+	ns   *ole.IDispatch
+	item *ole.IDispatch
+}
+
+func (a *outlookItemActions) MarkRead() error {
+	return markItemRead(a.item)
+}
+
+func (a *outlookItemActions) Move(folderName string) (string, error) {
+	return moveItem(a.ns, a.item, folderName)
+}
+
+func (a *outlookItemActions) Categorize(category string) error {
+	return addItemCategory(a.item, category)
+}
+
+// Добавляет категорию к письму, сохраняя уже назначенные
+func addItemCategory(item *ole.IDispatch, category string) error {
+	current := oleutil.MustGetProperty(item, "Categories").ToString()
+	for _, c := range strings.Split(current, ",") {
+		if strings.TrimSpace(c) == category {
+			return nil
+		}
+	}
+
+	categories := category
+	if strings.TrimSpace(current) != "" {
+		categories = current + ", " + category
+	}
+	if _, err := oleutil.PutProperty(item, "Categories", categories); err != nil {
+		return fmt.Errorf("ошибка назначения категории: %v", err)
+	}
+	if _, err := oleutil.CallMethod(item, "Save"); err != nil {
+		return fmt.Errorf("ошибка сохранения письма: %v", err)
+	}
+	return nil
+}
+
+func markItemRead(item *ole.IDispatch) error {
+	if _, err := oleutil.PutProperty(item, "UnRead", false); err != nil {
+		return fmt.Errorf("ошибка отметки письма прочитанным: %v", err)
+	}
+	if _, err := oleutil.CallMethod(item, "Save"); err != nil {
+		return fmt.Errorf("ошибка сохранения письма: %v", err)
+	}
+	return nil
+}
+
+func moveItem(ns, item *ole.IDispatch, folderName string) (string, error) {
+	target, err := getFolder(ns, folderName)
+	if err != nil {
+		return "", err
+	}
+	defer target.Release()
+
+	movedVar, err := oleutil.CallMethod(item, "Move", target)
+	if err != nil {
+		return "", fmt.Errorf("ошибка перемещения письма: %v", err)
+	}
+	moved := movedVar.ToIDispatch()
+	defer moved.Release()
+
+	idVar, err := oleutil.GetProperty(moved, "EntryID")
+	if err != nil {
+		return "", nil
+	}
+	return idVar.ToString(), nil
+}
@@ -0,0 +1,344 @@
+// Пакет dropsource получает письма из папки-приемника на диске: каталога
+// Maildir (подкаталоги new и cur) или обычной папки с файлами .eml и .msg.
+// Каждый файл считается новым письмом. Принятый в обработку файл переносится
+// в подкаталог processed (действие after_send move_to позже переносит его
+// оттуда), а файлы, которые не удалось разобрать, - в подкаталог failed.
+package dropsource
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"otn/mailsource"
+)
+
+// Подкаталоги для обработанных и нераспознанных файлов
+const (
+	ProcessedDir = "processed"
+	FailedDir    = "failed"
+)
+
+// Dir - отслеживаемая папка-приемник
+type Dir struct {
+	Path   string // Каталог на диске
+	Folder string // Папка, под которой письма попадают в уведомления
+	HTML   bool   // Брать HTML-тело письма
+}
+
+// Source - источник писем из папок-приемников
+type Source struct {
+	dirs []Dir
+
+	// Как часто проверять папки
+	PollInterval time.Duration
+	// Файлы, измененные позже этого времени назад, еще могут дописываться
+	// и откладываются до следующей проверки
+	SettleTime time.Duration
+	// Журнал; по умолчанию сообщения не выводятся
+	Logf func(format string, args ...interface{})
+	// Журнал ошибок; по умолчанию ошибки пишутся в Logf
+	Errorf func(format string, args ...interface{})
+}
+
+// New создает источник для списка папок
+func New(dirs []Dir) *Source {
+	return &Source{
+		dirs:         dirs,
+		PollInterval: 10 * time.Second,
+		SettleTime:   2 * time.Second,
+		Logf:         func(string, ...interface{}) {},
+	}
+}
+
+// Name возвращает имя источника
+func (s *Source) Name() string {
+	return "drop"
+}
+
+// Сообщает об ошибке через Errorf, а если он не задан - через Logf
+func (s *Source) errorf(format string, args ...interface{}) {
+	if s.Errorf != nil {
+		s.Errorf(format, args...)
+		return
+	}
+	s.Logf(format, args...)
+}
+
+// Run проверяет папки каждые PollInterval и передает новые письма в handle, пока не отменен ctx
+func (s *Source) Run(ctx context.Context, handle mailsource.Handler) error {
+	ticker := time.NewTicker(s.PollInterval)
+	defer ticker.Stop()
+	for {
+		for _, dir := range s.dirs {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := s.scan(dir, handle); err != nil {
+				s.errorf("Папка-приемник %s: %v", dir.Path, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Файл письма в папке-приемнике
+type dropFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Обрабатывает файлы одной папки в порядке их появления. Файл переносится в
+// processed до передачи в handle, чтобы после сбоя письмо не отправилось
+// повторно. Если уведомление не принято, файл возвращается на место и вместе
+// с оставшимися ждет следующей проверки
+func (s *Source) scan(dir Dir, handle mailsource.Handler) error {
+	files, err := listFiles(dir.Path)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if s.SettleTime > 0 && time.Since(file.modTime) < s.SettleTime {
+			continue
+		}
+
+		msg, err := readMessage(file, dir.HTML)
+		if err != nil {
+			s.errorf("Папка-приемник %s: не удалось разобрать %s: %v", dir.Path, file.path, err)
+			if _, err := moveFile(file.path, filepath.Join(dir.Path, FailedDir)); err != nil {
+				return err
+			}
+			continue
+		}
+		msg.ID = fmt.Sprintf("file:%s:%d:%d", file.path, file.size, file.modTime.UnixNano())
+		msg.Folder = dir.Folder
+
+		processed, err := moveFile(file.path, filepath.Join(dir.Path, ProcessedDir))
+		if err != nil {
+			return err
+		}
+		if !handle(msg) {
+			if err := os.Rename(processed, file.path); err != nil {
+				return fmt.Errorf("не удалось вернуть %s после ошибки: %v", processed, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Список писем в папке, от старых к новым. В каталоге Maildir берутся файлы
+// из new и cur, в обычной папке - файлы .eml и .msg
+func listFiles(root string) ([]dropFile, error) {
+	dirs := []string{root}
+	maildir := isDir(filepath.Join(root, "new")) && isDir(filepath.Join(root, "cur"))
+	if maildir {
+		dirs = []string{filepath.Join(root, "new"), filepath.Join(root, "cur")}
+	}
+
+	var files []dropFile
+	for _, dir := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			// Скрытые файлы и временные файлы Office (~$...) пропускаются
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "~") {
+				continue
+			}
+			if !maildir {
+				ext := strings.ToLower(filepath.Ext(name))
+				if ext != ".eml" && ext != ".msg" {
+					continue
+				}
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue // Файл уже перенесен или удален
+			}
+			files = append(files, dropFile{
+				path:    filepath.Join(dir, name),
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			})
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].modTime.Equal(files[j].modTime) {
+			return files[i].modTime.Before(files[j].modTime)
+		}
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Читает письмо из файла. Формат определяется по содержимому: файлы .msg
+// хранятся в составном файле (Compound File Binary), остальные - MIME
+func readMessage(file dropFile, preferHTML bool) (mailsource.Message, error) {
+	data, err := os.ReadFile(file.path)
+	if err != nil {
+		return mailsource.Message{}, err
+	}
+
+	var msg mailsource.Message
+	if bytes.HasPrefix(data, cfbSignature) {
+		msg, err = parseMSG(bytes.NewReader(data), preferHTML)
+	} else {
+		msg, err = mailsource.ParseMIME(bytes.NewReader(data), preferHTML)
+	}
+	if err != nil {
+		return mailsource.Message{}, err
+	}
+	if msg.Received.IsZero() {
+		msg.Received = file.modTime
+	}
+	return msg, nil
+}
+
+// Переносит файл в каталог dest и возвращает его новый путь. Если там уже
+// есть файл с таким именем, к имени добавляется номер
+func moveFile(path, dest string) (string, error) {
+	if err := os.MkdirAll(dest, 0o755); err != nil {
+		return "", fmt.Errorf("ошибка создания каталога %s: %v", dest, err)
+	}
+
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	target := filepath.Join(dest, name)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(target); os.IsNotExist(err) {
+			break
+		}
+		target = filepath.Join(dest, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return "", fmt.Errorf("ошибка переноса %s в %s: %v", path, dest, err)
+	}
+	return target, nil
+}
+
+// Act выполняет действия над уже обработанным файлом письма: находит его
+// в подкаталоге processed по имени, размеру и времени изменения
+func (s *Source) Act(ctx context.Context, id string, fn func(mailsource.Actions) error) error {
+	path, size, modTime, err := parseID(id)
+	if err != nil {
+		return err
+	}
+
+	// Файл лежал в папке-приемнике или в подкаталоге new или cur каталога Maildir
+	var root string
+	for _, dir := range s.dirs {
+		parent := filepath.Dir(path)
+		if filepath.Clean(dir.Path) == parent || filepath.Clean(dir.Path) == filepath.Dir(parent) {
+			root = dir.Path
+			break
+		}
+	}
+	if root == "" {
+		return fmt.Errorf("файл %s не относится к папкам-приемникам", path)
+	}
+
+	processed, err := findProcessed(filepath.Join(root, ProcessedDir), filepath.Base(path), size, modTime)
+	if err != nil {
+		return err
+	}
+	return fn(&fileActions{root: root, path: processed})
+}
+
+// Разбирает идентификатор письма "file:<путь>:<размер>:<время изменения>".
+// Путь может содержать двоеточия, поэтому размер и время берутся с конца
+func parseID(id string) (path string, size, modTime int64, err error) {
+	rest, ok := strings.CutPrefix(id, "file:")
+	parts := strings.Split(rest, ":")
+	if !ok || len(parts) < 3 {
+		return "", 0, 0, fmt.Errorf("письмо %s не относится к папкам-приемникам", id)
+	}
+	n := len(parts)
+	size, errSize := strconv.ParseInt(parts[n-2], 10, 64)
+	modTime, errTime := strconv.ParseInt(parts[n-1], 10, 64)
+	if errSize != nil || errTime != nil {
+		return "", 0, 0, fmt.Errorf("некорректный идентификатор письма %s", id)
+	}
+	return strings.Join(parts[:n-2], ":"), size, modTime, nil
+}
+
+// Находит перенесенный в processed файл: под исходным именем или с номером,
+// если имя было занято. Перенос сохраняет размер и время изменения
+func findProcessed(dir, name string, size, modTime int64) (string, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	for _, entry := range entries {
+		candidate := entry.Name()
+		if candidate != name {
+			number, ok := strings.CutPrefix(strings.TrimSuffix(candidate, ext), stem+"-")
+			if !ok || !strings.HasSuffix(candidate, ext) {
+				continue
+			}
+			if _, err := strconv.Atoi(number); err != nil {
+				continue
+			}
+		}
+		info, err := entry.Info()
+		if err == nil && info.Mode().IsRegular() && info.Size() == size && info.ModTime().UnixNano() == modTime {
+			return filepath.Join(dir, candidate), nil
+		}
+	}
+	return "", fmt.Errorf("файл %s не найден в %s", name, dir)
+}
+
+// Действия after_send над обработанным файлом письма
+type fileActions struct {
+	root string // Папка-приемник
+	path string // Файл в подкаталоге processed
+}
+
+// MarkRead не нужен: обработанный файл и так уносится из папки
+func (a *fileActions) MarkRead() error {
+	return nil
+}
+
+// Move переносит файл в каталог folder (относительный путь считается от папки-приемника)
+func (a *fileActions) Move(folder string) (string, error) {
+	if strings.TrimSpace(folder) == "" {
+		return "", fmt.Errorf("не указан каталог")
+	}
+	if !filepath.IsAbs(folder) {
+		folder = filepath.Join(a.root, folder)
+	}
+	path, err := moveFile(a.path, folder)
+	if err != nil {
+		return "", err
+	}
+	a.path = path
+	return "", nil
+}
+
+func (a *fileActions) Categorize(string) error {
+	return fmt.Errorf("категории не поддерживаются для папок-приемников")
+}
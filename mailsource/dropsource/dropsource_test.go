@@ -0,0 +1,389 @@
+package dropsource
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"otn/mailsource"
+)
+
+const testEML = "From: Zabbix <zabbix@example.com>\r\n" +
+	"Subject: =?UTF-8?B?0JDQstCw0YDQuNGP?=\r\n" +
+	"Date: Tue, 14 May 2024 12:30:00 +0300\r\n" +
+	"Message-ID: <eml1@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=outer\r\n" +
+	"\r\n" +
+	"--outer\r\n" +
+	"Content-Type: multipart/alternative; boundary=inner\r\n" +
+	"\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Сервер db1 недоступен\r\n" +
+	"--inner\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<p>Сервер <b>db1</b> недоступен</p>\r\n" +
+	"--inner--\r\n" +
+	"--outer\r\n" +
+	"Content-Type: text/csv; name=\"graph.csv\"\r\n" +
+	"Content-Disposition: attachment; filename=\"graph.csv\"\r\n" +
+	"\r\n" +
+	"a,b\r\n1,2\r\n" +
+	"--outer\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Disposition: inline; filename=\"logo.png\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--outer--\r\n"
+
+// Записывает файл с заданным временем изменения (смещение от текущего времени)
+func writeFile(t *testing.T, path string, data []byte, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Имена файлов в каталоге (пустой список, если каталога нет)
+func listNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Обработчик, который запоминает письма и возвращает заданный результат
+type recorder struct {
+	mu       sync.Mutex
+	messages []mailsource.Message
+	result   func(msg mailsource.Message) bool
+}
+
+func (r *recorder) handle(msg mailsource.Message) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.messages = append(r.messages, msg)
+	if r.result != nil {
+		return r.result(msg)
+	}
+	return true
+}
+
+func (r *recorder) subjects() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var subjects []string
+	for _, msg := range r.messages {
+		subjects = append(subjects, msg.Subject)
+	}
+	return subjects
+}
+
+func newTestSource(dirs ...Dir) *Source {
+	s := New(dirs)
+	s.SettleTime = 0
+	return s
+}
+
+func TestScanFolder(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "b.msg"), testMSG(), time.Minute)
+	writeFile(t, filepath.Join(root, "a.EML"), []byte(testEML), 2*time.Minute)
+	writeFile(t, filepath.Join(root, "notes.txt"), []byte("не письмо"), 3*time.Minute)
+	writeFile(t, filepath.Join(root, "~$draft.msg"), []byte("временный файл"), 3*time.Minute)
+
+	var r recorder
+	s := newTestSource(Dir{Path: root, Folder: "Zabbix", HTML: true})
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(r.subjects(), "|"); got != "Авария|Авария на сервере" {
+		t.Fatalf("письма = %s", got)
+	}
+
+	eml := r.messages[0]
+	if eml.Folder != "Zabbix" || !strings.HasPrefix(eml.ID, "file:") {
+		t.Errorf("Folder = %q, ID = %q", eml.Folder, eml.ID)
+	}
+	if eml.Sender() != "Zabbix <zabbix@example.com>" || eml.ConversationID != "eml1@example.com" {
+		t.Errorf("Sender() = %q, ConversationID = %q", eml.Sender(), eml.ConversationID)
+	}
+	if !eml.IsHTML || !strings.Contains(eml.Body, "<b>db1</b>") {
+		t.Errorf("Body = %q, IsHTML = %v", eml.Body, eml.IsHTML)
+	}
+	want := []mailsource.Attachment{
+		{Name: "graph.csv", ContentType: "text/csv", Size: 8},
+		{Name: "logo.png", ContentType: "image/png", Size: 8},
+	}
+	if len(eml.Attachments) != len(want) {
+		t.Fatalf("Attachments = %+v, want %+v", eml.Attachments, want)
+	}
+	for i := range want {
+		if eml.Attachments[i] != want[i] {
+			t.Errorf("Attachments[%d] = %+v, want %+v", i, eml.Attachments[i], want[i])
+		}
+	}
+	if !r.messages[1].IsHTML || len(r.messages[1].Attachments) != 1 {
+		t.Errorf(".msg: IsHTML = %v, Attachments = %+v", r.messages[1].IsHTML, r.messages[1].Attachments)
+	}
+
+	if got := strings.Join(listNames(t, filepath.Join(root, ProcessedDir)), "|"); got != "a.EML|b.msg" {
+		t.Errorf("processed = %s", got)
+	}
+	if got := strings.Join(listNames(t, root), "|"); got != "notes.txt|~$draft.msg" {
+		t.Errorf("в папке осталось: %s", got)
+	}
+}
+
+func TestScanMaildir(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "new", "1715679000.M1P1.host"), []byte(testEML), time.Minute)
+	writeFile(t, filepath.Join(root, "cur", "1715678000.M2P1.host:2,S"), []byte("Subject: old\r\n\r\ntext\r\n"), 2*time.Minute)
+	writeFile(t, filepath.Join(root, "tmp", "1715679500.M3P1.host"), []byte("Subject: partial\r\n"), 2*time.Minute)
+
+	var r recorder
+	s := newTestSource(Dir{Path: root, Folder: "Maildir"})
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(r.subjects(), "|"); got != "old|Авария" {
+		t.Fatalf("письма = %s", got)
+	}
+	if r.messages[1].IsHTML {
+		t.Error("без HTML ожидалось текстовое тело")
+	}
+	if got := len(listNames(t, filepath.Join(root, ProcessedDir))); got != 2 {
+		t.Errorf("в processed %d файлов, want 2", got)
+	}
+	if got := listNames(t, filepath.Join(root, "tmp")); len(got) != 1 {
+		t.Errorf("файлы из tmp не должны обрабатываться: %v", got)
+	}
+}
+
+func TestScanRetry(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "1.eml"), []byte("Subject: first\r\n\r\n1\r\n"), 2*time.Minute)
+	writeFile(t, filepath.Join(root, "2.eml"), []byte("Subject: second\r\n\r\n2\r\n"), time.Minute)
+
+	// Первое уведомление не доставлено: оба файла остаются на месте
+	r := recorder{result: func(mailsource.Message) bool { return false }}
+	s := newTestSource(Dir{Path: root})
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.subjects(), "|"); got != "first" {
+		t.Fatalf("письма = %s", got)
+	}
+	if got := len(listNames(t, root)); got != 2 {
+		t.Fatalf("в папке %d файлов, want 2", got)
+	}
+
+	// При следующей проверке письмо отправляется повторно с тем же ID
+	firstID := r.messages[0].ID
+	r = recorder{}
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.subjects(), "|"); got != "first|second" {
+		t.Fatalf("письма = %s", got)
+	}
+	if r.messages[0].ID != firstID {
+		t.Errorf("ID изменился: %q, want %q", r.messages[0].ID, firstID)
+	}
+	if got := len(listNames(t, root)); got != 0 {
+		t.Errorf("в папке осталось %d файлов", got)
+	}
+}
+
+func TestScanMovesBeforeHandle(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "1.eml"), []byte("Subject: first\r\n\r\n1\r\n"), time.Minute)
+
+	// Когда обработчик получает письмо, файл уже в processed
+	r := recorder{result: func(mailsource.Message) bool {
+		if _, err := os.Stat(filepath.Join(root, ProcessedDir, "1.eml")); err != nil {
+			t.Errorf("файл не перенесен до обработки: %v", err)
+		}
+		return true
+	}}
+	s := newTestSource(Dir{Path: root})
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(r.messages); got != 1 {
+		t.Fatalf("писем %d, want 1", got)
+	}
+	if got := len(listNames(t, root)); got != 0 {
+		t.Errorf("в папке осталось %d файлов", got)
+	}
+}
+
+func TestScanFailed(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "broken.msg"), append(append([]byte{}, cfbSignature...), 1, 2, 3), 2*time.Minute)
+	writeFile(t, filepath.Join(root, "ok.eml"), []byte("Subject: ok\r\n\r\ntext\r\n"), time.Minute)
+
+	var logs []string
+	var r recorder
+	s := newTestSource(Dir{Path: root})
+	s.Errorf = func(format string, args ...interface{}) { logs = append(logs, format) }
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.Join(r.subjects(), "|"); got != "ok" {
+		t.Errorf("письма = %s", got)
+	}
+	if got := strings.Join(listNames(t, filepath.Join(root, FailedDir)), "|"); got != "broken.msg" {
+		t.Errorf("failed = %s", got)
+	}
+	if len(logs) != 1 {
+		t.Errorf("записей в журнале: %d, want 1", len(logs))
+	}
+}
+
+func TestScanSettleTime(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "old.eml"), []byte("Subject: old\r\n\r\n1\r\n"), time.Minute)
+	writeFile(t, filepath.Join(root, "fresh.eml"), []byte("Subject: fresh\r\n\r\n2\r\n"), 0)
+
+	var r recorder
+	s := New([]Dir{{Path: root}})
+	s.SettleTime = 10 * time.Second
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.subjects(), "|"); got != "old" {
+		t.Errorf("письма = %s", got)
+	}
+	if got := strings.Join(listNames(t, root), "|"); got != "fresh.eml" {
+		t.Errorf("в папке осталось: %s", got)
+	}
+}
+
+func TestActions(t *testing.T) {
+	root := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	writeFile(t, filepath.Join(root, "a.eml"), []byte("Subject: a\r\n\r\n1\r\n"), 3*time.Minute)
+	writeFile(t, filepath.Join(root, "b.eml"), []byte("Subject: b\r\n\r\n2\r\n"), 2*time.Minute)
+	writeFile(t, filepath.Join(root, "c.eml"), []byte("Subject: c\r\n\r\n3\r\n"), time.Minute)
+	// Файл с таким же именем уже обработан раньше
+	writeFile(t, filepath.Join(root, ProcessedDir, "c.eml"), []byte("Subject: c\r\n\r\nold\r\n"), time.Hour)
+
+	var r recorder
+	s := newTestSource(Dir{Path: root})
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+
+	// Действия выполняются после обработки, когда файлы уже в processed
+	for _, msg := range r.messages {
+		err := s.Act(context.Background(), msg.ID, func(actions mailsource.Actions) error {
+			if err := actions.MarkRead(); err != nil {
+				return err
+			}
+			if err := actions.Categorize("Важное"); err == nil {
+				t.Error("Categorize: ожидалась ошибка")
+			}
+			var err error
+			switch msg.Subject {
+			case "a":
+				_, err = actions.Move("Zabbix")
+			case "b":
+				_, err = actions.Move(archive)
+			}
+			return err
+		})
+		if err != nil {
+			t.Errorf("%s: %v", msg.Subject, err)
+		}
+	}
+
+	if got := strings.Join(listNames(t, filepath.Join(root, "Zabbix")), "|"); got != "a.eml" {
+		t.Errorf("Zabbix = %s", got)
+	}
+	if got := strings.Join(listNames(t, archive), "|"); got != "b.eml" {
+		t.Errorf("archive = %s", got)
+	}
+	if got := strings.Join(listNames(t, filepath.Join(root, ProcessedDir)), "|"); got != "c-1.eml|c.eml" {
+		t.Errorf("processed = %s", got)
+	}
+}
+
+func TestActProcessedWithNumber(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "c.eml"), []byte("Subject: c\r\n\r\nnew\r\n"), time.Minute)
+	writeFile(t, filepath.Join(root, ProcessedDir, "c.eml"), []byte("Subject: c\r\n\r\nold\r\n"), time.Hour)
+
+	var r recorder
+	s := newTestSource(Dir{Path: root})
+	if err := s.scan(s.dirs[0], r.handle); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Act(context.Background(), r.messages[0].ID, func(actions mailsource.Actions) error {
+		_, err := actions.Move("done")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Перенесен новый файл (c-1.eml), а не обработанный раньше с тем же именем
+	if got := strings.Join(listNames(t, filepath.Join(root, "done")), "|"); got != "c-1.eml" {
+		t.Errorf("done = %s", got)
+	}
+
+	if err := s.Act(context.Background(), "file:/elsewhere/x.eml:1:2", func(mailsource.Actions) error { return nil }); err == nil {
+		t.Error("ожидалась ошибка для файла вне папок-приемников")
+	}
+}
+
+func TestRun(t *testing.T) {
+	root := t.TempDir()
+	var r recorder
+	s := newTestSource(Dir{Path: root})
+	s.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx, r.handle) }()
+
+	writeFile(t, filepath.Join(root, "new.eml"), []byte("Subject: new\r\n\r\ntext\r\n"), time.Minute)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(r.subjects()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(r.subjects(), "|"); got != "new" {
+		t.Errorf("письма = %s", got)
+	}
+}
@@ -0,0 +1,267 @@
+package dropsource
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/emersion/go-message/charset"
+	"github.com/richardlehane/mscfb"
+
+	"otn/mailsource"
+)
+
+// Свойства MAPI, которые читаются из файла .msg
+const (
+	propImportance       = 0x0017
+	propSubject          = 0x0037
+	propClientSubmitTime = 0x0039
+	propSenderName       = 0x0C1A
+	propSenderEmail      = 0x0C1F
+	propDeliveryTime     = 0x0E06
+	propBody             = 0x1000
+	propHTML             = 0x1013
+	propMessageID        = 0x1035
+	propReferences       = 0x1039
+	propInReplyTo        = 0x1042
+	propDisplayName      = 0x3001
+	propAttachData       = 0x3701
+	propAttachFilename   = 0x3704
+	propAttachLongName   = 0x3707
+	propAttachMimeTag    = 0x370E
+	propInternetCodepage = 0x3FDE
+	propMessageCodepage  = 0x3FFD
+	propSenderSMTP       = 0x5D01
+)
+
+// Типы свойств MAPI
+const (
+	typeString8 = 0x001E
+	typeUnicode = 0x001F
+)
+
+// Сигнатура составного файла (Compound File Binary), в котором хранится .msg
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// Свойства одного объекта .msg: самого письма или вложения
+type msgObject struct {
+	streams map[uint32][]byte // Свойства переменной длины, ключ - тег (ID << 16 | тип)
+	sizes   map[uint32]int64  // Размеры потоков (содержимое вложений не читается)
+	fixed   map[uint16]uint64 // Свойства фиксированной длины из __properties_version1.0
+}
+
+func newMsgObject() *msgObject {
+	return &msgObject{
+		streams: make(map[uint32][]byte),
+		sizes:   make(map[uint32]int64),
+		fixed:   make(map[uint16]uint64),
+	}
+}
+
+// parseMSG разбирает письмо Outlook (.msg). Если preferHTML и в письме есть
+// HTML-тело, берется оно, иначе обычный текст
+func parseMSG(r io.ReaderAt, preferHTML bool) (mailsource.Message, error) {
+	doc, err := mscfb.New(r)
+	if err != nil {
+		return mailsource.Message{}, fmt.Errorf("ошибка чтения файла .msg: %v", err)
+	}
+
+	root := newMsgObject()
+	attachments := make(map[string]*msgObject)
+
+	for entry, err := doc.Next(); err == nil; entry, err = doc.Next() {
+		// Свойства письма лежат в корне, свойства вложений - в хранилищах
+		// __attach_version1.0_#XXXXXXXX. Получатели и вложенные письма не нужны
+		var obj *msgObject
+		switch {
+		case len(entry.Path) == 0:
+			obj = root
+		case len(entry.Path) == 1 && strings.HasPrefix(entry.Path[0], "__attach_version1.0_"):
+			obj = attachments[entry.Path[0]]
+			if obj == nil {
+				obj = newMsgObject()
+				attachments[entry.Path[0]] = obj
+			}
+		default:
+			continue
+		}
+
+		switch {
+		case entry.Name == "__properties_version1.0":
+			data, err := io.ReadAll(entry)
+			if err != nil {
+				return mailsource.Message{}, fmt.Errorf("ошибка чтения свойств: %v", err)
+			}
+			// Заголовок потока свойств письма - 32 байта, вложения - 8
+			headerSize := 8
+			if obj == root {
+				headerSize = 32
+			}
+			obj.readFixed(data, headerSize)
+		case strings.HasPrefix(entry.Name, "__substg1.0_"):
+			tag, err := strconv.ParseUint(strings.TrimPrefix(entry.Name, "__substg1.0_"), 16, 32)
+			if err != nil {
+				continue
+			}
+			obj.sizes[uint32(tag)] = entry.Size
+			if uint16(tag>>16) == propAttachData {
+				continue
+			}
+			data, err := io.ReadAll(entry)
+			if err != nil {
+				return mailsource.Message{}, fmt.Errorf("ошибка чтения свойства %s: %v", entry.Name, err)
+			}
+			obj.streams[uint32(tag)] = data
+		}
+	}
+
+	codepage := root.fixed[propInternetCodepage]
+	if codepage == 0 {
+		codepage = root.fixed[propMessageCodepage]
+	}
+
+	msg := mailsource.Message{
+		Subject:    root.text(propSubject, codepage),
+		SenderName: root.text(propSenderName, codepage),
+		Importance: mailsource.ImportanceNormal,
+	}
+	if msg.Subject == "" && len(root.streams) == 0 {
+		return mailsource.Message{}, fmt.Errorf("в файле нет свойств письма")
+	}
+
+	// PidTagSenderEmailAddress у отправителей Exchange - адрес вида /O=..., тогда берем SMTP-адрес
+	msg.SenderEmail = root.text(propSenderSMTP, codepage)
+	if email := root.text(propSenderEmail, codepage); msg.SenderEmail == "" && strings.Contains(email, "@") {
+		msg.SenderEmail = email
+	}
+
+	if v, ok := root.fixed[propImportance]; ok && v <= uint64(mailsource.ImportanceHigh) {
+		msg.Importance = mailsource.Importance(v)
+	}
+	for _, id := range []uint16{propDeliveryTime, propClientSubmitTime} {
+		if v, ok := root.fixed[id]; ok && v != 0 {
+			msg.Received = filetimeToTime(v)
+			break
+		}
+	}
+	msg.ConversationID = msgConversationID(root, codepage)
+
+	text := root.text(propBody, codepage)
+	html := decodeCodepage(root.streams[propHTML<<16|0x0102], codepage)
+	switch {
+	case preferHTML && strings.TrimSpace(html) != "":
+		msg.Body, msg.IsHTML = html, true
+	case text != "":
+		msg.Body = text
+	default:
+		msg.Body, msg.IsHTML = html, html != ""
+	}
+
+	names := make([]string, 0, len(attachments))
+	for name := range attachments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		msg.Attachments = append(msg.Attachments, attachments[name].attachment(codepage))
+	}
+	return msg, nil
+}
+
+// Читает свойства фиксированной длины: записи по 16 байт (тег, флаги, значение)
+func (o *msgObject) readFixed(data []byte, headerSize int) {
+	for i := headerSize; i+16 <= len(data); i += 16 {
+		tag := binary.LittleEndian.Uint32(data[i:])
+		o.fixed[uint16(tag>>16)] = binary.LittleEndian.Uint64(data[i+8:])
+	}
+}
+
+// Строковое свойство в Unicode или в кодировке письма
+func (o *msgObject) text(id uint16, codepage uint64) string {
+	if data, ok := o.streams[uint32(id)<<16|typeUnicode]; ok {
+		return decodeUTF16(data)
+	}
+	if data, ok := o.streams[uint32(id)<<16|typeString8]; ok {
+		return decodeCodepage(data, codepage)
+	}
+	return ""
+}
+
+// Сведения о вложении
+func (o *msgObject) attachment(codepage uint64) mailsource.Attachment {
+	a := mailsource.Attachment{ContentType: o.text(propAttachMimeTag, codepage)}
+	for _, id := range []uint16{propAttachLongName, propAttachFilename, propDisplayName} {
+		if a.Name = o.text(id, codepage); a.Name != "" {
+			break
+		}
+	}
+	for tag, size := range o.sizes {
+		if uint16(tag>>16) == propAttachData {
+			a.Size = size
+		}
+	}
+	return a
+}
+
+// Идентификатор переписки: первое письмо цепочки из References,
+// затем In-Reply-To, затем собственный Message-ID
+func msgConversationID(o *msgObject, codepage uint64) string {
+	for _, id := range []uint16{propReferences, propInReplyTo, propMessageID} {
+		if ids := strings.Fields(o.text(id, codepage)); len(ids) > 0 {
+			return strings.Trim(ids[0], "<>")
+		}
+	}
+	return ""
+}
+
+func decodeUTF16(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
+
+// Кодировки Windows, в которых обычно сохраняются письма
+var codepageCharsets = map[uint64]string{
+	1250:  "windows-1250",
+	1251:  "windows-1251",
+	1252:  "windows-1252",
+	20866: "koi8-r",
+	21866: "koi8-u",
+	28591: "iso-8859-1",
+	28595: "iso-8859-5",
+	65001: "utf-8",
+}
+
+// Декодирует текст в кодировке письма. Неизвестная кодировка считается UTF-8
+func decodeCodepage(data []byte, codepage uint64) string {
+	name, ok := codepageCharsets[codepage]
+	if !ok || name == "utf-8" {
+		return strings.TrimRight(string(data), "\x00")
+	}
+	r, err := charset.Reader(name, strings.NewReader(string(data)))
+	if err != nil {
+		return strings.TrimRight(string(data), "\x00")
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return strings.TrimRight(string(data), "\x00")
+	}
+	return strings.TrimRight(string(decoded), "\x00")
+}
+
+// FILETIME (интервалы по 100 нс с 1601 года) в time.Time
+func filetimeToTime(ft uint64) time.Time {
+	const epochDiff = 116444736000000000 // Интервалов между 1601 и 1970 годами
+	if ft < epochDiff {
+		return time.Time{}
+	}
+	ticks := ft - epochDiff
+	return time.Unix(int64(ticks/1e7), int64(ticks%1e7)*100)
+}
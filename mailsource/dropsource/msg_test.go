@@ -0,0 +1,300 @@
+package dropsource
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"otn/mailsource"
+)
+
+// Элемент составного файла: хранилище (каталог) или поток
+type cfbEntry struct {
+	name     string
+	data     []byte
+	children []*cfbEntry // Не nil - хранилище
+}
+
+func storage(name string, children ...*cfbEntry) *cfbEntry {
+	return &cfbEntry{name: name, children: children}
+}
+
+func stream(name string, data []byte) *cfbEntry {
+	return &cfbEntry{name: name, data: data}
+}
+
+// Собирает минимальный составной файл версии 3. Все потоки меньше 4096 байт
+// и лежат в мини-потоке, соседние элементы связаны цепочкой правых соседей
+func buildCFB(entries ...*cfbEntry) []byte {
+	const (
+		sectorSize = 512
+		freeSect   = 0xFFFFFFFF
+		endOfChain = 0xFFFFFFFE
+		fatSect    = 0xFFFFFFFD
+		noStream   = 0xFFFFFFFF
+	)
+
+	type dirEntry struct {
+		name        string
+		kind        byte
+		right       uint32
+		child       uint32
+		start, size uint32
+	}
+	dir := []dirEntry{{name: "Root Entry", kind: 5, right: noStream, child: noStream}}
+	var mini []byte
+	var miniFAT []uint32
+
+	var add func(children []*cfbEntry) uint32
+	add = func(children []*cfbEntry) uint32 {
+		first := uint32(noStream)
+		prev := -1
+		for _, e := range children {
+			i := len(dir)
+			dir = append(dir, dirEntry{name: e.name, kind: 2, right: noStream, child: noStream})
+			if e.children != nil {
+				dir[i].kind = 1
+				dir[i].child = add(e.children)
+			} else {
+				dir[i].start = uint32(len(miniFAT))
+				dir[i].size = uint32(len(e.data))
+				n := (len(e.data) + 63) / 64
+				for j := 0; j < n; j++ {
+					next := uint32(len(miniFAT) + 1)
+					if j == n-1 {
+						next = endOfChain
+					}
+					miniFAT = append(miniFAT, next)
+				}
+				mini = append(mini, e.data...)
+				mini = append(mini, make([]byte, n*64-len(e.data))...)
+			}
+			if prev < 0 {
+				first = uint32(i)
+			} else {
+				dir[prev].right = uint32(i)
+			}
+			prev = i
+		}
+		return first
+	}
+	dir[0].child = add(entries)
+
+	sectors := func(n int) int { return (n + sectorSize - 1) / sectorSize }
+	dirSectors := sectors(len(dir) * 128)
+	miniFATSectors := sectors(len(miniFAT) * 4)
+	miniSectors := sectors(len(mini))
+
+	// Сектор 0 - FAT, затем каталог, мини-FAT и мини-поток
+	fat := []uint32{fatSect}
+	chain := func(n int) uint32 {
+		start := uint32(len(fat))
+		for i := 0; i < n; i++ {
+			next := uint32(len(fat) + 1)
+			if i == n-1 {
+				next = endOfChain
+			}
+			fat = append(fat, next)
+		}
+		return start
+	}
+	dirStart := chain(dirSectors)
+	miniFATStart := chain(miniFATSectors)
+	dir[0].start = chain(miniSectors)
+	dir[0].size = uint32(len(mini))
+
+	le := binary.LittleEndian
+	header := make([]byte, sectorSize)
+	copy(header, cfbSignature)
+	le.PutUint16(header[24:], 0x003E)
+	le.PutUint16(header[26:], 3)
+	le.PutUint16(header[28:], 0xFFFE)
+	le.PutUint16(header[30:], 9)
+	le.PutUint16(header[32:], 6)
+	le.PutUint32(header[44:], 1)
+	le.PutUint32(header[48:], dirStart)
+	le.PutUint32(header[56:], 4096)
+	le.PutUint32(header[60:], miniFATStart)
+	le.PutUint32(header[64:], uint32(miniFATSectors))
+	le.PutUint32(header[68:], endOfChain)
+	for i := 76; i < sectorSize; i += 4 {
+		le.PutUint32(header[i:], freeSect)
+	}
+	le.PutUint32(header[76:], 0)
+
+	fatSector := make([]byte, sectorSize)
+	for i := 0; i < sectorSize/4; i++ {
+		v := uint32(freeSect)
+		if i < len(fat) {
+			v = fat[i]
+		}
+		le.PutUint32(fatSector[i*4:], v)
+	}
+
+	dirData := make([]byte, dirSectors*sectorSize)
+	for i := range dirData[len(dir)*128:] {
+		if i%128 >= 68 && i%128 < 80 {
+			dirData[len(dir)*128+i] = 0xFF // Пустые записи без соседей
+		}
+	}
+	for i, e := range dir {
+		b := dirData[i*128:]
+		name := utf16.Encode([]rune(e.name))
+		for j, u := range name {
+			le.PutUint16(b[j*2:], u)
+		}
+		le.PutUint16(b[64:], uint16(len(name)*2+2))
+		b[66] = e.kind
+		b[67] = 1
+		le.PutUint32(b[68:], noStream)
+		le.PutUint32(b[72:], e.right)
+		le.PutUint32(b[76:], e.child)
+		le.PutUint32(b[116:], e.start)
+		le.PutUint32(b[120:], e.size)
+	}
+
+	miniFATData := make([]byte, miniFATSectors*sectorSize)
+	for i := range miniFATData {
+		miniFATData[i] = 0xFF
+	}
+	for i, v := range miniFAT {
+		le.PutUint32(miniFATData[i*4:], v)
+	}
+
+	var out bytes.Buffer
+	out.Write(header)
+	out.Write(fatSector)
+	out.Write(dirData)
+	out.Write(miniFATData)
+	out.Write(mini)
+	out.Write(make([]byte, miniSectors*sectorSize-len(mini)))
+	return out.Bytes()
+}
+
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return append(b, 0, 0)
+}
+
+// Поток свойств фиксированной длины: заголовок headerSize байт и записи по 16 байт
+func properties(headerSize int, props map[uint32]uint64) []byte {
+	b := make([]byte, headerSize)
+	for tag, value := range props {
+		entry := make([]byte, 16)
+		binary.LittleEndian.PutUint32(entry, tag)
+		binary.LittleEndian.PutUint64(entry[8:], value)
+		b = append(b, entry...)
+	}
+	return b
+}
+
+// Время в формате FILETIME
+func filetime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+var testReceived = time.Date(2024, 5, 14, 9, 30, 0, 0, time.UTC)
+
+// Письмо .msg с HTML-телом и одним вложением
+func testMSG() []byte {
+	return buildCFB(
+		stream("__properties_version1.0", properties(32, map[uint32]uint64{
+			0x00170003: 2,
+			0x0E060040: filetime(testReceived),
+		})),
+		stream("__substg1.0_0037001F", utf16Bytes("Авария на сервере")),
+		stream("__substg1.0_0C1A001F", utf16Bytes("Zabbix")),
+		stream("__substg1.0_0C1F001F", utf16Bytes("/O=EXCHANGE/OU=FIRST/CN=ZABBIX")),
+		stream("__substg1.0_5D01001F", utf16Bytes("zabbix@example.com")),
+		stream("__substg1.0_1000001F", utf16Bytes("Сервер db1 недоступен")),
+		stream("__substg1.0_10130102", []byte("<p>Сервер <b>db1</b> недоступен</p>")),
+		stream("__substg1.0_1035001F", utf16Bytes("<msg1@example.com>")),
+		stream("__substg1.0_1039001F", utf16Bytes("<root@example.com> <prev@example.com>")),
+		storage("__recip_version1.0_#00000000",
+			stream("__substg1.0_3001001F", utf16Bytes("Дежурный")),
+		),
+		storage("__attach_version1.0_#00000000",
+			stream("__properties_version1.0", properties(8, nil)),
+			stream("__substg1.0_3707001F", utf16Bytes("отчет.pdf")),
+			stream("__substg1.0_370E001F", utf16Bytes("application/pdf")),
+			stream("__substg1.0_37010102", bytes.Repeat([]byte{1}, 300)),
+		),
+	)
+}
+
+func TestParseMSG(t *testing.T) {
+	msg, err := parseMSG(bytes.NewReader(testMSG()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Subject != "Авария на сервере" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if msg.Sender() != "Zabbix <zabbix@example.com>" {
+		t.Errorf("Sender() = %q", msg.Sender())
+	}
+	if msg.Body != "Сервер db1 недоступен" || msg.IsHTML {
+		t.Errorf("Body = %q, IsHTML = %v", msg.Body, msg.IsHTML)
+	}
+	if msg.Importance != mailsource.ImportanceHigh {
+		t.Errorf("Importance = %v", msg.Importance)
+	}
+	if !msg.Received.Equal(testReceived) {
+		t.Errorf("Received = %v, want %v", msg.Received, testReceived)
+	}
+	if msg.ConversationID != "root@example.com" {
+		t.Errorf("ConversationID = %q", msg.ConversationID)
+	}
+	want := []mailsource.Attachment{{Name: "отчет.pdf", ContentType: "application/pdf", Size: 300}}
+	if len(msg.Attachments) != 1 || msg.Attachments[0] != want[0] {
+		t.Errorf("Attachments = %+v, want %+v", msg.Attachments, want)
+	}
+
+	msg, err = parseMSG(bytes.NewReader(testMSG()), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !msg.IsHTML || !strings.Contains(msg.Body, "<b>db1</b>") {
+		t.Errorf("Body = %q, IsHTML = %v", msg.Body, msg.IsHTML)
+	}
+}
+
+func TestParseMSGCodepage(t *testing.T) {
+	// "Привет" в windows-1251
+	subject := []byte{0xCF, 0xF0, 0xE8, 0xE2, 0xE5, 0xF2, 0}
+	data := buildCFB(
+		stream("__properties_version1.0", properties(32, map[uint32]uint64{0x3FFD0003: 1251})),
+		stream("__substg1.0_0037001E", subject),
+		stream("__substg1.0_0C1F001E", []byte("ivan@example.com\x00")),
+	)
+
+	msg, err := parseMSG(bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Привет" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if msg.SenderEmail != "ivan@example.com" {
+		t.Errorf("SenderEmail = %q", msg.SenderEmail)
+	}
+	if msg.Importance != mailsource.ImportanceNormal {
+		t.Errorf("Importance = %v", msg.Importance)
+	}
+}
+
+func TestParseMSGErrors(t *testing.T) {
+	if _, err := parseMSG(bytes.NewReader(append(append([]byte{}, cfbSignature...), 1, 2, 3)), false); err == nil {
+		t.Error("ожидалась ошибка для обрезанного файла")
+	}
+	if _, err := parseMSG(bytes.NewReader(buildCFB(stream("Другое", []byte("x")))), false); err == nil {
+		t.Error("ожидалась ошибка для файла без свойств письма")
+	}
+}
@@ -0,0 +1,169 @@
+package graphsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ошибка Graph API
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// Клиент Graph API с токеном приложения
+type api struct {
+	cfg    Config
+	client func() *http.Client
+
+	mutex   sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// Адрес метода Graph API для почтового ящика
+func (a *api) userURL(format string, args ...interface{}) string {
+	for i, arg := range args {
+		if s, ok := arg.(string); ok {
+			args[i] = url.PathEscape(s)
+		}
+	}
+	return strings.TrimSuffix(a.cfg.GraphURL, "/") + "/users/" + url.PathEscape(a.cfg.Mailbox) + fmt.Sprintf(format, args...)
+}
+
+// Возвращает токен приложения, запрашивая новый незадолго до истечения срока действия
+func (a *api) accessToken(ctx context.Context) (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.token != "" && time.Now().Before(a.expires) {
+		return a.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {a.cfg.ClientID},
+		"client_secret": {a.cfg.ClientSecret},
+		"scope":         {graphScope(a.cfg.GraphURL)},
+	}
+	tokenURL := strings.TrimSuffix(a.cfg.AuthorityURL, "/") + "/" + url.PathEscape(a.cfg.TenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("ошибка получения токена: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("ошибка получения токена: HTTP %d: %v", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("ошибка получения токена: HTTP %d %s: %s", resp.StatusCode, result.Error, result.ErrorDescription)
+	}
+
+	// Обновляем токен за минуту до истечения
+	a.token = result.AccessToken
+	a.expires = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return a.token, nil
+}
+
+// Сбрасывает токен, например после ответа 401
+func (a *api) resetToken() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.token = ""
+}
+
+// Выполняет запрос к Graph API. body и result кодируются в JSON, nil - без тела
+func (a *api) do(ctx context.Context, method, url string, header http.Header, body, result interface{}) error {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		if resp.StatusCode == http.StatusUnauthorized {
+			a.resetToken()
+		}
+		var failure struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		return &apiError{Status: resp.StatusCode, Code: failure.Error.Code, Message: failure.Error.Message}
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("ошибка разбора ответа Graph: %v", err)
+	}
+	return nil
+}
+
+// Область доступа токена: все разрешения приложения для адреса Graph,
+// например https://graph.microsoft.com/.default
+func graphScope(graphURL string) string {
+	u, err := url.Parse(graphURL)
+	if err != nil {
+		return "https://graph.microsoft.com/.default"
+	}
+	return u.Scheme + "://" + u.Host + "/.default"
+}
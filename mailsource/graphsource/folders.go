@@ -0,0 +1,96 @@
+package graphsource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"otn/folderref"
+)
+
+// Имена специальных папок Graph по номерам OlDefaultFolders
+var wellKnownNames = map[int]string{
+	3:  "deleteditems",
+	4:  "outbox",
+	5:  "sentitems",
+	6:  "inbox",
+	16: "drafts",
+	23: "junkemail",
+}
+
+// Дерево папок почтового ящика для folderref. Хранилище одно - сам ящик,
+// его имя - адрес из настроек
+type graphTree struct {
+	source *Source
+	ctx    context.Context
+}
+
+func (t graphTree) Stores() ([]folderref.Store, error) {
+	return []folderref.Store{graphStore(t)}, nil
+}
+
+func (t graphTree) DefaultFolder(id int) (folderref.Folder, error) {
+	name, ok := wellKnownNames[id]
+	if !ok {
+		return nil, fmt.Errorf("неизвестная специальная папка %d", id)
+	}
+	return t.folder(name)
+}
+
+// Загружает папку по ID или имени специальной папки
+func (t graphTree) folder(id string) (*graphFolder, error) {
+	var result graphFolder
+	if err := t.source.api.do(t.ctx, http.MethodGet, t.source.api.userURL("/mailFolders/%s", id)+"?$select=id,displayName", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	result.tree = t
+	return &result, nil
+}
+
+type graphStore graphTree
+
+func (s graphStore) DisplayName() string { return s.source.cfg.Mailbox }
+func (s graphStore) Release()            {}
+
+func (s graphStore) Root() (folderref.Folder, error) {
+	return graphTree(s).folder("msgfolderroot")
+}
+
+func (s graphStore) DefaultFolder(id int) (folderref.Folder, error) {
+	return graphTree(s).DefaultFolder(id)
+}
+
+type graphFolder struct {
+	ID          string `json:"id"`
+	DisplayName string `json:"displayName"`
+
+	tree graphTree
+}
+
+func (f *graphFolder) Name() string { return f.DisplayName }
+func (f *graphFolder) Release()     {}
+
+func (f *graphFolder) Subfolders() ([]folderref.Folder, error) {
+	var result []folderref.Folder
+	link := f.tree.source.api.userURL("/mailFolders/%s/childFolders", f.ID) + "?" + url.Values{
+		"$select": {"id,displayName"},
+		"$top":    {"100"},
+	}.Encode()
+
+	for link != "" {
+		var page struct {
+			Value    []*graphFolder `json:"value"`
+			NextLink string         `json:"@odata.nextLink"`
+		}
+		if err := f.tree.source.api.do(f.tree.ctx, http.MethodGet, link, nil, nil, &page); err != nil {
+			return nil, err
+		}
+		for _, child := range page.Value {
+			child.tree = f.tree
+			result = append(result, child)
+		}
+		link = page.NextLink
+	}
+	return result, nil
+}
@@ -0,0 +1,404 @@
+// Пакет graphsource получает письма почтового ящика Exchange Online через
+// Microsoft Graph с учетными данными приложения (client credentials), без
+// установленного Outlook. Новые письма находятся разностными запросами
+// (delta query): ссылка на следующую выборку хранится для каждой папки, поэтому
+// после перезапуска чтение продолжается с того же места. Выборка возвращает и
+// измененные письма, поэтому уведомление отправляется только о письмах,
+// полученных после отметки папки (Mark). Папки ищутся по тем же правилам, что
+// и в Outlook (пакет folderref).
+package graphsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"otn/folderref"
+	"otn/mailsource"
+)
+
+// Адреса по умолчанию (глобальное облако Microsoft)
+const (
+	DefaultAuthorityURL = "https://login.microsoftonline.com"
+	DefaultGraphURL     = "https://graph.microsoft.com/v1.0"
+)
+
+// Поля писем, запрашиваемые в разностной выборке
+const messageFields = "subject,from,receivedDateTime,importance,conversationId,isRead,body"
+
+// Config - почтовый ящик и регистрация приложения в Entra ID. Приложению нужно
+// разрешение Mail.ReadWrite (или Mail.Read, если не используется after_send)
+type Config struct {
+	Name         string `json:"name"`
+	TenantID     string `json:"tenant_id"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	Mailbox      string `json:"mailbox"` // Адрес или ID пользователя
+
+	AuthorityURL string `json:"authority_url,omitempty"` // Для национальных облаков
+	GraphURL     string `json:"graph_url,omitempty"`
+}
+
+// Validate проверяет настройки
+func (c Config) Validate() error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("не указано имя учетной записи")
+	case c.TenantID == "":
+		return fmt.Errorf("не указан tenant_id")
+	case c.ClientID == "" || c.ClientSecret == "":
+		return fmt.Errorf("не указаны client_id и client_secret")
+	case c.Mailbox == "":
+		return fmt.Errorf("не указан mailbox")
+	}
+	for _, u := range []string{c.AuthorityURL, c.GraphURL} {
+		if u == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("некорректный адрес %q", u)
+		}
+	}
+	return nil
+}
+
+// MailFolder - отслеживаемая папка
+type MailFolder struct {
+	Path         string // Ссылка на папку по правилам folderref: "@inbox/Zabbix", "Zabbix"
+	Folder       string // Папка, под которой письма попадают в уведомления
+	HTML         bool   // Брать HTML-тело письма
+	StartFromNow bool   // При первом запуске не отправлять уже лежащие в папке письма
+}
+
+// DeltaStore хранит для папки ссылку на следующую разностную выборку
+// ("" - ссылки нет) и отметку последнего отправленного письма
+type DeltaStore interface {
+	LoadDelta(key string) string
+	SaveDelta(key, deltaLink string)
+	LoadMark(key string) Mark
+	SaveMark(key string, mark Mark)
+}
+
+// Mark - время получения последнего отправленного письма папки и ID писем,
+// полученных в ту же секунду. Разностная выборка возвращает и измененные
+// письма (с новой категорией, флагом, прочитанные в другом клиенте), а
+// отметка отличает их от новых и после перезапуска программы
+type Mark struct {
+	Received time.Time `json:"received"`
+	IDs      []string  `json:"ids,omitempty"`
+}
+
+// Письмо получено после отметки: позже нее или в ту же секунду, но еще не отправлялось
+func (m Mark) precedes(id string, received time.Time) bool {
+	if !received.Equal(m.Received) {
+		return received.After(m.Received)
+	}
+	for _, seen := range m.IDs {
+		if seen == id {
+			return false
+		}
+	}
+	return true
+}
+
+// Отметка после отправки письма
+func (m Mark) advance(id string, received time.Time) Mark {
+	switch {
+	case received.After(m.Received):
+		return Mark{Received: received, IDs: []string{id}}
+	case received.Equal(m.Received):
+		return Mark{Received: m.Received, IDs: append(append([]string(nil), m.IDs...), id)}
+	}
+	return m
+}
+
+// Source - источник писем одного почтового ящика Graph
+type Source struct {
+	cfg       Config
+	folders   []MailFolder
+	store     DeltaStore
+	api       *api
+	folderIDs map[string]string // Найденные папки: ссылка -> ID
+
+	// Интервал разностных запросов
+	PollInterval time.Duration
+	// HTTP-клиент (например, с прокси); по умолчанию http.DefaultClient
+	Client *http.Client
+	// Журнал; по умолчанию сообщения не выводятся
+	Logf func(format string, args ...interface{})
+	// Журнал ошибок; по умолчанию ошибки пишутся в Logf
+	Errorf func(format string, args ...interface{})
+}
+
+// New создает источник для почтового ящика и списка папок
+func New(cfg Config, folders []MailFolder, store DeltaStore) (*Source, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	for _, folder := range folders {
+		if _, err := folderref.Parse(folder.Path); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.AuthorityURL == "" {
+		cfg.AuthorityURL = DefaultAuthorityURL
+	}
+	if cfg.GraphURL == "" {
+		cfg.GraphURL = DefaultGraphURL
+	}
+
+	s := &Source{
+		cfg:          cfg,
+		folders:      folders,
+		store:        store,
+		folderIDs:    make(map[string]string),
+		PollInterval: time.Minute,
+		Logf:         func(string, ...interface{}) {},
+	}
+	s.api = &api{cfg: cfg, client: func() *http.Client {
+		if s.Client != nil {
+			return s.Client
+		}
+		return http.DefaultClient
+	}}
+	return s, nil
+}
+
+// Name возвращает имя источника
+func (s *Source) Name() string {
+	return "graph:" + s.cfg.Name
+}
+
+// Сообщает об ошибке через Errorf, а если он не задан - через Logf
+func (s *Source) errorf(format string, args ...interface{}) {
+	if s.Errorf != nil {
+		s.Errorf(format, args...)
+		return
+	}
+	s.Logf(format, args...)
+}
+
+// Run проверяет папки раз в PollInterval и передает новые письма в handle, пока
+// не отменен ctx. Ошибка в одной папке не мешает проверке остальных
+func (s *Source) Run(ctx context.Context, handle mailsource.Handler) error {
+	for {
+		for _, folder := range s.folders {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := s.syncFolder(ctx, folder, handle); err != nil && ctx.Err() == nil {
+				s.errorf("Graph %s, папка %s: %v", s.cfg.Name, folder.Path, err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(s.PollInterval):
+		}
+	}
+}
+
+// Страница разностной выборки
+type deltaPage struct {
+	Value     []graphMessage `json:"value"`
+	NextLink  string         `json:"@odata.nextLink"`
+	DeltaLink string         `json:"@odata.deltaLink"`
+}
+
+type graphMessage struct {
+	ID      string `json:"id"`
+	Subject string `json:"subject"`
+	From    *struct {
+		EmailAddress struct {
+			Name    string `json:"name"`
+			Address string `json:"address"`
+		} `json:"emailAddress"`
+	} `json:"from"`
+	ReceivedDateTime time.Time `json:"receivedDateTime"`
+	Importance       string    `json:"importance"`
+	ConversationID   string    `json:"conversationId"`
+	IsRead           bool      `json:"isRead"`
+	Body             struct {
+		ContentType string `json:"contentType"`
+		Content     string `json:"content"`
+	} `json:"body"`
+	Removed *struct {
+		Reason string `json:"reason"`
+	} `json:"@removed"`
+}
+
+// Выполняет разностную выборку папки. Уведомления отправляются о непрочитанных
+// письмах, полученных после отметки папки; новая ссылка и отметка сохраняются,
+// только если все уведомления доставлены, иначе выборка повторяется со старой
+// ссылки при следующей проверке
+func (s *Source) syncFolder(ctx context.Context, folder MailFolder, handle mailsource.Handler) error {
+	folderID, ok := s.folderIDs[folder.Path]
+	if !ok {
+		var err error
+		if folderID, err = s.resolveFolder(ctx, folder.Path); err != nil {
+			return err
+		}
+		s.folderIDs[folder.Path] = folderID
+	}
+
+	key := s.cfg.Name + "/" + folderID
+	link := s.store.LoadDelta(key)
+	// Письма сравниваются с отметкой на начало выборки: в выборке они идут
+	// не по времени получения
+	mark := s.store.LoadMark(key)
+	newMark := mark
+	if link == "" {
+		query := url.Values{"$select": {messageFields}}
+		if folder.StartFromNow {
+			// Первый запуск: начальная выборка содержит только новые письма
+			query.Set("$filter", "receivedDateTime ge "+time.Now().UTC().Format(time.RFC3339))
+		}
+		link = s.api.userURL("/mailFolders/%s/messages/delta", folderID) + "?" + query.Encode()
+	}
+
+	header := http.Header{}
+	contentType := "text"
+	if folder.HTML {
+		contentType = "html"
+	}
+	header.Add("Prefer", `outlook.body-content-type="`+contentType+`"`)
+	header.Add("Prefer", "odata.maxpagesize=50")
+
+	for link != "" {
+		var page deltaPage
+		if err := s.api.do(ctx, http.MethodGet, link, header, nil, &page); err != nil {
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				switch apiErr.Status {
+				case http.StatusNotFound:
+					// Папка удалена или перемещена: найдем ее заново
+					delete(s.folderIDs, folder.Path)
+				case http.StatusGone:
+					// Ссылка устарела: начнем выборку заново
+					s.store.SaveDelta(key, "")
+				}
+			}
+			return fmt.Errorf("ошибка разностного запроса: %v", err)
+		}
+
+		for _, m := range page.Value {
+			if m.Removed != nil || m.IsRead || !mark.precedes(m.ID, m.ReceivedDateTime) {
+				continue
+			}
+			if !handle(s.message(m, folder)) {
+				return nil // Повторим со старой ссылки
+			}
+			newMark = newMark.advance(m.ID, m.ReceivedDateTime)
+		}
+
+		if page.DeltaLink != "" {
+			s.store.SaveMark(key, newMark)
+			s.store.SaveDelta(key, page.DeltaLink)
+			return nil
+		}
+		link = page.NextLink
+	}
+	return fmt.Errorf("в ответе нет @odata.deltaLink")
+}
+
+// Преобразует письмо Graph в общую структуру
+func (s *Source) message(m graphMessage, folder MailFolder) mailsource.Message {
+	msg := mailsource.Message{
+		ID:             s.messageID(m.ID),
+		Folder:         folder.Folder,
+		Subject:        m.Subject,
+		Body:           m.Body.Content,
+		IsHTML:         strings.EqualFold(m.Body.ContentType, "html"),
+		ConversationID: m.ConversationID,
+		Importance:     mailsource.ImportanceNormal,
+		Received:       m.ReceivedDateTime,
+	}
+	if m.From != nil {
+		msg.SenderName = m.From.EmailAddress.Name
+		msg.SenderEmail = m.From.EmailAddress.Address
+	}
+	switch strings.ToLower(m.Importance) {
+	case "high":
+		msg.Importance = mailsource.ImportanceHigh
+	case "low":
+		msg.Importance = mailsource.ImportanceLow
+	}
+	return msg
+}
+
+// Находит ID папки по ссылке
+func (s *Source) resolveFolder(ctx context.Context, path string) (string, error) {
+	folder, err := folderref.Resolve(graphTree{source: s, ctx: ctx}, path)
+	if err != nil {
+		return "", err
+	}
+	return folder.(*graphFolder).ID, nil
+}
+
+// Идентификатор письма для уведомлений: учетная запись и ID письма в Graph
+func (s *Source) messageID(id string) string {
+	return "graph:" + s.cfg.Name + ":" + id
+}
+
+// Act выполняет действия над письмом. ID письма Graph не зависит от
+// соединения, поэтому действия можно выполнять в любой момент после обработки
+func (s *Source) Act(ctx context.Context, id string, fn func(mailsource.Actions) error) error {
+	messageID, ok := strings.CutPrefix(id, "graph:"+s.cfg.Name+":")
+	if !ok || messageID == "" {
+		return fmt.Errorf("письмо %s не относится к учетной записи %s", id, s.cfg.Name)
+	}
+	return fn(&graphActions{source: s, ctx: ctx, id: messageID})
+}
+
+// Действия after_send над письмом
+type graphActions struct {
+	source *Source
+	ctx    context.Context
+	id     string
+}
+
+func (a *graphActions) MarkRead() error {
+	body := map[string]interface{}{"isRead": true}
+	return a.source.api.do(a.ctx, http.MethodPatch, a.source.api.userURL("/messages/%s", a.id), nil, body, nil)
+}
+
+// Move перемещает письмо и возвращает его новый идентификатор: в Graph ID письма меняется при перемещении
+func (a *graphActions) Move(folder string) (string, error) {
+	destination, err := a.source.resolveFolder(a.ctx, folder)
+	if err != nil {
+		return "", err
+	}
+
+	var moved struct {
+		ID string `json:"id"`
+	}
+	body := map[string]string{"destinationId": destination}
+	if err := a.source.api.do(a.ctx, http.MethodPost, a.source.api.userURL("/messages/%s/move", a.id), nil, body, &moved); err != nil {
+		return "", err
+	}
+	a.id = moved.ID
+	return a.source.messageID(moved.ID), nil
+}
+
+// Categorize добавляет категорию к письму, сохраняя уже назначенные
+func (a *graphActions) Categorize(category string) error {
+	var current struct {
+		Categories []string `json:"categories"`
+	}
+	if err := a.source.api.do(a.ctx, http.MethodGet, a.source.api.userURL("/messages/%s", a.id)+"?$select=categories", nil, nil, &current); err != nil {
+		return err
+	}
+	for _, c := range current.Categories {
+		if c == category {
+			return nil
+		}
+	}
+
+	body := map[string]interface{}{"categories": append(current.Categories, category)}
+	return a.source.api.do(a.ctx, http.MethodPatch, a.source.api.userURL("/messages/%s", a.id), nil, body, nil)
+}
@@ -0,0 +1,655 @@
+package graphsource
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"otn/mailsource"
+)
+
+type stubFolder struct {
+	ID, Name, Parent string
+}
+
+type stubMessage struct {
+	ID, Folder  string
+	Subject     string
+	FromName    string
+	FromAddress string
+	Read        bool
+	Importance  string
+	Text, HTML  string
+	Received    time.Time
+	Categories  []string
+	Deleted     bool
+	Version     int // Номер последнего изменения
+}
+
+// Заглушка Graph API: токены, папки, разностные выборки и действия над письмами
+type graphStub struct {
+	t      *testing.T
+	mutex  sync.Mutex
+	server *httptest.Server
+
+	folders   []stubFolder
+	wellKnown map[string]string
+	messages  []*stubMessage
+	version   int
+	pageSize  int
+
+	tokens    int // Сколько раз выдавался токен
+	token     string
+	expiresIn int
+}
+
+func newGraphStub(t *testing.T) *graphStub {
+	stub := &graphStub{
+		t: t,
+		folders: []stubFolder{
+			{ID: "root", Name: "Top of Information Store"},
+			{ID: "inbox-id", Name: "Inbox", Parent: "root"},
+			{ID: "zabbix-id", Name: "Zabbix", Parent: "inbox-id"},
+			{ID: "archive-id", Name: "Archive", Parent: "root"},
+		},
+		wellKnown: map[string]string{"msgfolderroot": "root", "inbox": "inbox-id", "deleteditems": "archive-id"},
+		pageSize:  2,
+		expiresIn: 3600,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /tenant/oauth2/v2.0/token", stub.handleToken)
+	mux.HandleFunc("GET /v1.0/users/ops@example.com/mailFolders/{id}", stub.auth(stub.handleFolder))
+	mux.HandleFunc("GET /v1.0/users/ops@example.com/mailFolders/{id}/childFolders", stub.auth(stub.handleChildFolders))
+	mux.HandleFunc("GET /v1.0/users/ops@example.com/mailFolders/{id}/messages/delta", stub.auth(stub.handleDelta))
+	mux.HandleFunc("GET /v1.0/users/ops@example.com/messages/{id}", stub.auth(stub.handleGetMessage))
+	mux.HandleFunc("PATCH /v1.0/users/ops@example.com/messages/{id}", stub.auth(stub.handlePatchMessage))
+	mux.HandleFunc("POST /v1.0/users/ops@example.com/messages/{id}/move", stub.auth(stub.handleMove))
+
+	stub.server = httptest.NewServer(mux)
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+// Добавляет письмо в папку
+func (s *graphStub) add(m stubMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.version++
+	m.Version = s.version
+	if m.Received.IsZero() {
+		m.Received = time.Now()
+	}
+	s.messages = append(s.messages, &m)
+}
+
+func (s *graphStub) find(id string) *stubMessage {
+	for _, m := range s.messages {
+		if m.ID == id && !m.Deleted {
+			return m
+		}
+	}
+	return nil
+}
+
+func (s *graphStub) message(id string) stubMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if m := s.find(id); m != nil {
+		return *m
+	}
+	s.t.Fatalf("письмо %s не найдено", id)
+	return stubMessage{}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func graphError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]interface{}{"error": map[string]string{"code": code, "message": code}})
+}
+
+func (s *graphStub) handleToken(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	r.ParseForm()
+	if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("client_id") != "app" ||
+		r.Form.Get("client_secret") != "secret" || r.Form.Get("scope") != s.server.URL+"/.default" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": "bad credentials"})
+		return
+	}
+	s.tokens++
+	s.token = fmt.Sprintf("token-%d", s.tokens)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": s.token, "expires_in": s.expiresIn, "token_type": "Bearer"})
+}
+
+func (s *graphStub) auth(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if r.Header.Get("Authorization") != "Bearer "+s.token {
+			graphError(w, http.StatusUnauthorized, "InvalidAuthenticationToken")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *graphStub) folderByID(id string) (stubFolder, bool) {
+	if known, ok := s.wellKnown[id]; ok {
+		id = known
+	}
+	for _, f := range s.folders {
+		if f.ID == id {
+			return f, true
+		}
+	}
+	return stubFolder{}, false
+}
+
+func (s *graphStub) handleFolder(w http.ResponseWriter, r *http.Request) {
+	f, ok := s.folderByID(r.PathValue("id"))
+	if !ok {
+		graphError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"id": f.ID, "displayName": f.Name})
+}
+
+func (s *graphStub) handleChildFolders(w http.ResponseWriter, r *http.Request) {
+	parent, ok := s.folderByID(r.PathValue("id"))
+	if !ok {
+		graphError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+	children := []map[string]string{}
+	for _, f := range s.folders {
+		if f.Parent == parent.ID {
+			children = append(children, map[string]string{"id": f.ID, "displayName": f.Name})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"value": children})
+}
+
+// Разностная выборка: $deltatoken - номер изменения, после которого нужны письма,
+// $skiptoken - смещение следующей страницы
+func (s *graphStub) handleDelta(w http.ResponseWriter, r *http.Request) {
+	folder, ok := s.folderByID(r.PathValue("id"))
+	if !ok {
+		graphError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+
+	query := r.URL.Query()
+	base, _ := strconv.Atoi(query.Get("$deltatoken"))
+	skip, _ := strconv.Atoi(query.Get("$skiptoken"))
+	var since time.Time
+	if filter := query.Get("$filter"); filter != "" {
+		since, _ = time.Parse(time.RFC3339, strings.TrimPrefix(filter, "receivedDateTime ge "))
+	}
+	html := strings.Contains(strings.Join(r.Header.Values("Prefer"), ","), `outlook.body-content-type="html"`)
+
+	var changed []*stubMessage
+	for _, m := range s.messages {
+		if m.Folder == folder.ID && m.Version > base && !m.Received.Before(since) && (base > 0 || !m.Deleted) {
+			changed = append(changed, m)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Version < changed[j].Version })
+
+	var value []map[string]interface{}
+	for i := skip; i < len(changed) && i < skip+s.pageSize; i++ {
+		m := changed[i]
+		if m.Deleted {
+			value = append(value, map[string]interface{}{"id": m.ID, "@removed": map[string]string{"reason": "deleted"}})
+			continue
+		}
+		body := map[string]string{"contentType": "text", "content": m.Text}
+		if html {
+			body = map[string]string{"contentType": "html", "content": m.HTML}
+		}
+		value = append(value, map[string]interface{}{
+			"id":               m.ID,
+			"subject":          m.Subject,
+			"from":             map[string]interface{}{"emailAddress": map[string]string{"name": m.FromName, "address": m.FromAddress}},
+			"receivedDateTime": m.Received.UTC().Format(time.RFC3339),
+			"importance":       m.Importance,
+			"conversationId":   "conv-" + m.ID,
+			"isRead":           m.Read,
+			"body":             body,
+		})
+	}
+
+	link := s.server.URL + r.URL.Path + "?"
+	result := map[string]interface{}{"value": value}
+	if skip+s.pageSize < len(changed) {
+		next := url.Values{}
+		for key, values := range query {
+			next[key] = values
+		}
+		next.Set("$skiptoken", strconv.Itoa(skip+s.pageSize))
+		result["@odata.nextLink"] = link + next.Encode()
+	} else {
+		result["@odata.deltaLink"] = link + url.Values{"$deltatoken": {strconv.Itoa(s.version)}}.Encode()
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *graphStub) handleGetMessage(w http.ResponseWriter, r *http.Request) {
+	m := s.find(r.PathValue("id"))
+	if m == nil {
+		graphError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": m.ID, "categories": m.Categories})
+}
+
+func (s *graphStub) handlePatchMessage(w http.ResponseWriter, r *http.Request) {
+	m := s.find(r.PathValue("id"))
+	if m == nil {
+		graphError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+	var patch struct {
+		IsRead     *bool    `json:"isRead"`
+		Categories []string `json:"categories"`
+	}
+	json.NewDecoder(r.Body).Decode(&patch)
+	if patch.IsRead != nil {
+		m.Read = *patch.IsRead
+	}
+	if patch.Categories != nil {
+		m.Categories = patch.Categories
+	}
+	s.version++
+	m.Version = s.version
+	writeJSON(w, http.StatusOK, map[string]string{"id": m.ID})
+}
+
+func (s *graphStub) handleMove(w http.ResponseWriter, r *http.Request) {
+	m := s.find(r.PathValue("id"))
+	var req struct {
+		DestinationID string `json:"destinationId"`
+	}
+	json.NewDecoder(r.Body).Decode(&req)
+	if m == nil || req.DestinationID == "" {
+		graphError(w, http.StatusNotFound, "ErrorItemNotFound")
+		return
+	}
+
+	// Как и в Exchange, у перемещенного письма новый ID
+	moved := *m
+	m.Deleted = true
+	s.version++
+	m.Version = s.version
+	s.version++
+	moved.ID, moved.Folder, moved.Version = m.ID+"-moved", req.DestinationID, s.version
+	s.messages = append(s.messages, &moved)
+	writeJSON(w, http.StatusCreated, map[string]string{"id": moved.ID})
+}
+
+type memoryStore struct {
+	links map[string]string
+	marks map[string]Mark
+}
+
+func (s *memoryStore) LoadDelta(key string) string     { return s.links[key] }
+func (s *memoryStore) SaveDelta(key, deltaLink string) { s.links[key] = deltaLink }
+func (s *memoryStore) LoadMark(key string) Mark        { return s.marks[key] }
+func (s *memoryStore) SaveMark(key string, mark Mark)  { s.marks[key] = mark }
+
+func newSource(t *testing.T, stub *graphStub, folders ...MailFolder) (*Source, *memoryStore) {
+	t.Helper()
+	store := &memoryStore{links: make(map[string]string), marks: make(map[string]Mark)}
+	src, err := New(Config{
+		Name:         "ops",
+		TenantID:     "tenant",
+		ClientID:     "app",
+		ClientSecret: "secret",
+		Mailbox:      "ops@example.com",
+		AuthorityURL: stub.server.URL,
+		GraphURL:     stub.server.URL + "/v1.0",
+	}, folders, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Logf = t.Logf
+	return src, store
+}
+
+// Собирает письма, переданные в обработчик
+type collector struct {
+	messages []mailsource.Message
+	fail     bool
+}
+
+func (c *collector) handle(msg mailsource.Message) bool {
+	if c.fail {
+		return false
+	}
+	c.messages = append(c.messages, msg)
+	return true
+}
+
+func (c *collector) subjects() string {
+	var subjects []string
+	for _, m := range c.messages {
+		subjects = append(subjects, m.Subject)
+	}
+	c.messages = nil
+	return strings.Join(subjects, ",")
+}
+
+func TestInitialSyncSendsUnreadThenDelta(t *testing.T) {
+	stub := newGraphStub(t)
+	stub.add(stubMessage{ID: "m1", Folder: "inbox-id", Subject: "прочитано", Read: true})
+	stub.add(stubMessage{ID: "m2", Folder: "inbox-id", Subject: "первое", FromName: "Zabbix", FromAddress: "zabbix@example.com",
+		Importance: "high", Text: "Сервер недоступен", HTML: "<b>Сервер недоступен</b>"})
+	stub.add(stubMessage{ID: "m3", Folder: "inbox-id", Subject: "второе"})
+	stub.add(stubMessage{ID: "z1", Folder: "zabbix-id", Subject: "другая папка"})
+
+	src, store := newSource(t, stub)
+	folder := MailFolder{Path: "@inbox", Folder: "Входящие"}
+	c := &collector{}
+
+	// Начальная выборка из двух страниц
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 2 {
+		t.Fatalf("получено писем: %d, want 2", len(c.messages))
+	}
+	msg := c.messages[0]
+	if msg.ID != "graph:ops:m2" || msg.Folder != "Входящие" || msg.Subject != "первое" {
+		t.Errorf("ID = %q, Folder = %q, Subject = %q", msg.ID, msg.Folder, msg.Subject)
+	}
+	if msg.Sender() != "Zabbix <zabbix@example.com>" || msg.Importance != mailsource.ImportanceHigh {
+		t.Errorf("Sender() = %q, Importance = %d", msg.Sender(), msg.Importance)
+	}
+	if msg.Body != "Сервер недоступен" || msg.IsHTML || msg.ConversationID != "conv-m2" {
+		t.Errorf("Body = %q, IsHTML = %t, ConversationID = %q", msg.Body, msg.IsHTML, msg.ConversationID)
+	}
+	if c.subjects() != "первое,второе" {
+		t.Error("неверный порядок писем")
+	}
+	if store.links["ops/inbox-id"] == "" {
+		t.Fatal("ссылка на разностную выборку не сохранена")
+	}
+
+	// Без изменений - ничего нового
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "" {
+		t.Errorf("повторно отправлены: %s", got)
+	}
+
+	// Новое письмо приходит разностной выборкой
+	stub.add(stubMessage{ID: "m4", Folder: "inbox-id", Subject: "новое"})
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "новое" {
+		t.Errorf("получено %q, want новое", got)
+	}
+
+	if stub.tokens != 1 {
+		t.Errorf("токен запрошен %d раз, want 1", stub.tokens)
+	}
+}
+
+func TestModifiedMessagesAreNotResent(t *testing.T) {
+	stub := newGraphStub(t)
+	received := time.Now().Add(-time.Minute).Truncate(time.Second)
+	stub.add(stubMessage{ID: "m1", Folder: "inbox-id", Subject: "первое", Received: received})
+
+	src, store := newSource(t, stub)
+	folder := MailFolder{Path: "@inbox", Folder: "Входящие"}
+	c := &collector{}
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 1 {
+		t.Fatalf("получено писем: %d", len(c.messages))
+	}
+	err := src.Act(context.Background(), c.messages[0].ID, func(a mailsource.Actions) error {
+		return a.Categorize("OTN")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "первое" {
+		t.Fatalf("получено %q", got)
+	}
+
+	// Категория изменила письмо, и оно вернулось в разностной выборке. После
+	// перезапуска (новый источник с тем же хранилищем) оно не отправляется снова,
+	// а новое письмо, полученное в ту же секунду, отправляется
+	stub.add(stubMessage{ID: "m2", Folder: "inbox-id", Subject: "второе", Received: received})
+	src, _ = New(src.cfg, src.folders, store)
+	c = &collector{}
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "второе" {
+		t.Errorf("получено %q, want второе", got)
+	}
+
+	// Выборка заново (ссылка устарела) не отправляет старые непрочитанные письма
+	store.links = make(map[string]string)
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "" {
+		t.Errorf("повторно отправлены: %s", got)
+	}
+}
+
+func TestStartFromNowAndHTML(t *testing.T) {
+	stub := newGraphStub(t)
+	stub.add(stubMessage{ID: "m1", Folder: "inbox-id", Subject: "старое", Received: time.Now().Add(-time.Hour)})
+
+	src, _ := newSource(t, stub)
+	folder := MailFolder{Path: "@inbox", Folder: "Входящие", StartFromNow: true, HTML: true}
+	c := &collector{}
+
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "" {
+		t.Errorf("при start_from_now отправлены старые письма: %s", got)
+	}
+
+	stub.add(stubMessage{ID: "m2", Folder: "inbox-id", Subject: "новое", HTML: "<b>Текст</b>"})
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 1 || !c.messages[0].IsHTML || c.messages[0].Body != "<b>Текст</b>" {
+		t.Errorf("получено %+v", c.messages)
+	}
+}
+
+func TestFailedDeliveryIsRetried(t *testing.T) {
+	stub := newGraphStub(t)
+	src, store := newSource(t, stub)
+	folder := MailFolder{Path: "@inbox", Folder: "Входящие", StartFromNow: true}
+	c := &collector{}
+
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	link := store.links["ops/inbox-id"]
+
+	stub.add(stubMessage{ID: "m1", Folder: "inbox-id", Subject: "новое"})
+	c.fail = true
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if store.links["ops/inbox-id"] != link {
+		t.Error("ссылка сдвинута после неудачной отправки")
+	}
+
+	c.fail = false
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.subjects(); got != "новое" {
+		t.Errorf("получено %q, want новое", got)
+	}
+}
+
+func TestResolveFolder(t *testing.T) {
+	stub := newGraphStub(t)
+	src, _ := newSource(t, stub)
+
+	tests := []struct {
+		path, want string
+	}{
+		{"@inbox", "inbox-id"},
+		{"@inbox/Zabbix", "zabbix-id"},
+		{"Zabbix", "zabbix-id"},
+		{"Inbox/Zabbix", "zabbix-id"},
+		{"ops@example.com/Archive", "archive-id"},
+		{"Входящие", "inbox-id"},
+	}
+	for _, tt := range tests {
+		got, err := src.resolveFolder(context.Background(), tt.path)
+		if err != nil {
+			t.Errorf("resolveFolder(%q): %v", tt.path, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveFolder(%q) = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+
+	if _, err := src.resolveFolder(context.Background(), "Нет такой"); err == nil {
+		t.Error("ожидалась ошибка для несуществующей папки")
+	}
+}
+
+func TestActions(t *testing.T) {
+	stub := newGraphStub(t)
+	stub.add(stubMessage{ID: "m1", Folder: "inbox-id", Subject: "письмо", Categories: []string{"Важное"}})
+
+	src, _ := newSource(t, stub)
+	folder := MailFolder{Path: "@inbox", Folder: "Входящие"}
+
+	c := &collector{}
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 1 {
+		t.Fatalf("получено писем: %d", len(c.messages))
+	}
+
+	// Действия выполняются после обработки письма, по его идентификатору
+	var newID string
+	err := src.Act(context.Background(), c.messages[0].ID, func(actions mailsource.Actions) error {
+		if err := actions.Categorize("OTN"); err != nil {
+			return err
+		}
+		if err := actions.MarkRead(); err != nil {
+			return err
+		}
+		var err error
+		newID, err = actions.Move("Archive")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Act(context.Background(), "graph:other:m1", func(mailsource.Actions) error { return nil }); err == nil {
+		t.Error("ожидалась ошибка для письма другой учетной записи")
+	}
+
+	if newID != "graph:ops:m1-moved" {
+		t.Errorf("новый ID = %q", newID)
+	}
+	moved := stub.message("m1-moved")
+	if moved.Folder != "archive-id" || !moved.Read || strings.Join(moved.Categories, ",") != "Важное,OTN" {
+		t.Errorf("письмо после действий: %+v", moved)
+	}
+
+	// Изменения письма (отметка о прочтении, перемещение) не отправляются повторно
+	if err := src.syncFolder(context.Background(), folder, c.handle); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 1 {
+		t.Errorf("получено писем: %d, want 1", len(c.messages))
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	stub := newGraphStub(t)
+	stub.expiresIn = 30 // Меньше минуты запаса: токен запрашивается каждый раз
+	src, _ := newSource(t, stub)
+
+	for i := 0; i < 2; i++ {
+		if _, err := src.resolveFolder(context.Background(), "@inbox"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stub.tokens != 2 {
+		t.Errorf("токен запрошен %d раз, want 2", stub.tokens)
+	}
+
+	// Отозванный токен сбрасывается после ответа 401
+	stub.expiresIn = 3600
+	if _, err := src.resolveFolder(context.Background(), "@inbox"); err != nil {
+		t.Fatal(err)
+	}
+	stub.mutex.Lock()
+	stub.token = "revoked"
+	stub.mutex.Unlock()
+	if _, err := src.resolveFolder(context.Background(), "@inbox"); err == nil {
+		t.Fatal("ожидалась ошибка 401")
+	}
+	if _, err := src.resolveFolder(context.Background(), "@inbox"); err != nil {
+		t.Fatalf("после сброса токена: %v", err)
+	}
+}
+
+func TestBadCredentials(t *testing.T) {
+	stub := newGraphStub(t)
+	src, _ := newSource(t, stub)
+	src.api.cfg.ClientSecret = "wrong"
+
+	_, err := src.resolveFolder(context.Background(), "@inbox")
+	if err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("ошибка = %v, want invalid_client", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Name: "ops", TenantID: "t", ClientID: "c", ClientSecret: "s", Mailbox: "ops@example.com"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() = %v", err)
+	}
+
+	invalid := []Config{
+		{TenantID: "t", ClientID: "c", ClientSecret: "s", Mailbox: "m"},
+		{Name: "ops", ClientID: "c", ClientSecret: "s", Mailbox: "m"},
+		{Name: "ops", TenantID: "t", ClientID: "c", Mailbox: "m"},
+		{Name: "ops", TenantID: "t", ClientID: "c", ClientSecret: "s"},
+		{Name: "ops", TenantID: "t", ClientID: "c", ClientSecret: "s", Mailbox: "m", GraphURL: "graph.local"},
+	}
+	for _, cfg := range invalid {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("Validate(%+v) = nil, ожидалась ошибка", cfg)
+		}
+	}
+}
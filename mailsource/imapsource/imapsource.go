@@ -0,0 +1,495 @@
+// Пакет imapsource получает письма с IMAP-сервера. Новые письма находятся
+// по UID (последний обработанный UID хранится вместе с UIDVALIDITY), о новых
+// письмах сервер сообщает командой IDLE, а если IDLE не поддерживается,
+// почтовый ящик периодически опрашивается. Для каждого почтового ящика
+// открывается отдельное соединение, а для действий after_send (Act) -
+// еще одно, на время действия.
+package imapsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+
+	"otn/mailsource"
+)
+
+// Режимы шифрования соединения
+const (
+	TLSImplicit = "tls"      // TLS сразу после подключения (порт 993), по умолчанию
+	TLSStartTLS = "starttls" // Команда STARTTLS (порт 143)
+	TLSNone     = "none"     // Без шифрования
+)
+
+// Config - учетная запись IMAP
+type Config struct {
+	Name               string `json:"name"`
+	Server             string `json:"server"` // host:port
+	TLS                string `json:"tls"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+}
+
+// Validate проверяет настройки учетной записи
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("не указано имя учетной записи")
+	}
+	if _, _, err := net.SplitHostPort(c.Server); err != nil {
+		return fmt.Errorf("server должен иметь вид host:port: %v", err)
+	}
+	switch c.TLS {
+	case "", TLSImplicit, TLSStartTLS, TLSNone:
+	default:
+		return fmt.Errorf("неизвестный режим tls %q (допустимы: %s, %s, %s)", c.TLS, TLSImplicit, TLSStartTLS, TLSNone)
+	}
+	if c.Username == "" {
+		return fmt.Errorf("не указан username")
+	}
+	return nil
+}
+
+// Mailbox - отслеживаемый почтовый ящик
+type Mailbox struct {
+	Name         string // Имя почтового ящика на сервере, например "INBOX" или "INBOX/Zabbix"
+	Folder       string // Папка, под которой письма попадают в уведомления
+	HTML         bool   // Брать HTML-тело письма
+	StartFromNow bool   // При первом запуске не отправлять уже лежащие в ящике письма
+}
+
+// UIDStore хранит последний обработанный UID почтового ящика
+type UIDStore interface {
+	LoadUID(key string) (validity, uid uint32, ok bool)
+	SaveUID(key string, validity, uid uint32)
+}
+
+// Source - источник писем одной учетной записи IMAP
+type Source struct {
+	cfg       Config
+	mailboxes []Mailbox
+	store     UIDStore
+
+	// Как часто проверять ящик без уведомления от сервера (и опрашивать,
+	// если IDLE не поддерживается)
+	PollInterval time.Duration
+	// Пауза перед повторным подключением после ошибки
+	RetryDelay time.Duration
+	// Журнал; по умолчанию сообщения не выводятся
+	Logf func(format string, args ...interface{})
+	// Журнал ошибок; по умолчанию ошибки пишутся в Logf
+	Errorf func(format string, args ...interface{})
+}
+
+// New создает источник для учетной записи и списка почтовых ящиков
+func New(cfg Config, mailboxes []Mailbox, store UIDStore) (*Source, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.TLS == "" {
+		cfg.TLS = TLSImplicit
+	}
+	return &Source{
+		cfg:          cfg,
+		mailboxes:    mailboxes,
+		store:        store,
+		PollInterval: 5 * time.Minute,
+		RetryDelay:   30 * time.Second,
+		Logf:         func(string, ...interface{}) {},
+	}, nil
+}
+
+// Name возвращает имя источника
+func (s *Source) Name() string {
+	return "imap:" + s.cfg.Name
+}
+
+// Сообщает об ошибке через Errorf, а если он не задан - через Logf
+func (s *Source) errorf(format string, args ...interface{}) {
+	if s.Errorf != nil {
+		s.Errorf(format, args...)
+		return
+	}
+	s.Logf(format, args...)
+}
+
+// Run следит за почтовыми ящиками и передает новые письма в handle, пока не отменен ctx.
+// Ошибки соединения не прерывают работу: ящик подключается заново через RetryDelay
+func (s *Source) Run(ctx context.Context, handle mailsource.Handler) error {
+	var wg sync.WaitGroup
+	for _, mailbox := range s.mailboxes {
+		wg.Add(1)
+		go func(mailbox Mailbox) {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if err := s.watch(ctx, mailbox, handle); err != nil && ctx.Err() == nil {
+					s.errorf("IMAP %s, ящик %s: %v. Повтор через %v", s.cfg.Name, mailbox.Name, err, s.RetryDelay)
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(s.RetryDelay):
+				}
+			}
+		}(mailbox)
+	}
+	wg.Wait()
+	return nil
+}
+
+// Подключается к серверу и входит в учетную запись
+func (s *Source) dial() (*client.Client, error) {
+	host, _, _ := net.SplitHostPort(s.cfg.Server)
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: s.cfg.InsecureSkipVerify}
+
+	var (
+		c   *client.Client
+		err error
+	)
+	if s.cfg.TLS == TLSImplicit {
+		c, err = client.DialTLS(s.cfg.Server, tlsConfig)
+	} else {
+		c, err = client.Dial(s.cfg.Server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения: %v", err)
+	}
+
+	if s.cfg.TLS == TLSStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			c.Logout()
+			return nil, fmt.Errorf("ошибка STARTTLS: %v", err)
+		}
+	}
+	if err := c.Login(s.cfg.Username, s.cfg.Password); err != nil {
+		c.Logout()
+		return nil, fmt.Errorf("ошибка входа: %v", err)
+	}
+	return c, nil
+}
+
+// Следит за одним почтовым ящиком до ошибки соединения или отмены ctx
+func (s *Source) watch(ctx context.Context, mailbox Mailbox, handle mailsource.Handler) error {
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+
+	// Сервер сообщает о новых письмах (EXISTS) через Updates. Канал нужно
+	// разбирать постоянно, иначе клиент заблокируется
+	updates := make(chan client.Update, 16)
+	newMail := make(chan struct{}, 1)
+	quit := make(chan struct{})
+	defer func() {
+		c.Logout()
+		close(quit)
+	}()
+	c.Updates = updates
+	go func() {
+		for {
+			select {
+			case <-quit:
+				return
+			case update := <-updates:
+				if _, ok := update.(*client.MailboxUpdate); ok {
+					select {
+					case newMail <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	status, err := c.Select(mailbox.Name, false)
+	if err != nil {
+		return fmt.Errorf("ошибка выбора ящика: %v", err)
+	}
+
+	w := &mailboxWatcher{
+		source:   s,
+		client:   c,
+		mailbox:  mailbox,
+		key:      s.cfg.Name + "/" + mailbox.Name,
+		validity: status.UidValidity,
+		handle:   handle,
+	}
+	if err := w.start(status); err != nil {
+		return err
+	}
+
+	for {
+		if err := w.fetchNew(); err != nil {
+			return err
+		}
+
+		// Ждем уведомления от сервера, периодической проверки или отмены
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- c.Idle(stop, &client.IdleOptions{PollInterval: s.PollInterval})
+		}()
+
+		timer := time.NewTimer(s.PollInterval)
+		var idleErr error
+		select {
+		case <-ctx.Done():
+		case <-newMail:
+		case <-timer.C:
+		case idleErr = <-done:
+			done = nil
+		}
+		timer.Stop()
+
+		if done != nil {
+			close(stop)
+			idleErr = <-done
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if idleErr != nil {
+			return fmt.Errorf("ошибка IDLE: %v", idleErr)
+		}
+	}
+}
+
+// Состояние отслеживания одного почтового ящика
+type mailboxWatcher struct {
+	source   *Source
+	client   *client.Client
+	mailbox  Mailbox
+	key      string
+	validity uint32
+	last     uint32 // Последний обработанный UID
+	handle   mailsource.Handler
+}
+
+// Определяет, с какого UID продолжать. При первом запуске отправляются
+// непрочитанные письма, если не задан StartFromNow. При смене UIDVALIDITY
+// старые UID недействительны, и отслеживание начинается с текущего письма
+func (w *mailboxWatcher) start(status *imap.MailboxStatus) error {
+	validity, last, ok := w.source.store.LoadUID(w.key)
+	if ok && validity == w.validity {
+		w.last = last
+		return nil
+	}
+
+	if ok {
+		w.source.Logf("IMAP %s, ящик %s: изменился UIDVALIDITY, отслеживание начинается заново", w.source.cfg.Name, w.mailbox.Name)
+	}
+
+	top, err := w.highestUID(status)
+	if err != nil {
+		return err
+	}
+
+	if !w.mailbox.StartFromNow && !ok {
+		criteria := imap.NewSearchCriteria()
+		criteria.WithoutFlags = []string{imap.SeenFlag}
+		unseen, err := w.client.UidSearch(criteria)
+		if err != nil {
+			return fmt.Errorf("ошибка поиска непрочитанных писем: %v", err)
+		}
+		sort.Slice(unseen, func(i, j int) bool { return unseen[i] < unseen[j] })
+		for _, uid := range unseen {
+			if !w.process(uid) {
+				// Повторим при следующем подключении
+				return fmt.Errorf("не удалось отправить уведомление о письме UID %d", uid)
+			}
+		}
+	}
+
+	w.last = top
+	w.source.store.SaveUID(w.key, w.validity, w.last)
+	return nil
+}
+
+// Наибольший UID в ящике
+func (w *mailboxWatcher) highestUID(status *imap.MailboxStatus) (uint32, error) {
+	if status.UidNext > 0 {
+		return status.UidNext - 1, nil
+	}
+	uids, err := w.client.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		return 0, fmt.Errorf("ошибка поиска писем: %v", err)
+	}
+	var top uint32
+	for _, uid := range uids {
+		if uid > top {
+			top = uid
+		}
+	}
+	return top, nil
+}
+
+// Обрабатывает письма с UID больше последнего обработанного. Отметка
+// сдвигается только до письма, уведомление о котором доставлено
+func (w *mailboxWatcher) fetchNew() error {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(w.last+1, 0)
+
+	uids, err := w.client.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("ошибка поиска новых писем: %v", err)
+	}
+	sort.Slice(uids, func(i, j int) bool { return uids[i] < uids[j] })
+
+	for _, uid := range uids {
+		// "N:*" всегда включает последнее письмо, даже если его UID меньше N
+		if uid <= w.last {
+			continue
+		}
+		if !w.process(uid) {
+			return nil // Повторим при следующей проверке
+		}
+		w.last = uid
+		w.source.store.SaveUID(w.key, w.validity, w.last)
+	}
+	return nil
+}
+
+// Загружает письмо и передает его в конвейер
+func (w *mailboxWatcher) process(uid uint32) bool {
+	msg, err := w.fetch(uid)
+	if err != nil {
+		w.source.errorf("IMAP %s, ящик %s: ошибка загрузки письма UID %d: %v", w.source.cfg.Name, w.mailbox.Name, uid, err)
+		return false
+	}
+	return w.handle(msg)
+}
+
+func (w *mailboxWatcher) fetch(uid uint32) (mailsource.Message, error) {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(uid)
+
+	// BODY.PEEK не ставит отметку о прочтении
+	section := &imap.BodySectionName{Peek: true}
+	items := []imap.FetchItem{section.FetchItem(), imap.FetchInternalDate, imap.FetchUid}
+
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- w.client.UidFetch(seqset, items, messages)
+	}()
+
+	var fetched *imap.Message
+	for m := range messages {
+		fetched = m
+	}
+	if err := <-done; err != nil {
+		return mailsource.Message{}, err
+	}
+	if fetched == nil {
+		return mailsource.Message{}, fmt.Errorf("письмо не найдено")
+	}
+
+	body := fetched.GetBody(section)
+	if body == nil {
+		return mailsource.Message{}, fmt.Errorf("сервер не вернул тело письма")
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return mailsource.Message{}, err
+	}
+
+	msg, err := mailsource.ParseMIME(bytes.NewReader(data), w.mailbox.HTML)
+	if err != nil {
+		return mailsource.Message{}, err
+	}
+	msg.ID = w.source.messageID(w.mailbox.Name, w.validity, uid)
+	msg.Folder = w.mailbox.Folder
+	if !fetched.InternalDate.IsZero() {
+		msg.Received = fetched.InternalDate
+	}
+	return msg, nil
+}
+
+// Идентификатор письма: учетная запись, ящик, UIDVALIDITY и UID
+func (s *Source) messageID(mailbox string, validity, uid uint32) string {
+	return fmt.Sprintf("imap:%s:%s:%d:%d", s.cfg.Name, mailbox, validity, uid)
+}
+
+// Разбирает идентификатор письма. Имя ящика может содержать двоеточия,
+// поэтому UIDVALIDITY и UID берутся с конца
+func (s *Source) parseID(id string) (mailbox string, validity, uid uint32, err error) {
+	rest, ok := strings.CutPrefix(id, "imap:"+s.cfg.Name+":")
+	parts := strings.Split(rest, ":")
+	if !ok || len(parts) < 3 {
+		return "", 0, 0, fmt.Errorf("письмо %s не относится к учетной записи %s", id, s.cfg.Name)
+	}
+	n := len(parts)
+	v, errV := strconv.ParseUint(parts[n-2], 10, 32)
+	u, errU := strconv.ParseUint(parts[n-1], 10, 32)
+	if errV != nil || errU != nil {
+		return "", 0, 0, fmt.Errorf("некорректный идентификатор письма %s", id)
+	}
+	return strings.Join(parts[:n-2], ":"), uint32(v), uint32(u), nil
+}
+
+// Act выполняет действия над письмом в отдельном соединении: соединения
+// отслеживания ящиков заняты ожиданием IDLE
+func (s *Source) Act(ctx context.Context, id string, fn func(mailsource.Actions) error) error {
+	mailbox, validity, uid, err := s.parseID(id)
+	if err != nil {
+		return err
+	}
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Logout()
+
+	status, err := c.Select(mailbox, false)
+	if err != nil {
+		return fmt.Errorf("ошибка выбора ящика: %v", err)
+	}
+	if status.UidValidity != validity {
+		return fmt.Errorf("ящик %s изменился (UIDVALIDITY), письмо UID %d не найдено", mailbox, uid)
+	}
+	return fn(imapActions{client: c, uid: uid})
+}
+
+// Действия after_send над письмом в выбранном ящике
+type imapActions struct {
+	client *client.Client
+	uid    uint32
+}
+
+func (a imapActions) seqset() *imap.SeqSet {
+	seqset := new(imap.SeqSet)
+	seqset.AddNum(a.uid)
+	return seqset
+}
+
+func (a imapActions) MarkRead() error {
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	return a.client.UidStore(a.seqset(), item, []interface{}{imap.SeenFlag}, nil)
+}
+
+func (a imapActions) Move(folder string) (string, error) {
+	return "", a.client.UidMove(a.seqset(), folder)
+}
+
+// Категория сохраняется как ключевое слово IMAP (пробелы и спецсимволы заменяются на "_")
+func (a imapActions) Categorize(category string) error {
+	keyword := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || strings.ContainsRune(`(){%*"\]`, r) {
+			return '_'
+		}
+		return r
+	}, category)
+	item := imap.FormatFlagsOp(imap.AddFlags, true)
+	return a.client.UidStore(a.seqset(), item, []interface{}{keyword}, nil)
+}
@@ -0,0 +1,471 @@
+package imapsource
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/emersion/go-imap/server"
+
+	"otn/mailsource"
+)
+
+// Бэкенд в памяти, который умеет сообщать клиентам о новых письмах (для IDLE).
+// Бэкенд memory не защищен от одновременного доступа, поэтому все обращения
+// к почтовым ящикам выполняются под общим мьютексом
+type testBackend struct {
+	*memory.Backend
+	updates chan backend.Update
+	mutex   sync.Mutex
+}
+
+func (b *testBackend) Updates() <-chan backend.Update {
+	return b.updates
+}
+
+func (b *testBackend) Login(info *imap.ConnInfo, username, password string) (backend.User, error) {
+	user, err := b.Backend.Login(info, username, password)
+	if err != nil {
+		return nil, err
+	}
+	return lockedUser{User: user, mutex: &b.mutex}, nil
+}
+
+type lockedUser struct {
+	backend.User
+	mutex *sync.Mutex
+}
+
+func (u lockedUser) GetMailbox(name string) (backend.Mailbox, error) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	mbox, err := u.User.GetMailbox(name)
+	if err != nil {
+		return nil, err
+	}
+	return lockedMailbox{Mailbox: mbox, mutex: u.mutex}, nil
+}
+
+type lockedMailbox struct {
+	backend.Mailbox
+	mutex *sync.Mutex
+}
+
+func (m lockedMailbox) Status(items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.Status(items)
+}
+
+func (m lockedMailbox) ListMessages(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.ListMessages(uid, seqset, items, ch)
+}
+
+func (m lockedMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.SearchMessages(uid, criteria)
+}
+
+func (m lockedMailbox) CreateMessage(flags []string, date time.Time, body imap.Literal) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.CreateMessage(flags, date, body)
+}
+
+func (m lockedMailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, op imap.FlagsOp, flags []string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.UpdateMessagesFlags(uid, seqset, op, flags)
+}
+
+func (m lockedMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.CopyMessages(uid, seqset, dest)
+}
+
+func (m lockedMailbox) Expunge() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.Mailbox.Expunge()
+}
+
+func (b *testBackend) inbox(t *testing.T) backend.Mailbox {
+	t.Helper()
+	user, err := b.Login(nil, "username", "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mbox, err := user.GetMailbox("INBOX")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mbox
+}
+
+// Кладет письмо в INBOX
+func (b *testBackend) store(t *testing.T, raw string) {
+	t.Helper()
+	if err := b.inbox(t).CreateMessage(nil, time.Now(), bytes.NewBufferString(raw)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Кладет письмо в INBOX и сообщает об этом подключенным клиентам. Сервер go-imap
+// рассылает уведомления без синхронизации с входом в систему, поэтому они
+// отправляются только после подключения наблюдателя
+func (b *testBackend) deliver(t *testing.T, raw string) {
+	t.Helper()
+	b.store(t, raw)
+	status, err := b.inbox(t).Status([]imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.updates <- &backend.MailboxUpdate{
+		Update:        backend.NewUpdate("username", "INBOX"),
+		MailboxStatus: status,
+	}
+}
+
+func (b *testBackend) flags(t *testing.T, uid uint32) []string {
+	t.Helper()
+	mbox := b.inbox(t).(lockedMailbox).Mailbox.(*memory.Mailbox)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, msg := range mbox.Messages {
+		if msg.Uid == uid {
+			return msg.Flags
+		}
+	}
+	t.Fatalf("письмо UID %d не найдено", uid)
+	return nil
+}
+
+func startServer(t *testing.T) (*testBackend, string) {
+	t.Helper()
+	be := &testBackend{Backend: memory.New(), updates: make(chan backend.Update, 16)}
+
+	srv := server.New(be)
+	srv.AllowInsecureAuth = true
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	return be, l.Addr().String()
+}
+
+type memoryStore struct {
+	mutex sync.Mutex
+	uids  map[string][2]uint32
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{uids: make(map[string][2]uint32)}
+}
+
+func (s *memoryStore) LoadUID(key string) (uint32, uint32, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	v, ok := s.uids[key]
+	return v[0], v[1], ok
+}
+
+func (s *memoryStore) SaveUID(key string, validity, uid uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.uids[key] = [2]uint32{validity, uid}
+}
+
+// Запускает источник и возвращает канал полученных писем
+func runSource(t *testing.T, addr string, mailbox Mailbox, store UIDStore, handle mailsource.Handler) <-chan mailsource.Message {
+	t.Helper()
+	src, err := New(Config{Name: "test", Server: addr, TLS: TLSNone, Username: "username", Password: "password"},
+		[]Mailbox{mailbox}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.PollInterval = time.Hour // Новые письма должны приходить через IDLE
+	src.RetryDelay = 50 * time.Millisecond
+	src.Logf = t.Logf
+
+	received := make(chan mailsource.Message, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		src.Run(ctx, func(msg mailsource.Message) bool {
+			ok := handle == nil || handle(msg)
+			if ok {
+				received <- msg
+			}
+			return ok
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return received
+}
+
+func waitMessage(t *testing.T, received <-chan mailsource.Message) mailsource.Message {
+	t.Helper()
+	select {
+	case msg := <-received:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("письмо не получено")
+	}
+	return mailsource.Message{}
+}
+
+// Ждет, пока наблюдатель подключится и запомнит начальный UID
+func waitStarted(t *testing.T, store *memoryStore, key string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, _, ok := store.LoadUID(key); ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("наблюдатель не запустился")
+}
+
+func expectNothing(t *testing.T, received <-chan mailsource.Message) {
+	t.Helper()
+	select {
+	case msg := <-received:
+		t.Fatalf("неожиданное письмо: %q", msg.Subject)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+const plainMessage = "From: =?utf-8?B?0JjQstCw0L0g0J/QtdGC0YDQvtCy?= <ivan@example.com>\r\n" +
+	"To: ops@example.com\r\n" +
+	"Subject: =?windows-1251?B?z/Du4uXw6uA=?=\r\n" +
+	"Date: Mon, 10 Jun 2024 10:00:00 +0300\r\n" +
+	"Message-ID: <2@example.com>\r\n" +
+	"References: <1@example.com> <2@example.com>\r\n" +
+	"X-Priority: 1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Сервер недоступен"
+
+const alternativeMessage = "From: monitoring@example.com\r\n" +
+	"Subject: HTML\r\n" +
+	"Content-Type: multipart/alternative; boundary=b1\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Текст\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=utf-8\r\n" +
+	"\r\n" +
+	"<b>Текст</b>\r\n" +
+	"--b1--\r\n"
+
+func TestFirstRunSendsUnseenThenIdle(t *testing.T) {
+	be, addr := startServer(t)
+	// Письмо из memory.New() (UID 6) уже прочитано и не должно отправляться
+	be.store(t, plainMessage)
+
+	store := newMemoryStore()
+	received := runSource(t, addr, Mailbox{Name: "INBOX", Folder: "Почта/INBOX"}, store, nil)
+
+	msg := waitMessage(t, received)
+	if msg.Subject != "Проверка" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	if msg.Sender() != "Иван Петров <ivan@example.com>" {
+		t.Errorf("Sender() = %q", msg.Sender())
+	}
+	if strings.TrimSpace(msg.Body) != "Сервер недоступен" || msg.IsHTML {
+		t.Errorf("Body = %q, IsHTML = %t", msg.Body, msg.IsHTML)
+	}
+	if msg.Folder != "Почта/INBOX" || msg.ID != "imap:test:INBOX:1:7" {
+		t.Errorf("Folder = %q, ID = %q", msg.Folder, msg.ID)
+	}
+	if msg.ConversationID != "1@example.com" || msg.Importance != mailsource.ImportanceHigh {
+		t.Errorf("ConversationID = %q, Importance = %d", msg.ConversationID, msg.Importance)
+	}
+	expectNothing(t, received)
+
+	// Новое письмо приходит через IDLE
+	be.deliver(t, alternativeMessage)
+	msg = waitMessage(t, received)
+	if msg.Subject != "HTML" || strings.TrimSpace(msg.Body) != "Текст" {
+		t.Errorf("Subject = %q, Body = %q", msg.Subject, msg.Body)
+	}
+
+	if _, uid, _ := store.LoadUID("test/INBOX"); uid != 8 {
+		t.Errorf("сохраненный UID = %d, want 8", uid)
+	}
+}
+
+func TestStartFromNow(t *testing.T) {
+	be, addr := startServer(t)
+	be.store(t, plainMessage)
+
+	store := newMemoryStore()
+	received := runSource(t, addr, Mailbox{Name: "INBOX", Folder: "INBOX", StartFromNow: true, HTML: true}, store, nil)
+	waitStarted(t, store, "test/INBOX")
+	expectNothing(t, received)
+
+	be.deliver(t, alternativeMessage)
+	msg := waitMessage(t, received)
+	if !msg.IsHTML || strings.TrimSpace(msg.Body) != "<b>Текст</b>" {
+		t.Errorf("Body = %q, IsHTML = %t", msg.Body, msg.IsHTML)
+	}
+}
+
+func TestResumeFromStoredUID(t *testing.T) {
+	be, addr := startServer(t)
+	be.store(t, plainMessage)       // UID 7, уже обработано
+	be.store(t, alternativeMessage) // UID 8
+
+	store := newMemoryStore()
+	store.SaveUID("test/INBOX", 1, 7)
+
+	received := runSource(t, addr, Mailbox{Name: "INBOX", Folder: "INBOX"}, store, nil)
+	if msg := waitMessage(t, received); msg.Subject != "HTML" {
+		t.Errorf("Subject = %q, want HTML", msg.Subject)
+	}
+	expectNothing(t, received)
+}
+
+func TestUIDValidityChange(t *testing.T) {
+	be, addr := startServer(t)
+	be.store(t, plainMessage)
+
+	store := newMemoryStore()
+	store.SaveUID("test/INBOX", 99, 3)
+
+	received := runSource(t, addr, Mailbox{Name: "INBOX", Folder: "INBOX"}, store, nil)
+	expectNothing(t, received)
+
+	if validity, uid, _ := store.LoadUID("test/INBOX"); validity != 1 || uid != 7 {
+		t.Errorf("сохранено validity=%d uid=%d, want 1 и 7", validity, uid)
+	}
+}
+
+func TestFailedDeliveryIsRetried(t *testing.T) {
+	be, addr := startServer(t)
+
+	store := newMemoryStore()
+	var (
+		mutex    sync.Mutex
+		attempts int
+	)
+	received := runSource(t, addr, Mailbox{Name: "INBOX", Folder: "INBOX", StartFromNow: true}, store,
+		func(msg mailsource.Message) bool {
+			mutex.Lock()
+			defer mutex.Unlock()
+			attempts++
+			return attempts > 1 // Первая отправка не удалась
+		})
+	waitStarted(t, store, "test/INBOX")
+
+	be.deliver(t, plainMessage)
+	// Первая попытка не удалась: UID не сдвигается, письмо обрабатывается при следующем событии
+	time.Sleep(200 * time.Millisecond)
+	if _, uid, _ := store.LoadUID("test/INBOX"); uid != 6 {
+		t.Fatalf("UID сдвинут после неудачной отправки: %d", uid)
+	}
+
+	be.deliver(t, alternativeMessage)
+	if msg := waitMessage(t, received); msg.Subject != "Проверка" {
+		t.Errorf("первым должно быть повторено письмо UID 7, получено %q", msg.Subject)
+	}
+	if msg := waitMessage(t, received); msg.Subject != "HTML" {
+		t.Errorf("Subject = %q, want HTML", msg.Subject)
+	}
+}
+
+func TestActions(t *testing.T) {
+	be, addr := startServer(t)
+	be.store(t, plainMessage)
+
+	received := runSource(t, addr, Mailbox{Name: "INBOX", Folder: "INBOX"}, newMemoryStore(), nil)
+	msg := waitMessage(t, received)
+
+	// Действия выполняются позже, отдельным экземпляром источника
+	actor, err := New(Config{Name: "test", Server: addr, TLS: TLSNone, Username: "username", Password: "password"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = actor.Act(context.Background(), msg.ID, func(actions mailsource.Actions) error {
+		if err := actions.MarkRead(); err != nil {
+			return err
+		}
+		return actions.Categorize("Отправлено в Telegram")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := strings.Join(be.flags(t, 7), " ")
+	if !strings.Contains(flags, imap.SeenFlag) {
+		t.Errorf("нет флага \\Seen: %s", flags)
+	}
+	if !strings.Contains(flags, "_________") {
+		t.Errorf("нет ключевого слова категории: %s", flags)
+	}
+
+	// После смены UIDVALIDITY письмо с прежним UID - другое письмо
+	mailbox, validity, uid, _ := actor.parseID(msg.ID)
+	stale := actor.messageID(mailbox, validity+1, uid)
+	if err := actor.Act(context.Background(), stale, func(mailsource.Actions) error { return nil }); err == nil {
+		t.Error("ожидалась ошибка для устаревшего UIDVALIDITY")
+	}
+}
+
+func TestParseID(t *testing.T) {
+	src, err := New(Config{Name: "test", Server: "imap.example.com:993", Username: "u"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := src.messageID("Архив:2024", 3, 42)
+	mailbox, validity, uid, err := src.parseID(id)
+	if err != nil || mailbox != "Архив:2024" || validity != 3 || uid != 42 {
+		t.Errorf("parseID(%q) = %q, %d, %d, %v", id, mailbox, validity, uid, err)
+	}
+
+	for _, id := range []string{"imap:other:INBOX:1:7", "imap:test:1:7", "imap:test:INBOX:1:x", "graph:test:m1"} {
+		if _, _, _, err := src.parseID(id); err == nil {
+			t.Errorf("parseID(%q): ожидалась ошибка", id)
+		}
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		wantErr bool
+	}{
+		{Config{Name: "a", Server: "imap.example.com:993", Username: "u"}, false},
+		{Config{Name: "a", Server: "imap.example.com", Username: "u"}, true},
+		{Config{Name: "a", Server: "imap.example.com:143", TLS: "ssl", Username: "u"}, true},
+		{Config{Server: "imap.example.com:993", Username: "u"}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.cfg.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) = %v, wantErr %t", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Пакет mailsource описывает источники писем и общую очередь событий о новых
+// письмах. Источники IMAP, Graph и каталога передают письма в общий конвейер
+// отправки уведомлений (Handler), а Outlook в режиме событий сообщает о письме
+// событием в очередь: ее обработчик получает письмо и передает его в конвейер.
+// Очередь не зависит от COM и проверяется синтетическими событиями.
+package mailsource
+
+import (
+	"context"
+	"time"
+)
+
+// Важность письма (значения совпадают с OlImportance)
+type Importance int
+
+const (
+	ImportanceLow    Importance = 0
+	ImportanceNormal Importance = 1
+	ImportanceHigh   Importance = 2
+)
+
+// Message - письмо, независимо от источника
+type Message struct {
+	ID             string // Уникальный идентификатор письма в источнике (EntryID для Outlook)
+	Folder         string // Путь папки, под которым письмо попадает в уведомление
+	SenderName     string
+	SenderEmail    string
+	Subject        string
+	Body           string // Тело письма: HTML, если IsHTML, иначе обычный текст
+	IsHTML         bool
+	ConversationID string // Идентификатор переписки для группировки уведомлений
+	Importance     Importance
+	Received       time.Time
+	Attachments    []Attachment
+}
+
+// Attachment - сведения о вложении (содержимое в уведомление не попадает)
+type Attachment struct {
+	Name        string
+	ContentType string
+	Size        int64
+}
+
+// Sender возвращает отправителя в виде "Имя <адрес>" или только адрес.
+func (m Message) Sender() string {
+	if m.SenderName != "" && m.SenderName != m.SenderEmail {
+		return m.SenderName + " <" + m.SenderEmail + ">"
+	}
+	return m.SenderEmail
+}
+
+// Actions - действия над письмом в источнике (для after_send)
+type Actions interface {
+	MarkRead() error
+	// Move перемещает письмо и возвращает его новый идентификатор, если он известен
+	Move(folder string) (string, error)
+	Categorize(category string) error
+}
+
+// Actor - источник, который выполняет действия над уже обработанным письмом
+// по его идентификатору (Message.ID). Действия after_send выполняются после
+// доставки уведомления, когда обработка письма давно закончена
+type Actor interface {
+	Act(ctx context.Context, id string, fn func(Actions) error) error
+}
+
+// Handler - общий конвейер отправки уведомлений. Возвращает false, если
+// уведомление не принято и письмо нужно обработать повторно
+type Handler func(msg Message) bool
@@ -0,0 +1,128 @@
+package mailsource
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset" // Кодировки windows-1251, koi8-r и др.
+	"github.com/emersion/go-message/mail"
+)
+
+// ParseMIME разбирает письмо в формате RFC 5322 (MIME). Если preferHTML
+// и в письме есть HTML-часть, тело берется из нее, иначе из текстовой части.
+// ID и Folder заполняет источник.
+func ParseMIME(r io.Reader, preferHTML bool) (Message, error) {
+	mr, err := mail.CreateReader(r)
+	if err != nil && !message.IsUnknownCharset(err) {
+		return Message{}, fmt.Errorf("ошибка разбора письма: %v", err)
+	}
+	defer mr.Close()
+
+	msg := Message{Importance: ImportanceNormal}
+	header := mr.Header
+
+	if from, err := header.AddressList("From"); err == nil && len(from) > 0 {
+		msg.SenderName = from[0].Name
+		msg.SenderEmail = from[0].Address
+	}
+	if subject, err := header.Subject(); err == nil {
+		msg.Subject = subject
+	} else {
+		msg.Subject = header.Get("Subject")
+	}
+	if date, err := header.Date(); err == nil {
+		msg.Received = date
+	}
+	msg.ConversationID = conversationID(header)
+	msg.Importance = parseImportance(header)
+
+	var text, html string
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if message.IsUnknownCharset(err) {
+				continue
+			}
+			return Message{}, fmt.Errorf("ошибка разбора части письма: %v", err)
+		}
+
+		switch h := part.Header.(type) {
+		case *mail.AttachmentHeader:
+			msg.Attachments = append(msg.Attachments, readAttachment(h.Header, part.Body))
+		case *mail.InlineHeader:
+			contentType, _, _ := h.ContentType()
+			switch {
+			case contentType == "text/plain" && text == "":
+				data, _ := io.ReadAll(part.Body)
+				text = string(data)
+			case contentType == "text/html" && html == "":
+				data, _ := io.ReadAll(part.Body)
+				html = string(data)
+			case !strings.HasPrefix(contentType, "text/"):
+				// Встроенные картинки и другие не текстовые части считаем вложениями
+				msg.Attachments = append(msg.Attachments, readAttachment(h.Header, part.Body))
+			}
+		}
+	}
+
+	switch {
+	case preferHTML && strings.TrimSpace(html) != "":
+		msg.Body, msg.IsHTML = html, true
+	case text != "":
+		msg.Body = text
+	default:
+		msg.Body, msg.IsHTML = html, html != ""
+	}
+	return msg, nil
+}
+
+// Читает сведения о вложении. Содержимое не сохраняется, только считается размер
+func readAttachment(header message.Header, body io.Reader) Attachment {
+	contentType, _, _ := header.ContentType()
+	// Filename учитывает и filename из Content-Disposition, и name из Content-Type
+	name, _ := (&mail.AttachmentHeader{Header: header}).Filename()
+	size, _ := io.Copy(io.Discard, body)
+	return Attachment{Name: name, ContentType: contentType, Size: size}
+}
+
+// Идентификатор переписки: первое письмо цепочки из References,
+// затем In-Reply-To, затем собственный Message-ID
+func conversationID(header mail.Header) string {
+	for _, key := range []string{"References", "In-Reply-To"} {
+		if ids, err := header.MsgIDList(key); err == nil && len(ids) > 0 {
+			return ids[0]
+		}
+	}
+	id, _ := header.MessageID()
+	return id
+}
+
+// Важность из заголовков Importance и X-Priority
+func parseImportance(header mail.Header) Importance {
+	switch strings.ToLower(strings.TrimSpace(header.Get("Importance"))) {
+	case "high":
+		return ImportanceHigh
+	case "low":
+		return ImportanceLow
+	}
+
+	// X-Priority: 1 (Highest) ... 5 (Lowest)
+	if fields := strings.Fields(header.Get("X-Priority")); len(fields) > 0 {
+		if n, err := strconv.Atoi(fields[0]); err == nil {
+			switch {
+			case n <= 2:
+				return ImportanceHigh
+			case n >= 4:
+				return ImportanceLow
+			}
+		}
+	}
+	return ImportanceNormal
+}
@@ -0,0 +1,110 @@
+package mailsource
+
+import (
+	"context"
+	"sync"
+)
+
+// Event - событие о новом письме
+type Event struct {
+	Source string // Имя источника
+	Folder string // Путь папки
+	ID     string // Идентификатор письма в источнике
+}
+
+// Queue - ограниченная очередь событий. Повторное событие о письме, которое
+// еще ждет обработки, отбрасывается. Если очередь переполнена, событие тоже
+// отбрасывается, а очередь отмечается переполненной: такие письма подберет
+// периодическая сверка (опрос папок).
+type Queue struct {
+	mutex      sync.Mutex
+	events     []Event
+	pending    map[string]bool
+	capacity   int
+	overflowed bool
+	notify     chan struct{}
+}
+
+// NewQueue создает очередь на capacity событий.
+func NewQueue(capacity int) *Queue {
+	return &Queue{
+		pending:  make(map[string]bool),
+		capacity: capacity,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func eventKey(ev Event) string {
+	return ev.Source + "\x00" + ev.ID
+}
+
+// Push добавляет событие. Возвращает false, если событие отброшено
+// (дубликат или переполнение). Не блокируется, поэтому подходит для
+// обработчиков событий COM.
+func (q *Queue) Push(ev Event) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	key := eventKey(ev)
+	if q.pending[key] {
+		return false
+	}
+	if len(q.events) >= q.capacity {
+		q.overflowed = true
+		return false
+	}
+
+	q.events = append(q.events, ev)
+	q.pending[key] = true
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Next возвращает следующее событие, ожидая его появления, или ошибку ctx.
+func (q *Queue) Next(ctx context.Context) (Event, error) {
+	for {
+		q.mutex.Lock()
+		if len(q.events) > 0 {
+			ev := q.events[0]
+			q.events = q.events[1:]
+			delete(q.pending, eventKey(ev))
+			if len(q.events) > 0 {
+				// Будим следующего получателя, если события еще остались
+				select {
+				case q.notify <- struct{}{}:
+				default:
+				}
+			}
+			q.mutex.Unlock()
+			return ev, nil
+		}
+		q.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Event{}, ctx.Err()
+		case <-q.notify:
+		}
+	}
+}
+
+// Len возвращает число событий, ожидающих обработки.
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.events)
+}
+
+// TakeOverflow сообщает, переполнялась ли очередь с прошлого вызова, и сбрасывает отметку.
+func (q *Queue) TakeOverflow() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	overflowed := q.overflowed
+	q.overflowed = false
+	return overflowed
+}
@@ -0,0 +1,149 @@
+package mailsource
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueOrderAndDedup(t *testing.T) {
+	q := NewQueue(10)
+	q.Push(Event{Source: "outlook", Folder: "@inbox", ID: "1"})
+	q.Push(Event{Source: "outlook", Folder: "@inbox", ID: "2"})
+	if q.Push(Event{Source: "outlook", Folder: "@inbox", ID: "1"}) {
+		t.Error("повторное событие о письме в очереди должно отбрасываться")
+	}
+	// Тот же идентификатор из другого источника - другое письмо
+	q.Push(Event{Source: "imap", Folder: "INBOX", ID: "1"})
+
+	if q.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", q.Len())
+	}
+
+	ctx := context.Background()
+	for _, want := range []string{"outlook/1", "outlook/2", "imap/1"} {
+		ev, err := q.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ev.Source + "/" + ev.ID; got != want {
+			t.Errorf("Next() = %s, want %s", got, want)
+		}
+	}
+
+	// После обработки событие о том же письме снова принимается
+	if !q.Push(Event{Source: "outlook", Folder: "@inbox", ID: "1"}) {
+		t.Error("событие после обработки должно приниматься")
+	}
+}
+
+func TestQueueOverflow(t *testing.T) {
+	q := NewQueue(2)
+	q.Push(Event{ID: "1"})
+	q.Push(Event{ID: "2"})
+	if q.Push(Event{ID: "3"}) {
+		t.Error("событие сверх емкости должно отбрасываться")
+	}
+	if !q.TakeOverflow() {
+		t.Error("TakeOverflow() = false после переполнения")
+	}
+	if q.TakeOverflow() {
+		t.Error("TakeOverflow() должен сбрасывать отметку")
+	}
+}
+
+func TestQueueNextCancel(t *testing.T) {
+	q := NewQueue(1)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := q.Next(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Next() error = %v, want DeadlineExceeded", err)
+	}
+}
+
+// Источник, генерирующий синтетические события
+type syntheticSource struct {
+	name   string
+	events []Event
+}
+
+func (s syntheticSource) Name() string { return s.name }
+
+func (s syntheticSource) Run(ctx context.Context, q *Queue) error {
+	for _, ev := range s.events {
+		ev.Source = s.name
+		for !q.Push(ev) {
+			// Очередь заполнена - ждем, пока обработчик ее разгрузит
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Millisecond):
+			}
+			q.TakeOverflow()
+		}
+	}
+	return nil
+}
+
+func TestQueueWithSources(t *testing.T) {
+	q := NewQueue(4)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sources := []syntheticSource{{name: "a"}, {name: "b"}}
+	const perSource = 50
+	for i := range sources {
+		for n := 0; n < perSource; n++ {
+			sources[i].events = append(sources[i].events, Event{Folder: "Inbox", ID: fmt.Sprint(n)})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, src := range sources {
+		wg.Add(1)
+		go func(src syntheticSource) {
+			defer wg.Done()
+			if err := src.Run(ctx, q); err != nil {
+				t.Errorf("%s: %v", src.Name(), err)
+			}
+		}(src)
+	}
+
+	received := make(map[string]bool)
+	for len(received) < perSource*len(sources) {
+		ev, err := q.Next(ctx)
+		if err != nil {
+			t.Fatalf("получено %d событий из %d: %v", len(received), perSource*len(sources), err)
+		}
+		key := ev.Source + "/" + ev.ID
+		if received[key] {
+			t.Errorf("событие %s получено дважды", key)
+		}
+		received[key] = true
+	}
+	wg.Wait()
+
+	if q.Len() != 0 {
+		t.Errorf("в очереди остались события: %d", q.Len())
+	}
+}
+
+func TestMessageSender(t *testing.T) {
+	tests := []struct {
+		msg  Message
+		want string
+	}{
+		{Message{SenderName: "Иван", SenderEmail: "ivan@example.com"}, "Иван <ivan@example.com>"},
+		{Message{SenderName: "ivan@example.com", SenderEmail: "ivan@example.com"}, "ivan@example.com"},
+		{Message{SenderEmail: "ivan@example.com"}, "ivan@example.com"},
+	}
+	for _, tt := range tests {
+		if got := tt.msg.Sender(); got != tt.want {
+			t.Errorf("Sender() = %q, want %q", got, tt.want)
+		}
+	}
+}
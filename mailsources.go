@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"otn/mailsource"
+	"otn/mailsource/dropsource"
+	"otn/mailsource/graphsource"
+	"otn/mailsource/imapsource"
+)
+
+// Письма папки берутся из Outlook, а не с IMAP-сервера, через Graph или из папки-приемника
+func (f Folder) isOutlook() bool {
+	return f.IMAPAccount == "" && f.GraphAccount == "" && f.DropDir == ""
+}
+
+// Проверяет папку, письма которой берутся не из Outlook
+func validateSourceFolder(folder *Folder) error {
+	sources := 0
+	for _, v := range []string{folder.IMAPAccount, folder.GraphAccount, folder.DropDir} {
+		if v != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources > 1:
+		return fmt.Errorf("imap_account, graph_account и drop_dir нельзя указывать одновременно")
+	case folder.IMAPAccount != "":
+		if !hasIMAPAccount(folder.IMAPAccount) {
+			return fmt.Errorf("учетная запись %q не найдена в imap_accounts", folder.IMAPAccount)
+		}
+	case folder.GraphAccount != "":
+		if !hasGraphAccount(folder.GraphAccount) {
+			return fmt.Errorf("учетная запись %q не найдена в graph_accounts", folder.GraphAccount)
+		}
+	case folder.DropDir != "":
+		for _, other := range config.Folders {
+			if other.label() != folder.label() && sameDir(other.DropDir, folder.DropDir) {
+				return fmt.Errorf("drop_dir %s уже используется папкой %s", folder.DropDir, other.label())
+			}
+		}
+		// Файлы не поддерживают категории, а отметка о прочтении не нужна:
+		// обработанный файл переносится в processed
+		if strings.HasPrefix(strings.TrimSpace(folder.AfterSend), "categorize") {
+			return fmt.Errorf("after_send categorize не поддерживается для drop_dir")
+		}
+	}
+
+	// Кнопки, ответы и остальные настройки ниже работают только с письмами Outlook
+	switch {
+	case len(folder.Buttons) > 0:
+		return fmt.Errorf("buttons поддерживаются только для папок Outlook")
+	case folder.AllowReply:
+		return fmt.Errorf("allow_reply поддерживается только для папок Outlook")
+	case folder.Recursive:
+		return fmt.Errorf("recursive поддерживается только для папок Outlook")
+	case folder.EntryID != "" || folder.StoreID != "":
+		return fmt.Errorf("entry_id и store_id используются только для папок Outlook")
+	case folder.Detection != "":
+		return fmt.Errorf("detection используется только для папок Outlook")
+	}
+	return nil
+}
+
+func hasIMAPAccount(name string) bool {
+	for _, account := range config.IMAPAccounts {
+		if account.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasGraphAccount(name string) bool {
+	for _, account := range config.GraphAccounts {
+		if account.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findGraphAccount(name string) graphsource.Config {
+	for _, account := range config.GraphAccounts {
+		if account.Name == name {
+			return account
+		}
+	}
+	return graphsource.Config{}
+}
+
+// Указывают ли пути на один каталог
+func sameDir(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	return strings.EqualFold(filepath.Clean(a), filepath.Clean(b))
+}
+
+// Проверяет учетные записи IMAP
+func validateIMAPAccounts() error {
+	names := make(map[string]bool)
+	for i, account := range config.IMAPAccounts {
+		if err := account.Validate(); err != nil {
+			return fmt.Errorf("Ошибка в imap_accounts %d: %v", i, err)
+		}
+		if names[account.Name] {
+			return fmt.Errorf("Повторяющееся имя учетной записи IMAP: %s", account.Name)
+		}
+		names[account.Name] = true
+	}
+	return nil
+}
+
+// Проверяет учетные записи Graph
+func validateGraphAccounts() error {
+	names := make(map[string]bool)
+	for i, account := range config.GraphAccounts {
+		if err := account.Validate(); err != nil {
+			return fmt.Errorf("Ошибка в graph_accounts %d: %v", i, err)
+		}
+		if names[account.Name] {
+			return fmt.Errorf("Повторяющееся имя учетной записи Graph: %s", account.Name)
+		}
+		names[account.Name] = true
+	}
+	return nil
+}
+
+// Создает источник IMAP для папок источника
+func (src *configuredSource) newIMAPSource() (*imapsource.Source, error) {
+	var account imapsource.Config
+	for _, a := range config.IMAPAccounts {
+		if a.Name == src.account {
+			account = a
+		}
+	}
+
+	var mailboxes []imapsource.Mailbox
+	for _, folder := range src.folders() {
+		mailboxes = append(mailboxes, imapsource.Mailbox{
+			Name:         folder.Name,
+			Folder:       folder.label(),
+			HTML:         folder.HTMLBody && folder.MessageLength != 0,
+			StartFromNow: folder.StartFromNow,
+		})
+	}
+
+	source, err := imapsource.New(account, mailboxes, state)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка настройки IMAP %s: %v", account.Name, err)
+	}
+	if src.interval > 0 {
+		source.PollInterval = src.interval
+	}
+	source.Logf = logMessage
+	source.Errorf = src.errorf
+	return source, nil
+}
+
+// Получает письма с IMAP-сервера для папок источника
+func (src *configuredSource) runIMAP(ctx context.Context) error {
+	source, err := src.newIMAPSource()
+	if err != nil {
+		return err
+	}
+	logMessage("Отслеживание почтовых ящиков IMAP %s: %d", src.account, len(src.folders()))
+	return source.Run(ctx, handleSourceMessage)
+}
+
+// Создает источник Graph для папок источника
+func (src *configuredSource) newGraphSource() (*graphsource.Source, error) {
+	account := findGraphAccount(src.account)
+
+	var folders []graphsource.MailFolder
+	for _, folder := range src.folders() {
+		folders = append(folders, graphsource.MailFolder{
+			Path:         folder.Name,
+			Folder:       folder.label(),
+			HTML:         folder.HTMLBody && folder.MessageLength != 0,
+			StartFromNow: folder.StartFromNow,
+		})
+	}
+
+	source, err := graphsource.New(account, folders, state)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка настройки Graph %s: %v", account.Name, err)
+	}
+	if src.interval > 0 {
+		source.PollInterval = src.interval
+	}
+	source.Client = httpClient
+	source.Logf = logMessage
+	source.Errorf = src.errorf
+	return source, nil
+}
+
+// Получает письма через Microsoft Graph для папок источника
+func (src *configuredSource) runGraph(ctx context.Context) error {
+	source, err := src.newGraphSource()
+	if err != nil {
+		return err
+	}
+	logMessage("Отслеживание папок ящика %s через Graph: %d", findGraphAccount(src.account).Mailbox, len(src.folders()))
+	return source.Run(ctx, handleSourceMessage)
+}
+
+// Создает источник из папок-приемников источника
+func (src *configuredSource) newDropSource() *dropsource.Source {
+	var dirs []dropsource.Dir
+	for _, folder := range src.folders() {
+		dirs = append(dirs, dropsource.Dir{
+			Path:   folder.DropDir,
+			Folder: folder.label(),
+			HTML:   folder.HTMLBody && folder.MessageLength != 0,
+		})
+	}
+
+	source := dropsource.New(dirs)
+	if src.interval > 0 {
+		source.PollInterval = src.interval
+	}
+	source.Logf = logMessage
+	source.Errorf = src.errorf
+	return source
+}
+
+// Получает письма из папок-приемников источника
+func (src *configuredSource) runDrop(ctx context.Context) error {
+	logMessage("Отслеживание папок-приемников %s: %d", src.name, len(src.folders()))
+	return src.newDropSource().Run(ctx, handleSourceMessage)
+}
+
+// Передает письмо с IMAP-сервера, из Graph или из папки-приемника в общий конвейер уведомлений
+func handleSourceMessage(msg mailsource.Message) bool {
+	// Пересылка приостановлена командой /pause: позиция в источнике не сдвигается,
+	// и письмо будет отправлено после /resume
+	if state.isPaused(msg.Folder) {
+		return false
+	}
+	return processMessage(msg)
+}
+
+// Источники для действий after_send по имени источника. Действия выполняет
+// очередь уведомлений после доставки, поэтому для них создается отдельный
+// экземпляр источника, не связанный с отслеживанием писем
+var (
+	actorsMutex sync.Mutex
+	mailActors  = make(map[string]mailsource.Actor)
+)
+
+// Возвращает источник, который выполняет действия над его письмами
+func sourceActor(name string) (mailsource.Actor, error) {
+	actorsMutex.Lock()
+	defer actorsMutex.Unlock()
+
+	if actor, ok := mailActors[name]; ok {
+		return actor, nil
+	}
+	src := findSource(name)
+	if src == nil {
+		return nil, fmt.Errorf("источник %s не найден", name)
+	}
+
+	var actor mailsource.Actor
+	switch src.kind {
+	case sourceOutlook:
+		actor = outlookSource{}
+	case sourceIMAP:
+		source, err := src.newIMAPSource()
+		if err != nil {
+			return nil, err
+		}
+		actor = source
+	case sourceGraph:
+		source, err := src.newGraphSource()
+		if err != nil {
+			return nil, err
+		}
+		actor = source
+	case sourceDrop:
+		actor = src.newDropSource()
+	default:
+		return nil, fmt.Errorf("неизвестный тип источника %s", src.kind)
+	}
+	mailActors[name] = actor
+	return actor, nil
+}
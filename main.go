@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -36,6 +37,16 @@ import (
 	"github.com/shirou/gopsutil/process"
 	"golang.org/x/net/proxy"
 	"golang.org/x/sys/windows/svc/eventlog"
+
+	"otn/alerts"
+	"otn/cleanup"
+	"otn/folderref"
+	"otn/htmlconv"
+	"otn/mailsource"
+	"otn/mailsource/graphsource"
+	"otn/mailsource/imapsource"
+	"otn/notify"
+	"otn/redact"
 )
 
 //go:generate winres -output resource.syso version.rc
@@ -45,16 +56,42 @@ type Config struct {
 		BotToken      string `json:"bot_token"`
 		DefaultChatID string `json:"default_chat_id"`
 		UseEmojis     bool   `json:"use_emojis"`
+		// Отправлять письма одной переписки ответом на первое уведомление
+		ThreadConversations bool `json:"thread_conversations"`
+		// Пользователи Telegram, которым разрешено нажимать кнопки и отправлять команды боту
+		AllowedUserIDs []int64 `json:"allowed_user_ids"`
 	} `json:"telegram"`
-	Proxy                ProxyConfig `json:"proxy"`
-	CheckIntervalSeconds int         `json:"check_interval_seconds"`
-	LoggingEnabled       bool        `json:"logging_enabled"`      // Логирование в приложение
-	FileLoggingEnabled   bool        `json:"file_logging_enabled"` // Логирование в файл
-	StartMinimized       bool        `json:"start_minimized"`      // Запуск программы в трее
-	CutText              string      `json:"cut_text"`
-	Folders              []Folder    `json:"folders"`
-	IP                   string      `json:"ip"`
-	Port                 int         `json:"port"`
+	Proxy                ProxyConfig         `json:"proxy"`
+	CheckIntervalSeconds int                 `json:"check_interval_seconds"`
+	LoggingEnabled       bool                `json:"logging_enabled"`      // Логирование в приложение
+	FileLoggingEnabled   bool                `json:"file_logging_enabled"` // Логирование в файл
+	StartMinimized       bool                `json:"start_minimized"`      // Запуск программы в трее
+	CutText              string              `json:"cut_text"`
+	Folders              []Folder            `json:"folders"`
+	IP                   string              `json:"ip"`
+	Port                 int                 `json:"port"`
+	Redaction            *redact.Config      `json:"redaction,omitempty"` // Скрытие чувствительных данных
+	HistoryDays          int                 `json:"history_days"`        // Сколько дней хранить историю доставки
+	QueueMaxAgeHours     int                 `json:"queue_max_age_hours"` // Сколько часов повторять отправку уведомления из очереди
+	Reports              []ReportConfig      `json:"reports"`             // Периодические сводки
+	AlertParsers         []AlertParserConfig `json:"alert_parsers"`       // Разбор писем систем мониторинга
+	DebugLogging         bool                `json:"debug_logging"`       // Подробный журнал (время этапов проверки почты)
+
+	// Режим событий: новые письма приходят событиями Outlook, а опрос папок
+	// выполняется реже как сверка
+	EventMode                bool `json:"event_mode"`
+	ReconcileIntervalSeconds int  `json:"reconcile_interval_seconds"`
+
+	// Учетные записи IMAP для папок с imap_account
+	IMAPAccounts []imapsource.Config `json:"imap_accounts"`
+	// Почтовые ящики Microsoft Graph для папок с graph_account
+	GraphAccounts []graphsource.Config `json:"graph_accounts"`
+	// Источники писем со своими папками: Outlook, IMAP, Graph и папки-приемники
+	Sources []SourceConfig `json:"sources"`
+	// Дополнительные каналы уведомлений: Slack, Mattermost, Matrix, ntfy, Gotify, вебхуки и другие боты Telegram
+	Channels []notify.Config `json:"channels"`
+	// Ограничение частоты сообщений Telegram по чатам
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
 }
 
 type ProxyConfig struct {
@@ -68,9 +105,45 @@ type ProxyConfig struct {
 }
 
 type Folder struct {
-	Name          string `json:"name"`
-	ChatID        string `json:"chat_id"`
-	MessageLength int    `json:"message_length"`
+	Name          string   `json:"name"`
+	Title         string   `json:"title"`         // Подпись папки в уведомлениях и командах (не для Outlook), по умолчанию name
+	IMAPAccount   string   `json:"imap_account"`  // Брать письма с IMAP-сервера; name - имя почтового ящика
+	GraphAccount  string   `json:"graph_account"` // Брать письма через Microsoft Graph
+	DropDir       string   `json:"drop_dir"`      // Брать письма из файлов .eml/.msg или каталога Maildir
+	ChatID        string   `json:"chat_id"`
+	Channels      []string `json:"channels"` // Куда отправлять уведомления: имена из channels и telegram (чат папки), по умолчанию только telegram
+	MessageLength int      `json:"message_length"`
+	HTMLBody      bool     `json:"html_body"` // Брать HTML-тело письма и сохранять форматирование
+
+	Buttons       []string `json:"buttons"`        // Кнопки под уведомлением: read, flag, archive
+	ArchiveFolder string   `json:"archive_folder"` // Папка для кнопки archive
+	AllowReply    bool     `json:"allow_reply"`    // Отправлять ответы из Telegram ответом на письмо
+
+	AfterSend string          `json:"after_send"` // Действие после отправки: none, mark_read, move_to:<папка>, categorize:<категория>
+	afterSend afterSendAction // Разобранное действие after_send (заполняется при валидации)
+
+	Recursive bool     `json:"recursive"` // Отслеживать также подпапки
+	Include   []string `json:"include"`   // Шаблоны имен подпапок, которые нужно отслеживать
+	Exclude   []string `json:"exclude"`   // Шаблоны имен подпапок, которые нужно пропустить
+
+	EntryID string `json:"entry_id"` // Закрепление папки по идентификатору (необязательно)
+	StoreID string `json:"store_id"` // Идентификатор хранилища для entry_id
+
+	Detection    string `json:"detection"`      // Способ обнаружения новых писем: unread или received_time
+	StartFromNow bool   `json:"start_from_now"` // Для received_time: при первом запуске не отправлять уже полученные письма
+
+	Cleanup *cleanup.Options `json:"cleanup,omitempty"` // Очистка тела от цитат, подписей и дисклеймеров
+	cleaner *cleanup.Cleaner // Подготовленные правила очистки (заполняется при валидации)
+
+	source string // Имя источника писем (заполняется при валидации)
+}
+
+// Имя, под которым письма папки попадают в уведомления, /pause, /mute и статистику
+func (f Folder) label() string {
+	if f.Title != "" {
+		return f.Title
+	}
+	return f.Name
 }
 
 // Глобальный клиент (инициализируется при старте)
@@ -80,7 +153,11 @@ var httpClient *http.Client
 func initHTTPClient(cfg Config) error {
 	var err error
 	httpClient, err = NewHTTPClientWithProxy(cfg.Proxy)
-	return err
+	if err != nil {
+		return err
+	}
+	updatesClient = longPollClient(httpClient)
+	return nil
 }
 
 var (
@@ -106,6 +183,9 @@ var (
 
 	// Для логирования в eventlog
 	eventLog *eventlog.Log
+
+	// Правила скрытия чувствительных данных (nil - выключено)
+	redactor *redact.Redactor
 )
 
 const httpTimeout = 10 * time.Second
@@ -376,12 +456,29 @@ func main() {
 	if err := initHTTPClient(config); err != nil {
 		log.Fatalf("Ошибка инициализации HTTP-клиента: %v", err)
 	}
+	initTelegramLimiter()
+	initChannels()
 
 	// Проверка доступа к боту
 	if err := checkBotAccess(config.Telegram.BotToken); err != nil {
 		logMessage("Нет доступа к Telegram боту: %v", err)
 	}
 
+	// Загрузка сохраненного состояния (отправленные оповещения и переписки)
+	if err := initState("state.json"); err != nil {
+		logMessage("Ошибка загрузки состояния: %v", err)
+	}
+
+	// Загрузка истории доставки для сводок
+	if err := initHistory("history.jsonl", config.HistoryDays); err != nil {
+		logMessage("Ошибка загрузки истории: %v", err)
+	}
+
+	// Очередь исходящих уведомлений (переживает перезапуск программы)
+	if err := initOutbox(); err != nil {
+		log.Fatalf("Ошибка открытия очереди уведомлений: %v", err)
+	}
+
 	// Запускаем трей-иконку в отдельной горутине
 	// logMessage("Запуск трей-иконки...")
 	safeGo(func() {
@@ -403,8 +500,18 @@ func main() {
 	if len(errors) == 0 {
 		//startCounter()
 		safeGo(func() {
-			mainLogic(ctx)
+			runReports(ctx)
+		})
+		safeGo(func() {
+			runHistoryCompaction(ctx)
 		})
+		safeGo(func() {
+			runUpdates(ctx)
+		})
+		startOutbox(ctx)
+		// Каждый источник писем работает в своей горутине и перезапускается
+		// после сбоя. Outlook нужен только для папок без imap_account, graph_account и drop_dir
+		startSources(ctx)
 	}
 
 	// Запускаем главный цикл приложения
@@ -418,6 +525,9 @@ func startHTTPServer(address string) error {
 
 	httpServer = &http.Server{Addr: address}
 
+	http.HandleFunc("/metrics", handleMetrics)
+	http.HandleFunc("/status", handleStatus)
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		response := map[string]string{
 			"service": "OTN",
@@ -688,11 +798,21 @@ func validateConfig() error {
 		return fmt.Errorf("UseEmojis должно быть true или false")
 	}
 
+	// Разбор источников писем (до проверки интервала: источник outlook задает его сам)
+	if err := setupSources(); err != nil {
+		return err
+	}
+
 	// Проверка CheckIntervalSeconds
 	if config.CheckIntervalSeconds < 0 || config.CheckIntervalSeconds > 1000 {
 		return fmt.Errorf("CheckIntervalSeconds должно быть в диапазоне от 0 до 1000")
 	}
 
+	// Проверка ReconcileIntervalSeconds
+	if config.ReconcileIntervalSeconds < 0 || config.ReconcileIntervalSeconds > 86400 {
+		return fmt.Errorf("ReconcileIntervalSeconds должно быть в диапазоне от 0 до 86400")
+	}
+
 	// Проверка LoggingEnabled
 	if config.LoggingEnabled != true && config.LoggingEnabled != false {
 		return fmt.Errorf("LoggingEnabled должно быть true или false")
@@ -713,13 +833,48 @@ func validateConfig() error {
 		return fmt.Errorf("CutText должен быть либо пустым, либо иметь длину не менее 4 символов")
 	}
 
+	// Проверка учетных записей IMAP и Graph
+	if err := validateIMAPAccounts(); err != nil {
+		return err
+	}
+	if err := validateGraphAccounts(); err != nil {
+		return err
+	}
+
 	// Проверка Folders
-	for i, folder := range config.Folders {
+	for i := range config.Folders {
+		folder := &config.Folders[i]
+
 		// Проверка Name
 		if len(folder.Name) == 0 || len(folder.Name) > 150 {
 			return fmt.Errorf("Name в папке %d должен быть текстом длиной от 1 до 150 символов", i)
 		}
 
+		// Проверка Title
+		if len(folder.Title) > 150 {
+			return fmt.Errorf("Title в папке %d должен быть не длиннее 150 символов", i)
+		}
+		if folder.Title != "" && folder.isOutlook() {
+			return fmt.Errorf("title в папке %d используется только для папок IMAP, Graph и drop_dir", i)
+		}
+		for _, other := range config.Folders[:i] {
+			if other.label() == folder.label() {
+				return fmt.Errorf("Повторяющееся имя папки %d: %s (задайте title)", i, folder.label())
+			}
+		}
+
+		// Проверка ссылки на папку
+		if folder.IMAPAccount == "" && folder.DropDir == "" {
+			if _, err := folderref.Parse(folder.Name); err != nil {
+				return fmt.Errorf("Некорректное имя папки %d: %v", i, err)
+			}
+		}
+		if !folder.isOutlook() {
+			if err := validateSourceFolder(folder); err != nil {
+				return fmt.Errorf("Ошибка в папке %d: %v", i, err)
+			}
+		}
+
 		// Проверка ChatID
 		if !isValidChatID(folder.ChatID) {
 			return fmt.Errorf("Некорректный ChatID в папке %d", i)
@@ -729,6 +884,48 @@ func validateConfig() error {
 		if folder.MessageLength < 0 || folder.MessageLength > 4000 {
 			return fmt.Errorf("MessageLength в папке %d должно быть в диапазоне от 0 до 4000", i)
 		}
+
+		// Проверка кнопок
+		if err := validateButtons(folder); err != nil {
+			return fmt.Errorf("Ошибка в buttons папки %d: %v", i, err)
+		}
+
+		// Ответы принимаются только от пользователей из allowed_user_ids:
+		// без них обновления Telegram не читаются
+		if folder.AllowReply && len(config.Telegram.AllowedUserIDs) == 0 {
+			return fmt.Errorf("для allow_reply в папке %d нужно указать telegram.allowed_user_ids", i)
+		}
+
+		// Проверка шаблонов подпапок
+		if !folder.Recursive && (len(folder.Include) > 0 || len(folder.Exclude) > 0) {
+			return fmt.Errorf("include и exclude в папке %d используются только с recursive: true", i)
+		}
+		if err := folder.subfolderFilter().Validate(); err != nil {
+			return fmt.Errorf("Ошибка в include/exclude папки %d: %v", i, err)
+		}
+
+		// Проверка способа обнаружения новых писем (только для Outlook)
+		if folder.isOutlook() {
+			if err := validateDetection(folder); err != nil {
+				return fmt.Errorf("Ошибка в detection папки %d: %v", i, err)
+			}
+		}
+
+		// Проверка действия после отправки
+		afterSend, err := parseAfterSend(folder.AfterSend)
+		if err != nil {
+			return fmt.Errorf("Ошибка в after_send папки %d: %v", i, err)
+		}
+		folder.afterSend = afterSend
+
+		// Проверка и подготовка правил очистки тела письма
+		if folder.Cleanup != nil {
+			cleaner, err := cleanup.New(*folder.Cleanup)
+			if err != nil {
+				return fmt.Errorf("Ошибка в cleanup папки %d: %v", i, err)
+			}
+			folder.cleaner = cleaner
+		}
 	}
 
 	// Проверка IP
@@ -741,6 +938,45 @@ func validateConfig() error {
 		return fmt.Errorf("Port должен быть в диапазоне от 1024 до 49151")
 	}
 
+	// Проверка и подготовка правил скрытия данных
+	if config.Redaction != nil {
+		r, err := redact.New(*config.Redaction)
+		if err != nil {
+			return fmt.Errorf("Ошибка в redaction: %v", err)
+		}
+		redactor = r
+	}
+
+	// Проверка HistoryDays
+	if config.HistoryDays < 0 || config.HistoryDays > 365 {
+		return fmt.Errorf("HistoryDays должно быть в диапазоне от 0 до 365")
+	}
+
+	// Проверка QueueMaxAgeHours
+	if config.QueueMaxAgeHours < 0 || config.QueueMaxAgeHours > 720 {
+		return fmt.Errorf("QueueMaxAgeHours должно быть в диапазоне от 0 до 720")
+	}
+
+	// Проверка ограничения частоты сообщений
+	if err := validateRateLimit(); err != nil {
+		return err
+	}
+
+	// Проверка сводок
+	if err := validateReports(); err != nil {
+		return err
+	}
+
+	// Проверка парсеров оповещений
+	if err := validateAlertParsers(); err != nil {
+		return err
+	}
+
+	// Проверка каналов уведомлений
+	if err := validateChannels(); err != nil {
+		return err
+	}
+
 	// Проверка Proxy (если включен)
 	if config.Proxy.Enabled {
 		// Тип прокси
@@ -882,6 +1118,13 @@ func logMessage(format string, args ...interface{}) {
 	log.Println(message)
 }
 
+// Сообщение подробного журнала, выводится только при debug_logging
+func logDebug(format string, args ...interface{}) {
+	if config.DebugLogging {
+		logMessage("[debug] "+format, args...)
+	}
+}
+
 func logErrorToFile(err error) {
 	// Открываем файл error.log в режиме добавления
 	file, openErr := os.OpenFile("error.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
@@ -901,8 +1144,8 @@ func logErrorToFile(err error) {
 
 var semaphore = make(chan struct{}, 1) // Ограничение до 1 одновременных горутин
 
-// Основная логика программы и ее функции
-func mainLogic(ctx context.Context) {
+// Основная логика программы и ее функции. Работает, пока не отменен ctx
+func mainLogic(ctx context.Context) error {
 	logMessage("Приложение успешно запущено и готово к работе")
 	eventLog.Info(0, "Приложение успешно запущено и готово к работе")
 
@@ -913,17 +1156,20 @@ func mainLogic(ctx context.Context) {
 	// Инициализация COM с обработкой ошибок
 	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err != nil {
 		if oleErr, ok := err.(*ole.OleError); ok {
-			logMessage("COM ошибка: код=%v, сообщение=%v", oleErr.Code(), oleErr.Error())
-		} else {
-			logMessage("Неизвестная ошибка: %v", err)
+			return fmt.Errorf("ошибка инициализации COM: код=%v, сообщение=%v", oleErr.Code(), oleErr.Error())
 		}
-		os.Exit(1)
+		return fmt.Errorf("ошибка инициализации COM: %v", err)
 	}
 
 	defer ole.CoUninitialize()
 
-	for {
-		semaphore <- struct{}{} // Захватываем слот семафора
+	for ctx.Err() == nil {
+		// Захватываем слот семафора
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
 
 		// Запускаем через safeGoNoLog, т.к. го рутина переодически падает, не понятно из за API Windows или из за пакета go-ole
 		safeGoNoLog(func() {
@@ -958,9 +1204,12 @@ func mainLogic(ctx context.Context) {
 			}
 
 			// Пытаемся инициализировать Outlook
+			cycleStart := time.Now()
 			outlook, ns, err := initializeOutlook()
 			if err != nil {
 				logMessage("Ошибка инициализации Outlook: %v", err)
+				stats.pollFailed(err)
+				stats.sourceFailed(outlookSourceName, err)
 
 				// Завершаем процесс OUTLOOK.EXE
 				err = killOutlookProcess()
@@ -972,23 +1221,36 @@ func mainLogic(ctx context.Context) {
 				return
 			}
 
-			folders := getTargetFolders(ns)
+			initDone := time.Now()
+
+			folders, cached := targetFolderCache.get(ns)
 			if len(folders) == 0 {
 				logMessage("Не найдено ни одной целевой папки")
 				releaseObjects(outlook, ns)
 				return
 			}
+			resolveDone := time.Now()
 
 			processFolders(folders)
+			stats.pollDone()
 
+			logDebug("Цикл проверки: %v (подключение к Outlook %v, папки %v из кэша: %t, обработка %v)",
+				time.Since(cycleStart).Round(time.Millisecond),
+				initDone.Sub(cycleStart).Round(time.Millisecond),
+				resolveDone.Sub(initDone).Round(time.Millisecond), cached,
+				time.Since(resolveDone).Round(time.Millisecond))
+
+			for _, folder := range folders {
+				folder.Release()
+			}
 			releaseObjects(outlook, ns)
 
 		})
 
 		// Ждем перед следующей попыткой
-		waitNextCheck()
+		waitNextCheck(ctx)
 	}
-
+	return nil
 }
 
 func releaseObjects(objs ...*ole.IDispatch) {
@@ -1015,7 +1277,13 @@ func isOutlookRunning() bool {
 	return false
 }
 
+// Запускает Outlook. Если у источника outlook задан profile, Outlook запускается с этим профилем
 func startOutlook() error {
+	var args []string
+	if profile := outlookProfile(); profile != "" {
+		args = append(args, "/profile", profile)
+	}
+
 	paths := []string{
 		`C:\Program Files\Microsoft Office\root\Office16\OUTLOOK.EXE`,
 		`C:\Program Files (x86)\Microsoft Office\root\Office16\OUTLOOK.EXE`,
@@ -1025,10 +1293,10 @@ func startOutlook() error {
 
 	for _, path := range paths {
 		if _, err := os.Stat(path); err == nil {
-			return exec.Command(path).Start()
+			return exec.Command(path, args...).Start()
 		}
 	}
-	return exec.Command("outlook.exe").Start()
+	return exec.Command("outlook.exe", args...).Start()
 }
 
 func initializeOutlook() (*ole.IDispatch, *ole.IDispatch, error) {
@@ -1083,123 +1351,376 @@ func getTargetFolders(ns *ole.IDispatch) map[string]*ole.IDispatch {
 	folders := make(map[string]*ole.IDispatch)
 
 	for _, folderCfg := range config.Folders {
-		folder, err := getFolder(ns, folderCfg.Name)
+		if !folderCfg.isOutlook() {
+			continue // Письма берутся с IMAP-сервера или через Graph
+		}
+
+		folder, err := getConfiguredFolder(ns, folderCfg)
 		if err != nil {
 			logMessage("Ошибка поиска папки %s: %v", folderCfg.Name, err)
 			continue
 		}
-		folders[folderCfg.Name] = folder
+
+		if !folderCfg.Recursive {
+			folders[folderCfg.Name] = folder
+			continue
+		}
+
+		// Подпапки отслеживаются под своими путями: "Clients/Project A"
+		entries, err := folderref.Subtree(outlookFolder{folder}, folderCfg.Name, folderCfg.subfolderFilter())
+		if err != nil {
+			logMessage("Ошибка получения подпапок %s: %v", folderCfg.Name, err)
+			folders[folderCfg.Name] = folder
+			continue
+		}
+		for _, entry := range entries {
+			folders[entry.Path] = entry.Folder.(outlookFolder).d
+		}
 	}
 
 	return folders
 }
 
-func getFolder(ns *ole.IDispatch, name string) (*ole.IDispatch, error) {
-	if name == "Входящие" {
-		return oleutil.MustCallMethod(ns, "GetDefaultFolder", 6).ToIDispatch(), nil
+func processFolders(folders map[string]*ole.IDispatch) {
+	for folderName, folder := range folders {
+		// Пересылка приостановлена командой /pause: письма останутся непрочитанными
+		// и будут отправлены после /resume
+		folderConfig, _ := findFolderConfig(folderName)
+		if state.isPaused(folderName) || state.isPaused(folderConfig.Name) {
+			continue
+		}
+
+		start := time.Now()
+		if folderConfig.Detection == detectionReceivedTime {
+			processFolderByWatermark(folder, folderName, folderConfig)
+		} else {
+			processUnread(folder, folderName)
+		}
+		logDebug("Папка %s обработана за %v", folderName, time.Since(start).Round(time.Millisecond))
 	}
-	return findFolderRecursive(ns, name)
 }
 
-func findFolderRecursive(parent *ole.IDispatch, target string) (*ole.IDispatch, error) {
-	folders := oleutil.MustGetProperty(parent, "Folders").ToIDispatch()
-	defer folders.Release()
+// Обрабатывает непрочитанные письма папки. Возвращает false, если какое-то уведомление не отправлено
+func processUnread(folder *ole.IDispatch, folderName string) bool {
+	items := oleutil.MustCallMethod(folder, "Items").ToIDispatch()
+	defer items.Release()
 
-	count := int(oleutil.MustGetProperty(folders, "Count").Val)
-	for i := 1; i <= count; i++ {
-		folder := oleutil.MustCallMethod(folders, "Item", i).ToIDispatch()
-		currentName := oleutil.MustGetProperty(folder, "Name").ToString()
+	filtered := oleutil.MustCallMethod(items, "Restrict", "[UnRead] = true").ToIDispatch()
+	defer filtered.Release()
 
-		if currentName == target {
-			return folder, nil
-		}
+	// logMessage("Найдено %d новых сообщений в папке '%s'", count, folderName)
 
-		subFolder, err := findFolderRecursive(folder, target)
-		folder.Release()
-		if err == nil {
-			return subFolder, nil
+	ok := true
+	for _, item := range collectItems(filtered) {
+		if !processEmail(item, folderName) {
+			ok = false
 		}
+		item.Release()
 	}
+	return ok
+}
 
-	return nil, fmt.Errorf("папка '%s' не найдена", target)
+// Собирает письма коллекции. Действия after_send (отметка прочитанным, перемещение)
+// меняют состав отфильтрованной коллекции, поэтому обходить ее по индексу во время обработки нельзя
+func collectItems(filtered *ole.IDispatch) []*ole.IDispatch {
+	count := int(oleutil.MustGetProperty(filtered, "Count").Val)
+	batch := make([]*ole.IDispatch, 0, count)
+	for i := 1; i <= count; i++ {
+		batch = append(batch, oleutil.MustCallMethod(filtered, "Item", i).ToIDispatch())
+	}
+	return batch
 }
 
-func processFolders(folders map[string]*ole.IDispatch) {
-	for folderName, folder := range folders {
-		items := oleutil.MustCallMethod(folder, "Items").ToIDispatch()
-		defer items.Release()
+// Отправляет уведомление о письме Outlook. Возвращает false, если уведомление
+// не доставлено и письмо нужно обработать повторно
+func processEmail(item *ole.IDispatch, folderName string) bool {
+	// subjectID := oleutil.MustGetProperty(item, "Subject").ToString()
+	entryIDVar, err := oleutil.GetProperty(item, "EntryID")
+	if err != nil {
+		logMessage("Ошибка получения EntryID: %v", err)
+		return true
+	}
+	entryID := entryIDVar.ToString()
+
+	if isProcessed(entryID) {
+		// logMessage("Сообщение уже отправлено в Telegram: %s", subjectID)
+		return true
+	}
 
-		filtered := oleutil.MustCallMethod(items, "Restrict", "[UnRead] = true").ToIDispatch()
-		defer filtered.Release()
+	return processMessage(outlookMessage(item, entryID, folderName))
+}
 
-		count := int(oleutil.MustGetProperty(filtered, "Count").Val)
-		if count == 0 {
-			continue
-		}
+// Читает письмо Outlook
+func outlookMessage(item *ole.IDispatch, entryID, folderName string) mailsource.Message {
+	folderConfig, _ := findFolderConfig(folderName)
 
-		// logMessage("Найдено %d новых сообщений в папке '%s'", count, folderName)
+	msg := mailsource.Message{
+		ID:             entryID,
+		Folder:         folderName,
+		SenderName:     oleutil.MustGetProperty(item, "SenderName").ToString(),
+		SenderEmail:    oleutil.MustGetProperty(item, "SenderEmailAddress").ToString(),
+		Subject:        oleutil.MustGetProperty(item, "Subject").ToString(),
+		ConversationID: getConversationID(item),
+		Importance:     mailsource.ImportanceNormal,
+	}
+	if v, err := oleutil.GetProperty(item, "Importance"); err == nil {
+		msg.Importance = mailsource.Importance(v.Val)
+	}
+	if received, err := itemReceivedTime(item); err == nil {
+		msg.Received = received
+	}
 
-		for i := 1; i <= count; i++ {
-			item := oleutil.MustCallMethod(filtered, "Item", i).ToIDispatch()
-			processEmail(item, folderName)
-			item.Release()
+	// HTML-тело читаем только если оно нужно, иначе берем обычный текст
+	if folderConfig.HTMLBody && folderConfig.MessageLength != 0 {
+		if htmlVar, err := oleutil.GetProperty(item, "HTMLBody"); err == nil {
+			msg.Body = htmlVar.ToString()
+			msg.IsHTML = strings.TrimSpace(msg.Body) != ""
 		}
 	}
+	if !msg.IsHTML {
+		msg.Body = oleutil.MustGetProperty(item, "Body").ToString()
+	}
+	msg.Attachments = outlookAttachments(item)
+	return msg
 }
 
-func processEmail(item *ole.IDispatch, folderName string) {
-	// subjectID := oleutil.MustGetProperty(item, "Subject").ToString()
-	entryIDVar, err := oleutil.GetProperty(item, "EntryID")
+// Читает сведения о вложениях письма Outlook
+func outlookAttachments(item *ole.IDispatch) []mailsource.Attachment {
+	attachmentsVar, err := oleutil.GetProperty(item, "Attachments")
 	if err != nil {
-		logMessage("Ошибка получения EntryID: %v", err)
-		return
+		return nil
 	}
-	entryID := entryIDVar.ToString()
+	attachments := attachmentsVar.ToIDispatch()
+	defer attachments.Release()
+
+	count := int(oleutil.MustGetProperty(attachments, "Count").Val)
+	result := make([]mailsource.Attachment, 0, count)
+	for i := 1; i <= count; i++ {
+		attachment := oleutil.MustCallMethod(attachments, "Item", i).ToIDispatch()
+		a := mailsource.Attachment{}
+		if v, err := oleutil.GetProperty(attachment, "FileName"); err == nil {
+			a.Name = v.ToString()
+		}
+		if v, err := oleutil.GetProperty(attachment, "Size"); err == nil {
+			a.Size = v.Val
+		}
+		attachment.Release()
+		result = append(result, a)
+	}
+	return result
+}
+
+// Проверяет, отправлено ли уже уведомление о письме
+func isProcessed(id string) bool {
+	mutexMsg.Lock()
+	defer mutexMsg.Unlock()
+	return processedEmails[id]
+}
+
+// Общий конвейер отправки уведомления о письме из любого источника.
+// Уведомления ставятся в очередь outboxQueue; возвращает false, если поставить
+// их не удалось и письмо нужно обработать повторно
+func processMessage(mail mailsource.Message) bool {
+	entryID, folderName := mail.ID, mail.Folder
 
 	mutexMsg.Lock()
 	defer mutexMsg.Unlock()
 	if processedEmails[entryID] {
-		// logMessage("Сообщение уже отправлено в Telegram: %s", subjectID)
-		return
+		return true
 	}
 	processedEmails[entryID] = true
 
-	sender := ""
-	senderName := oleutil.MustGetProperty(item, "SenderName").ToString()
-	senderEmail := oleutil.MustGetProperty(item, "SenderEmailAddress").ToString()
+	folderConfig, _ := findFolderConfig(folderName)
 
-	if senderName != "" && senderName != senderEmail {
-		sender = (senderName + " <" + senderEmail + ">")
-	} else {
-		sender = (senderEmail)
+	// Уведомления из папки отключены командой /mute
+	if state.isMuted(folderName) || state.isMuted(folderConfig.label()) {
+		return true
 	}
 
-	subject := oleutil.MustGetProperty(item, "Subject").ToString()
-	body := oleutil.MustGetProperty(item, "Body").ToString()
-
-	var folderConfig Folder
-	for _, f := range config.Folders {
-		if f.Name == folderName {
-			folderConfig = f
-			break
+	sender := mail.Sender()
+	subject, body, isHTML := mail.Subject, mail.Body, mail.IsHTML
+
+	// Скрываем чувствительные данные до формирования уведомления
+	subject, redactedSubject := redactor.Redact(subject)
+	body, redactedBody := redactor.Redact(body)
+	if n := redactedSubject + redactedBody; n > 0 {
+		logMessage("Скрыто фрагментов с чувствительными данными: %d (папка %s)", n, folderName)
+	}
+	rawBody := body
+
+	// Письма систем мониторинга отправляем компактным оповещением,
+	// если разобрать письмо не удалось - обычным уведомлением
+	var (
+		message   string
+		alert     *alerts.Alert
+		parserCfg *AlertParserConfig
+	)
+	if cfg, parser := findAlertParser(folderName, mail.SenderEmail); parser != nil {
+		alertBody := body
+		if isHTML {
+			alertBody = htmlconv.StripTags(htmlconv.Convert(body))
+		}
+		if parsed, err := parser.Parse(subject, alertBody); err == nil {
+			alert, parserCfg = parsed, cfg
+			message = formatAlertMessage(folderName, alert)
+		} else {
+			logMessage("Не удалось разобрать оповещение %s: %v", parser.Name(), err)
 		}
 	}
 
-	message := formatMessage(folderName, sender, subject, body, folderConfig.MessageLength)
+	if message == "" {
+		if folderConfig.MessageLength != 0 {
+			body = prepareBody(body, isHTML, folderConfig)
+		}
+		message = formatMessage(folderName, sender, subject, body, mail.Attachments, folderConfig.MessageLength)
+	}
 	chatID := folderConfig.ChatID
 	if chatID == "" {
 		chatID = config.Telegram.DefaultChatID
 	}
 
-	if err := sendTelegramMessage(message, chatID); err != nil {
+	msg := telegramMessage{
+		ChatID:   chatID,
+		Text:     message,
+		Keyboard: actionKeyboard(folderConfig.Buttons),
+	}
+
+	// Ставим уведомления по всем маршрутам папки в очередь. Отправляет их
+	// отдельная горутина, поэтому опрос почты не ждет Telegram и другие каналы
+	routes := folderConfig.routes()
+	items := make([]queuedNotification, 0, len(routes))
+	rec := &HistoryRecord{Folder: folderName, Sender: sender, Subject: subject, EntryID: entryID}
+	var notification *notify.Notification
+	for i, route := range routes {
+		n := queuedNotification{Route: route, Source: folderConfig.source, Folder: folderName, Subject: subject, MailID: entryID}
+		if route == telegramRoute || (i == 0 && !slices.Contains(routes, telegramRoute)) {
+			// Запись в историю - одна на письмо. Действие after_send выполняется
+			// после доставки этого уведомления: в Telegram, если он среди маршрутов
+			n.History = rec
+			if folderConfig.afterSend.Kind != "" {
+				action := folderConfig.afterSend
+				n.AfterSend = &action
+			}
+		}
+
+		if route == telegramRoute {
+			m := msg
+			n.Message = &m
+			if alert != nil {
+				setAlertCorrelation(&n, parserCfg, alert, subject)
+			} else {
+				n.ConversationID = mail.ConversationID
+			}
+			if len(msg.Keyboard) > 0 || folderConfig.AllowReply {
+				n.Remember = &NotifiedMail{EntryID: entryID, Folder: folderName}
+			}
+			rec.ChatID = chatID
+		} else {
+			if notification == nil {
+				cn := channelNotification(mail, subject, rawBody, folderConfig)
+				notification = &cn
+			}
+			n.Notification = notification
+		}
+		items = append(items, n)
+	}
+
+	if err := enqueueNotifications(items); err != nil {
 		processedEmails[entryID] = false
-		logMessage("Ошибка отправки в Telegram: %v", err)
-	} else {
-		logMessage("Сообщение успешно отправлено в Telegram: %s", subject)
+		stats.sendFailed(folderConfig.source, folderName, err)
+		logMessage("Ошибка постановки уведомления в очередь: %v", err)
+		return false
 	}
+	logDebug("Уведомление поставлено в очередь: %s", subject)
+	return true
 }
 
-func formatMessage(folder, sender, subject, body string, maxLength int) string {
+// Возвращает настройки папки по имени. Для подпапок папки с recursive: true
+// возвращаются настройки этой папки
+func findFolderConfig(folderName string) (Folder, bool) {
+	for _, f := range config.Folders {
+		if f.label() == folderName {
+			return f, true
+		}
+	}
+	for _, f := range config.Folders {
+		if f.Recursive && strings.HasPrefix(folderName, f.Name+"/") {
+			return f, true
+		}
+	}
+	return Folder{}, false
+}
+
+// Фильтр подпапок для recursive: true
+func (f Folder) subfolderFilter() folderref.Filter {
+	return folderref.Filter{Include: f.Include, Exclude: f.Exclude}
+}
+
+// Возвращает идентификатор переписки Outlook (ConversationID, а для старых версий - ConversationTopic)
+func getConversationID(item *ole.IDispatch) string {
+	if !config.Telegram.ThreadConversations {
+		return ""
+	}
+	for _, property := range []string{"ConversationID", "ConversationTopic"} {
+		if v, err := oleutil.GetProperty(item, property); err == nil {
+			if id := strings.TrimSpace(v.ToString()); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// Отправляет уведомление ответом на первое уведомление той же переписки в этом чате
+func sendThreaded(msg telegramMessage, conversationID string) (int, error) {
+	if conversationID == "" {
+		return sendTelegram(msg)
+	}
+
+	key := msg.ChatID + "|" + conversationID
+	if first, ok := state.lookupThread(key); ok {
+		msg.ReplyTo = first.MessageID
+		return sendTelegram(msg)
+	}
+
+	messageID, err := sendTelegram(msg)
+	if err == nil {
+		state.rememberThread(key, SentMessage{
+			ChatID:    msg.ChatID,
+			MessageID: messageID,
+			Time:      time.Now(),
+		})
+	}
+	return messageID, err
+}
+
+// Готовит тело письма к отправке: очищает его и переводит в разметку Telegram
+func prepareBody(body string, isHTML bool, folderConfig Folder) string {
+	if isHTML {
+		// Преобразуем HTML письма в разметку, которую понимает Telegram
+		body = htmlconv.Convert(body)
+	}
+
+	// Удаляем цитаты, подписи и дисклеймеры
+	body = folderConfig.cleaner.Clean(body)
+
+	// Обрезаем тело письма до строки CutText (заголовки уведомления не затрагиваются)
+	if len(config.CutText) != 0 {
+		re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(config.CutText))
+		if index := re.FindStringIndex(body); index != nil {
+			body = body[:index[0]]
+		}
+	}
+
+	if isHTML {
+		// После обрезки закрываем оставшиеся открытыми теги
+		return htmlconv.Truncate(body, len(body))
+	}
+	return html.EscapeString(body)
+}
+
+// Формирует текст уведомления. Тело письма должно быть уже подготовлено prepareBody
+func formatMessage(folder, sender, subject, body string, attachments []mailsource.Attachment, maxLength int) string {
 	var msg strings.Builder
 
 	// Экранируем специальные символы для HTML
@@ -1217,34 +1738,25 @@ func formatMessage(folder, sender, subject, body string, maxLength int) string {
 		msg.WriteString("<b>Отправитель:</b> " + sender + "\n")
 		msg.WriteString("<b>Тема:</b> " + subject + "\n")
 	}
+	if len(attachments) > 0 {
+		if config.Telegram.UseEmojis {
+			msg.WriteString("📎 ")
+		}
+		msg.WriteString("<b>Вложения:</b> " + html.EscapeString(notify.FormatAttachments(attachments)) + "\n")
+	}
 
 	// Добавляем тело сообщения только если maxLength ≠ 0
 	if maxLength != 0 {
-		body = html.EscapeString(body)
-		// Обрезаем тело если указана максимальная длина
+		// Обрезаем тело если указана максимальная длина, не разрывая теги
 		if maxLength > 0 {
-			body = truncateByRunes(body, maxLength)
+			body = htmlconv.Truncate(body, maxLength)
 		}
 		msg.WriteString("<i>Сообщение:</i>\n" + body)
 	}
 
-	// Проверяем, нужно ли обрезать текст до строки СutText
-	cutString := config.CutText
-	if len(cutString) != 0 {
-		re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(cutString))
-		fullMessage := msg.String()
-		index := re.FindStringIndex(fullMessage)
-		if index != nil {
-			// Обрезаем текст до найденного индекса
-			fullMessage = fullMessage[:index[0]]
-			msg.Reset()                  // Очищаем текущий Builder
-			msg.WriteString(fullMessage) // Записываем обрезанный текст
-		}
-	}
-
 	// Проверяем общую длину сообщения
 	finalMessage := msg.String()
-	return truncateByRunes(finalMessage, 4000)
+	return htmlconv.Truncate(finalMessage, 4000)
 }
 
 func truncateByRunes(text string, maxRunes int) string {
@@ -1255,21 +1767,81 @@ func truncateByRunes(text string, maxRunes int) string {
 	return string(runes[:maxRunes]) + "..."
 }
 
-func sendTelegramMessage(text, chatID string) error {
+// Исходящее сообщение Telegram
+type telegramMessage struct {
+	ChatID   string           `json:"chat_id"`
+	Text     string           `json:"text"`
+	ReplyTo  int              `json:"reply_to,omitempty"` // message_id сообщения, на которое отвечаем
+	Keyboard [][]inlineButton `json:"keyboard,omitempty"` // Кнопки под сообщением
+	Reserved bool             `json:"-"`                  // Отправку уже зарезервировала очередь (см. postChatJSON)
+}
+
+// Кнопка под сообщением
+type inlineButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+// Отправляет сообщение и возвращает его message_id
+func sendTelegramMessage(text, chatID string) (int, error) {
+	return sendTelegram(telegramMessage{ChatID: chatID, Text: text})
+}
+
+// Отправляет сообщение ответом на другое сообщение
+func sendTelegramReply(text, chatID string, replyTo int) (int, error) {
+	return sendTelegram(telegramMessage{ChatID: chatID, Text: text, ReplyTo: replyTo})
+}
+
+func sendTelegram(msg telegramMessage) (int, error) {
+	params := map[string]interface{}{
+		"chat_id":    msg.ChatID,
+		"text":       msg.Text,
+		"parse_mode": "HTML",
+	}
+	if msg.ReplyTo != 0 {
+		params["reply_to_message_id"] = msg.ReplyTo
+		params["allow_sending_without_reply"] = true
+	}
+	if len(msg.Keyboard) > 0 {
+		params["reply_markup"] = map[string]interface{}{"inline_keyboard": msg.Keyboard}
+	}
+
 	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", config.Telegram.BotToken)
-	_, err := postJSON(url, map[string]interface{}{
+	body, err := postChatJSON(msg.ChatID, url, params, msg.Reserved)
+	if err != nil {
+		return 0, err
+	}
+
+	var response struct {
+		Result struct {
+			MessageID int `json:"message_id"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return 0, fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+
+	logMessage("Уведомление отправлено в чат %s", msg.ChatID)
+	return response.Result.MessageID, nil
+}
+
+// Изменяет текст ранее отправленного сообщения
+func editTelegramMessage(chatID string, messageID int, text string, reserved bool) error {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/editMessageText", config.Telegram.BotToken)
+	_, err := postChatJSON(chatID, url, map[string]interface{}{
 		"chat_id":    chatID,
+		"message_id": messageID,
 		"text":       text,
 		"parse_mode": "HTML",
-	})
-
-	if err == nil {
-		logMessage("Уведомление отправлено в чат %s", chatID)
-	}
+	}, reserved)
 	return err
 }
 
 func postJSON(url string, data interface{}) ([]byte, error) {
+	return postJSONWith(httpClient, url, data)
+}
+
+func postJSONWith(client *http.Client, url string, data interface{}) ([]byte, error) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка маршалинга JSON: %v", err)
@@ -1281,7 +1853,7 @@ func postJSON(url string, data interface{}) ([]byte, error) {
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := httpClient.Do(req) // ← Используем клиент с прокси
+	resp, err := client.Do(req) // ← Используем клиент с прокси
 	if err != nil {
 		return nil, fmt.Errorf("ошибка выполнения запроса: %v", err)
 	}
@@ -1293,18 +1865,41 @@ func postJSON(url string, data interface{}) ([]byte, error) {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("неверный статус код: %d, тело ответа: %s", resp.StatusCode, string(body))
+		return nil, &telegramStatusError{Status: resp.StatusCode, Body: string(body)}
 	}
 
 	return body, nil
 }
 
-func waitNextCheck() {
+// Ответ Bot API с кодом, отличным от 200
+type telegramStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *telegramStatusError) Error() string {
+	return fmt.Sprintf("неверный статус код: %d, тело ответа: %s", e.Status, e.Body)
+}
+
+func waitNextCheck(ctx context.Context) {
 	interval := config.CheckIntervalSeconds
 	if interval <= 0 {
 		interval = 10
 	}
-	time.Sleep(time.Duration(interval) * time.Second)
+
+	// В режиме событий опрос нужен только для сверки, ее можно запросить досрочно
+	if config.EventMode {
+		interval = config.ReconcileIntervalSeconds
+		if interval <= 0 {
+			interval = defaultReconcileSec
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Duration(interval) * time.Second):
+	case <-reconcileNow:
+	}
 }
 
 // Создаёт http.Client с поддержкой HTTP/HTTPS/SOCKS5 прокси
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Обработчик /metrics: статистика работы в текстовом формате Prometheus
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	snap := stats.snapshot()
+
+	var b strings.Builder
+	writeMetricHeader(&b, "otn_forwarded_total", "counter", "Отправлено уведомлений")
+	writeFolderCounts(&b, "otn_forwarded_total", snap.ForwardedByFolder)
+
+	writeMetricHeader(&b, "otn_send_failures_total", "counter", "Ошибок отправки уведомлений")
+	writeFolderCounts(&b, "otn_send_failures_total", snap.SendFailuresByFolder)
+
+	writeMetricHeader(&b, "otn_poll_failures_total", "counter", "Ошибок работы с Outlook")
+	fmt.Fprintf(&b, "otn_poll_failures_total %d\n", snap.PollFailures)
+
+	writeMetricHeader(&b, "otn_last_poll_timestamp_seconds", "gauge", "Время последней успешной проверки почты")
+	lastPoll := int64(0)
+	if !snap.LastPoll.IsZero() {
+		lastPoll = snap.LastPoll.Unix()
+	}
+	fmt.Fprintf(&b, "otn_last_poll_timestamp_seconds %d\n", lastPoll)
+
+	writeMetricHeader(&b, "otn_start_timestamp_seconds", "gauge", "Время запуска программы")
+	fmt.Fprintf(&b, "otn_start_timestamp_seconds %d\n", snap.StartTime.Unix())
+
+	writeMetricHeader(&b, "otn_queue_depth", "gauge", "Уведомлений в очереди на отправку")
+	fmt.Fprintf(&b, "otn_queue_depth %d\n", queueDepth())
+
+	writeRateLimitMetrics(&b)
+
+	writeSourceMetrics(&b, snap.Sources)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// Выводит текущее ожидание лимита Telegram по чатам
+func writeRateLimitMetrics(b *strings.Builder) {
+	writeMetricHeader(b, "otn_telegram_rate_wait_seconds", "gauge", "Ожидание лимита частоты сообщений Telegram по чатам")
+	if telegramLimiter == nil {
+		return
+	}
+	waits := telegramLimiter.Waits(time.Now())
+	chats := make([]string, 0, len(waits))
+	for chat := range waits {
+		chats = append(chats, chat)
+	}
+	sort.Strings(chats)
+	for _, chat := range chats {
+		fmt.Fprintf(b, "otn_telegram_rate_wait_seconds{chat_id=\"%s\"} %.3f\n", escapeLabel(chat), waits[chat].Seconds())
+	}
+}
+
+// Выводит метрики источников писем в порядке имен
+func writeSourceMetrics(b *strings.Builder, sources map[string]sourceStats) {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := []struct {
+		name, kind, help string
+		value            func(src sourceStats) int
+	}{
+		{"otn_source_up", "gauge", "Источник писем работает (1) или нет (0)", func(src sourceStats) int {
+			if src.Status == sourceRunning {
+				return 1
+			}
+			return 0
+		}},
+		{"otn_source_forwarded_total", "counter", "Отправлено уведомлений по источникам", func(src sourceStats) int { return src.Forwarded }},
+		{"otn_source_send_failures_total", "counter", "Ошибок отправки уведомлений по источникам", func(src sourceStats) int { return src.SendFailures }},
+		{"otn_source_errors_total", "counter", "Ошибок получения писем по источникам", func(src sourceStats) int { return src.Errors }},
+		{"otn_source_restarts_total", "counter", "Перезапусков источника после сбоя", func(src sourceStats) int { return src.Restarts }},
+	}
+	for _, m := range metrics {
+		writeMetricHeader(b, m.name, m.kind, m.help)
+		for _, name := range names {
+			src := sources[name]
+			fmt.Fprintf(b, "%s{source=\"%s\",type=\"%s\"} %d\n", m.name, escapeLabel(name), src.Kind, m.value(src))
+		}
+	}
+}
+
+// Состояние источника писем для /status
+type sourceStatusJSON struct {
+	Name          string     `json:"name"`
+	Type          string     `json:"type"`
+	Status        string     `json:"status"`
+	Since         time.Time  `json:"since"`
+	Restarts      int        `json:"restarts"`
+	Forwarded     int        `json:"forwarded"`
+	SendFailures  int        `json:"send_failures"`
+	Errors        int        `json:"errors"`
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+}
+
+// Обработчик /status: состояние программы и источников писем в JSON
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	snap := stats.snapshot()
+
+	response := struct {
+		Service      string             `json:"service"`
+		Status       string             `json:"status"`
+		StartTime    time.Time          `json:"start_time"`
+		Forwarded    int                `json:"forwarded"`
+		SendFailures int                `json:"send_failures"`
+		QueueDepth   int                `json:"queue_depth"`
+		Sources      []sourceStatusJSON `json:"sources"`
+	}{
+		Service:      "OTN",
+		Status:       "UP",
+		StartTime:    snap.StartTime,
+		Forwarded:    snap.Forwarded,
+		SendFailures: snap.SendFailures,
+		QueueDepth:   queueDepth(),
+		Sources:      []sourceStatusJSON{},
+	}
+	// Источники в порядке настроек
+	for _, src := range configuredSources {
+		s, ok := snap.Sources[src.name]
+		if !ok {
+			continue
+		}
+		status := sourceStatusJSON{
+			Name:         src.name,
+			Type:         s.Kind,
+			Status:       s.Status,
+			Since:        s.Since,
+			Restarts:     s.Restarts,
+			Forwarded:    s.Forwarded,
+			SendFailures: s.SendFailures,
+			Errors:       s.Errors,
+			LastError:    s.LastError,
+		}
+		if !s.LastErrorTime.IsZero() {
+			status.LastErrorTime = &s.LastErrorTime
+		}
+		response.Sources = append(response.Sources, status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Выводит описание и тип метрики
+func writeMetricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Выводит счетчики по папкам в порядке имен
+func writeFolderCounts(b *strings.Builder, name string, counts map[string]int) {
+	folders := make([]string, 0, len(counts))
+	for folder := range counts {
+		folders = append(folders, folder)
+	}
+	sort.Strings(folders)
+
+	for _, folder := range folders {
+		fmt.Fprintf(b, "%s{folder=\"%s\"} %d\n", name, escapeLabel(folder), counts[folder])
+	}
+}
+
+// Экранирует значение метки: обратная косая черта, кавычки и переводы строк
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,161 @@
+package notify
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"otn/mailsource"
+)
+
+// Максимальная длина текста уведомления в символах. У Telegram ограничение
+// 4096, у Slack и Mattermost - больше, но длинные сообщения неудобно читать
+const maxTextLength = 4000
+
+// Разметка, в которой канал ожидает текст
+type markup struct {
+	bold   func(s string) string // Полужирный текст (s уже экранирован)
+	escape func(s string) string
+	quote  func(s string) string // Тело письма (s уже экранирован)
+}
+
+// FormatTelegram формирует уведомление в HTML-разметке Telegram
+func FormatTelegram(n Notification) string {
+	return format(n, markup{
+		bold:   func(s string) string { return "<b>" + s + "</b>" },
+		escape: html.EscapeString,
+		quote:  func(s string) string { return "<i>Сообщение:</i>\n" + s },
+	})
+}
+
+// FormatSlack формирует уведомление в разметке mrkdwn Slack
+func FormatSlack(n Notification) string {
+	return format(n, markup{
+		bold: func(s string) string { return "*" + s + "*" },
+		// Slack требует экранировать только &, < и >
+		escape: strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace,
+		quote:  quoteLines,
+	})
+}
+
+// FormatMattermost формирует уведомление в Markdown Mattermost
+func FormatMattermost(n Notification) string {
+	return format(n, markup{
+		bold:   func(s string) string { return "**" + s + "**" },
+		escape: escapeMarkdown,
+		quote:  quoteLines,
+	})
+}
+
+// FormatMatrix формирует уведомление в HTML для formatted_body Matrix. В отличие
+// от Telegram, переводы строк в HTML Matrix нужно задавать тегом <br>
+func FormatMatrix(n Notification) string {
+	text := format(n, markup{
+		bold:   func(s string) string { return "<b>" + s + "</b>" },
+		escape: html.EscapeString,
+		quote:  func(s string) string { return "<blockquote>" + s + "</blockquote>" },
+	})
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+// FormatPlain формирует уведомление простым текстом: для push-уведомлений и
+// текстового body сообщения Matrix
+func FormatPlain(n Notification) string {
+	return format(n, markup{
+		bold:   func(s string) string { return s },
+		escape: func(s string) string { return s },
+		quote:  func(s string) string { return s },
+	})
+}
+
+func format(n Notification, m markup) string {
+	var b strings.Builder
+
+	subject := m.escape(n.Subject)
+	if n.Importance == mailsource.ImportanceHigh {
+		subject = "❗ " + subject
+	}
+	fmt.Fprintf(&b, "%s %s\n", m.bold("Папка:"), m.escape(n.Folder))
+	fmt.Fprintf(&b, "%s %s\n", m.bold("Отправитель:"), m.escape(n.Sender()))
+	fmt.Fprintf(&b, "%s %s\n", m.bold("Тема:"), subject)
+	if len(n.Attachments) > 0 {
+		fmt.Fprintf(&b, "%s %s\n", m.bold("Вложения:"), m.escape(FormatAttachments(n.Attachments)))
+	}
+
+	text := b.String()
+	if body := strings.TrimSpace(n.Body); body != "" {
+		// Обрезаем тело так, чтобы уведомление поместилось целиком. Экранирование
+		// и цитирование удлиняют текст, поэтому длина проверяется после них
+		for room := maxTextLength - runeCount(text); room > 0; {
+			quoted := "\n" + m.quote(m.escape(truncate(body, room)))
+			excess := runeCount(text) + runeCount(quoted) - maxTextLength
+			if excess <= 0 {
+				text += quoted
+				break
+			}
+			room -= excess
+		}
+	}
+	return text
+}
+
+func runeCount(s string) int {
+	return len([]rune(s))
+}
+
+// Каждая строка тела - цитата ("> ")
+func quoteLines(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = "> " + line
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Экранирует символы Markdown обратной косой чертой
+func escapeMarkdown(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("\\`*_~[]()#<>|", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// FormatAttachments возвращает список вложений с размерами
+func FormatAttachments(attachments []mailsource.Attachment) string {
+	names := make([]string, 0, len(attachments))
+	for _, a := range attachments {
+		name := a.Name
+		if name == "" {
+			name = "без имени"
+		}
+		names = append(names, fmt.Sprintf("%s (%s)", name, FormatSize(a.Size)))
+	}
+	return strings.Join(names, ", ")
+}
+
+// FormatSize возвращает размер в байтах, килобайтах или мегабайтах
+func FormatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d Б", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f КБ", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%.1f МБ", float64(size)/(1024*1024))
+	}
+}
+
+// ImportanceName возвращает важность словом: low, normal или high
+func ImportanceName(importance mailsource.Importance) string {
+	switch importance {
+	case mailsource.ImportanceHigh:
+		return "high"
+	case mailsource.ImportanceLow:
+		return "low"
+	}
+	return "normal"
+}
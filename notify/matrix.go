@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"otn/mailsource"
+)
+
+// Matrix - канал в комнату Matrix через Client-Server API
+type Matrix struct {
+	cfg    Config
+	client *http.Client
+	Retry
+
+	// FormatHTML и FormatText формируют formatted_body и body сообщения;
+	// по умолчанию FormatMatrix и FormatPlain
+	FormatHTML func(n Notification) string
+	FormatText func(n Notification) string
+}
+
+// NewMatrix создает канал Matrix
+func NewMatrix(cfg Config, client *http.Client) *Matrix {
+	return &Matrix{cfg: cfg, client: client, Retry: newRetry(cfg), FormatHTML: FormatMatrix, FormatText: FormatPlain}
+}
+
+// Name возвращает имя канала
+func (m *Matrix) Name() string {
+	return m.cfg.Name
+}
+
+// Notify отправляет в комнату событие m.room.message. Письма низкой важности
+// отправляются как m.notice: клиенты Matrix обычно не оповещают о них звуком
+func (m *Matrix) Notify(ctx context.Context, n Notification) error {
+	msgtype := "m.text"
+	if n.Importance == mailsource.ImportanceLow {
+		msgtype = "m.notice"
+	}
+	event := map[string]interface{}{
+		"msgtype":        msgtype,
+		"body":           m.FormatText(n),
+		"format":         "org.matrix.custom.html",
+		"formatted_body": m.FormatHTML(n),
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("ошибка маршалинга JSON: %v", err)
+	}
+
+	// Идентификатор транзакции одинаков для всех попыток: если сервер принял
+	// событие, но ответ потерялся, повтор не создаст второе сообщение
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.cfg.URL, "/"), url.PathEscape(m.cfg.RoomID), transactionID(n))
+	header := http.Header{"Authorization": {"Bearer " + m.cfg.Token}}
+
+	err = m.do(ctx, func() error {
+		_, err := send(ctx, m.client, http.MethodPut, endpoint, header, data)
+		return matrixRetryAfter(err)
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки в Matrix: %w", err)
+	}
+	return nil
+}
+
+// Идентификатор транзакции Matrix для уведомления
+func transactionID(n Notification) string {
+	sum := sha256.Sum256([]byte(n.ID + "\x00" + n.Received.Format(time.RFC3339Nano) + "\x00" + n.Subject))
+	return "otn-" + hex.EncodeToString(sum[:16])
+}
+
+// Matrix сообщает паузу перед повтором в теле ответа 429 (retry_after_ms),
+// а не в заголовке Retry-After
+func matrixRetryAfter(err error) error {
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) || httpErr.Status != http.StatusTooManyRequests || httpErr.RetryAfter > 0 {
+		return err
+	}
+	var body struct {
+		RetryAfterMS int64 `json:"retry_after_ms"`
+	}
+	if json.Unmarshal([]byte(httpErr.Body), &body) == nil && body.RetryAfterMS > 0 {
+		httpErr.RetryAfter = time.Duration(body.RetryAfterMS) * time.Millisecond
+	}
+	return err
+}
@@ -0,0 +1,282 @@
+// Пакет notify доставляет уведомления о письмах в каналы: Telegram, входящие
+// вебхуки Slack и Mattermost, произвольный JSON-вебхук, комнаты Matrix и
+// push-уведомления ntfy и Gotify. Каждый канал сам форматирует уведомление,
+// потому что разметка Telegram (HTML), Slack (mrkdwn) и Mattermost (Markdown)
+// различается.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"otn/mailsource"
+)
+
+// Типы каналов
+const (
+	TypeTelegram   = "telegram"
+	TypeSlack      = "slack"
+	TypeMattermost = "mattermost"
+	TypeWebhook    = "webhook"
+	TypeMatrix     = "matrix"
+	TypeNtfy       = "ntfy"
+	TypeGotify     = "gotify"
+)
+
+// Notification - уведомление о письме, независимо от канала
+type Notification struct {
+	ID          string // Идентификатор письма в источнике
+	Folder      string
+	SenderName  string
+	SenderEmail string
+	Subject     string
+	Body        string // Текст письма без разметки; пустой, если тело не отправляется
+	Importance  mailsource.Importance
+	Received    time.Time
+	Attachments []mailsource.Attachment
+}
+
+// Sender возвращает отправителя в виде "Имя <адрес>" или только адрес.
+func (n Notification) Sender() string {
+	if n.SenderName != "" && n.SenderName != n.SenderEmail {
+		if n.SenderEmail == "" {
+			return n.SenderName
+		}
+		return n.SenderName + " <" + n.SenderEmail + ">"
+	}
+	return n.SenderEmail
+}
+
+// Notifier - канал доставки уведомлений
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Config - канал доставки из раздела channels
+type Config struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	URL      string            `json:"url"`       // Адрес вебхука или сервера (matrix, ntfy, gotify)
+	ChatID   string            `json:"chat_id"`   // telegram: чат для уведомлений
+	BotToken string            `json:"bot_token"` // telegram: по умолчанию токен из telegram.bot_token
+	Channel  string            `json:"channel"`   // slack, mattermost: канал вместо канала вебхука
+	Username string            `json:"username"`  // slack, mattermost: имя отправителя; ntfy: пользователь
+	IconURL  string            `json:"icon_url"`  // slack, mattermost: значок отправителя
+	Headers  map[string]string `json:"headers"`   // webhook: дополнительные заголовки запроса
+
+	Secret   string `json:"secret"`   // webhook: ключ подписи X-OTN-Signature (HMAC-SHA256 тела запроса)
+	Template string `json:"template"` // webhook: шаблон тела запроса (text/template), по умолчанию стандартный JSON
+	Retries  *int   `json:"retries"`  // Повторы после временных ошибок, по умолчанию DefaultRetries
+
+	RoomID     string         `json:"room_id"`    // matrix: комната, например !abc:example.com
+	Topic      string         `json:"topic"`      // ntfy: тема
+	Token      string         `json:"token"`      // matrix: токен доступа; ntfy: токен доступа; gotify: токен приложения
+	Password   string         `json:"password"`   // ntfy: пароль пользователя username
+	Priorities map[string]int `json:"priorities"` // ntfy, gotify: приоритет для важности low, normal, high
+
+	TimeoutSeconds int `json:"timeout_seconds"` // Время ожидания ответа; 0 - как у общего HTTP-клиента
+}
+
+// Validate проверяет настройки канала
+func (c Config) Validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("не указано имя канала")
+	}
+	switch c.Type {
+	case TypeTelegram:
+		if c.ChatID == "" {
+			return fmt.Errorf("не указан chat_id")
+		}
+		if c.URL != "" {
+			return fmt.Errorf("url не используется для канала telegram")
+		}
+	case TypeSlack, TypeMattermost, TypeWebhook, TypeMatrix, TypeNtfy, TypeGotify:
+		u, err := url.Parse(c.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url должен быть адресом http:// или https://")
+		}
+	default:
+		return fmt.Errorf("неизвестный тип канала %q (допустимы: %s)", c.Type,
+			strings.Join([]string{TypeTelegram, TypeSlack, TypeMattermost, TypeWebhook, TypeMatrix, TypeNtfy, TypeGotify}, ", "))
+	}
+
+	switch c.Type {
+	case TypeMatrix:
+		if !strings.HasPrefix(c.RoomID, "!") {
+			return fmt.Errorf("room_id должен быть идентификатором комнаты вида !abc:example.com")
+		}
+		if c.Token == "" {
+			return fmt.Errorf("не указан token")
+		}
+	case TypeNtfy:
+		if c.Topic == "" || strings.ContainsAny(c.Topic, "/?#") {
+			return fmt.Errorf("topic должен быть именем темы без символов /, ? и #")
+		}
+		if c.Password != "" && c.Username == "" {
+			return fmt.Errorf("для password нужен username")
+		}
+		if c.Token != "" && c.Username != "" {
+			return fmt.Errorf("укажите token или username и password")
+		}
+	case TypeGotify:
+		if c.Token == "" {
+			return fmt.Errorf("не указан token")
+		}
+	}
+
+	switch {
+	case len(c.Headers) > 0 && c.Type != TypeWebhook:
+		return fmt.Errorf("headers используются только для канала webhook")
+	case c.Secret != "" && c.Type != TypeWebhook:
+		return fmt.Errorf("secret используется только для канала webhook")
+	case c.Template != "" && c.Type != TypeWebhook:
+		return fmt.Errorf("template используется только для канала webhook")
+	case c.RoomID != "" && c.Type != TypeMatrix:
+		return fmt.Errorf("room_id используется только для канала matrix")
+	case c.Topic != "" && c.Type != TypeNtfy:
+		return fmt.Errorf("topic используется только для канала ntfy")
+	case c.Token != "" && c.Type != TypeMatrix && c.Type != TypeNtfy && c.Type != TypeGotify:
+		return fmt.Errorf("token используется только для каналов matrix, ntfy и gotify")
+	case c.Password != "" && c.Type != TypeNtfy:
+		return fmt.Errorf("password используется только для канала ntfy")
+	}
+	if err := validatePriorities(c); err != nil {
+		return err
+	}
+	if c.Retries != nil && (*c.Retries < 0 || *c.Retries > 10) {
+		return fmt.Errorf("retries должно быть в диапазоне от 0 до 10")
+	}
+	if c.TimeoutSeconds < 0 || c.TimeoutSeconds > 300 {
+		return fmt.Errorf("timeout_seconds должно быть в диапазоне от 0 до 300")
+	}
+	if c.Template != "" {
+		if _, err := parseTemplate(c.Template); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// New создает канал по настройкам. Запросы выполняются через client
+func New(cfg Config, client *http.Client) (Notifier, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if cfg.TimeoutSeconds > 0 {
+		client = withTimeout(client, time.Duration(cfg.TimeoutSeconds)*time.Second)
+	}
+	switch cfg.Type {
+	case TypeTelegram:
+		return NewTelegram(cfg, client), nil
+	case TypeSlack, TypeMattermost:
+		return NewIncomingWebhook(cfg, client), nil
+	case TypeMatrix:
+		return NewMatrix(cfg, client), nil
+	case TypeNtfy:
+		return NewNtfy(cfg, client), nil
+	case TypeGotify:
+		return NewGotify(cfg, client), nil
+	default:
+		return NewWebhook(cfg, client)
+	}
+}
+
+// Копия клиента со своим временем ожидания. Транспорт копируется вместе с
+// настройками прокси, меняется только время ожидания ответа
+func withTimeout(client *http.Client, timeout time.Duration) *http.Client {
+	c := *client
+	c.Timeout = timeout
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport = transport.Clone()
+		transport.ResponseHeaderTimeout = timeout
+		c.Transport = transport
+	}
+	return &c
+}
+
+// Ошибка HTTP-ответа канала
+type httpError struct {
+	Status     int
+	Body       string
+	RetryAfter time.Duration // Пауза из заголовка Retry-After
+}
+
+func (e *httpError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("HTTP %d", e.Status)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.Status, e.Body)
+}
+
+// Ошибка соединения с каналом
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("ошибка выполнения запроса: %v", e.err)
+}
+
+// Отправляет JSON методом POST и возвращает тело ответа. Ответ не 2xx - ошибка
+func postJSON(ctx context.Context, client *http.Client, endpoint string, header http.Header, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка маршалинга JSON: %v", err)
+	}
+	return send(ctx, client, http.MethodPost, endpoint, header, data)
+}
+
+// Отправляет готовое тело JSON и возвращает тело ответа
+func send(ctx context.Context, client *http.Client, method, endpoint string, header http.Header, data []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка создания запроса: %v", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		// Адрес вебхука и токен бота - секреты, поэтому в ошибку попадает только причина
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, &requestError{err: err}
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &httpError{
+			Status:     resp.StatusCode,
+			Body:       truncate(strings.TrimSpace(string(body)), 300),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return body, nil
+}
+
+// Обрезает текст до maxRunes символов, добавляя многоточие
+func truncate(s string, maxRunes int) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+	return string(runes[:maxRunes]) + "..."
+}
@@ -0,0 +1,267 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"otn/mailsource"
+)
+
+func testNotification() Notification {
+	return Notification{
+		ID:          "graph:ops:AAMk",
+		Folder:      "Zabbix",
+		SenderName:  "Zabbix",
+		SenderEmail: "zabbix@example.com",
+		Subject:     "Проблема: db1 <недоступен>",
+		Body:        "Сервер *db1* недоступен\nс 12:30",
+		Importance:  mailsource.ImportanceHigh,
+		Received:    time.Date(2024, 5, 14, 9, 30, 0, 0, time.UTC),
+		Attachments: []mailsource.Attachment{{Name: "graph.png", ContentType: "image/png", Size: 2048}},
+	}
+}
+
+// Запрос, полученный тестовым сервером
+type request struct {
+	path   string
+	header http.Header
+	body   map[string]interface{}
+}
+
+// Тестовый сервер, который запоминает запросы и отвечает status
+func newServer(t *testing.T, status int, response string) (*httptest.Server, *[]request) {
+	t.Helper()
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("тело запроса не JSON: %v", err)
+		}
+		requests = append(requests, request{path: r.URL.Path, header: r.Header, body: body})
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func TestTelegram(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, `{"ok":true,"result":{"message_id":7}}`)
+
+	n, err := New(Config{Name: "oncall", Type: TypeTelegram, ChatID: "-100123", BotToken: "123:abc"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.(*Telegram).apiURL = srv.URL
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("запросов: %d", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.path != "/bot123:abc/sendMessage" {
+		t.Errorf("path = %s", req.path)
+	}
+	if req.body["chat_id"] != "-100123" || req.body["parse_mode"] != "HTML" {
+		t.Errorf("body = %v", req.body)
+	}
+	text := req.body["text"].(string)
+	for _, want := range []string{
+		"<b>Тема:</b> ❗ Проблема: db1 &lt;недоступен&gt;",
+		"<b>Отправитель:</b> Zabbix &lt;zabbix@example.com&gt;",
+		"<b>Вложения:</b> graph.png (2.0 КБ)",
+		"Сервер *db1* недоступен",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("в тексте нет %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestSlack(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, "ok")
+
+	n, err := New(Config{Name: "slack", Type: TypeSlack, URL: srv.URL + "/services/T0/B0/x", Channel: "#ops", Username: "OTN"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.path != "/services/T0/B0/x" || req.body["channel"] != "#ops" || req.body["username"] != "OTN" {
+		t.Errorf("запрос = %s %v", req.path, req.body)
+	}
+	if _, ok := req.body["icon_url"]; ok {
+		t.Error("пустой icon_url не должен отправляться")
+	}
+	text := req.body["text"].(string)
+	for _, want := range []string{
+		"*Тема:* ❗ Проблема: db1 &lt;недоступен&gt;",
+		"*Папка:* Zabbix",
+		"> Сервер *db1* недоступен\n> с 12:30",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("в тексте нет %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestMattermost(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, "ok")
+
+	n, err := New(Config{Name: "mm", Type: TypeMattermost, URL: srv.URL + "/hooks/abc"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	text := (*requests)[0].body["text"].(string)
+	for _, want := range []string{
+		"**Тема:** ❗ Проблема: db1 \\<недоступен\\>",
+		"**Вложения:** graph.png \\(2.0 КБ\\)",
+		"> Сервер \\*db1\\* недоступен",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("в тексте нет %q:\n%s", want, text)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	srv, requests := newServer(t, http.StatusAccepted, "")
+
+	cfg := Config{Name: "hook", Type: TypeWebhook, URL: srv.URL + "/incident", Headers: map[string]string{"Authorization": "Bearer secret"}}
+	n, err := New(cfg, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.header.Get("Authorization") != "Bearer secret" || req.header.Get("Content-Type") != "application/json" {
+		t.Errorf("заголовки = %v", req.header)
+	}
+	want := map[string]interface{}{
+		"id":           "graph:ops:AAMk",
+		"folder":       "Zabbix",
+		"sender_name":  "Zabbix",
+		"sender_email": "zabbix@example.com",
+		"subject":      "Проблема: db1 <недоступен>",
+		"importance":   "high",
+		"received":     "2024-05-14T09:30:00Z",
+	}
+	for key, value := range want {
+		if req.body[key] != value {
+			t.Errorf("%s = %v, want %v", key, req.body[key], value)
+		}
+	}
+	attachments := req.body["attachments"].([]interface{})
+	if len(attachments) != 1 || attachments[0].(map[string]interface{})["name"] != "graph.png" {
+		t.Errorf("attachments = %v", attachments)
+	}
+}
+
+func TestHTTPError(t *testing.T) {
+	srv, _ := newServer(t, http.StatusBadRequest, `{"ok":false,"description":"Bad Request: chat not found"}`)
+
+	n, _ := New(Config{Name: "hook", Type: TypeWebhook, URL: srv.URL}, srv.Client())
+	err := n.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "HTTP 400") || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestRequestErrorHidesURL(t *testing.T) {
+	srv, _ := newServer(t, http.StatusOK, "")
+	srv.Close()
+
+	retries := 0
+	n, _ := New(Config{Name: "slack", Type: TypeSlack, URL: srv.URL + "/services/secret-path", Retries: &retries}, srv.Client())
+	err := n.Notify(context.Background(), testNotification())
+	if err == nil {
+		t.Fatal("ожидалась ошибка")
+	}
+	if strings.Contains(err.Error(), "secret-path") {
+		t.Errorf("адрес вебхука попал в ошибку: %v", err)
+	}
+	if Permanent(err) {
+		t.Error("сетевая ошибка не должна считаться окончательной")
+	}
+}
+
+func TestBodyTruncated(t *testing.T) {
+	n := testNotification()
+	n.Body = strings.Repeat("очень длинное письмо ", 1000)
+	for name, format := range map[string]func(Notification) string{
+		"telegram":   FormatTelegram,
+		"slack":      FormatSlack,
+		"mattermost": FormatMattermost,
+	} {
+		if got := len([]rune(format(n))); got > maxTextLength {
+			t.Errorf("%s: длина %d больше %d", name, got, maxTextLength)
+		}
+	}
+
+	n.Body = ""
+	if text := FormatSlack(n); strings.Contains(text, ">") {
+		t.Errorf("без тела не должно быть цитаты:\n%s", text)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tooManyRetries := 11
+	tests := []struct {
+		cfg     Config
+		wantErr string
+	}{
+		{Config{Name: "tg", Type: TypeTelegram, ChatID: "1"}, ""},
+		{Config{Name: "s", Type: TypeSlack, URL: "https://hooks.slack.com/services/x"}, ""},
+		{Config{Name: "w", Type: TypeWebhook, URL: "http://10.0.0.1:8080/in", Headers: map[string]string{"X-Key": "1"}}, ""},
+		{Config{Type: TypeSlack, URL: "https://x"}, "не указано имя"},
+		{Config{Name: "tg", Type: TypeTelegram}, "chat_id"},
+		{Config{Name: "tg", Type: TypeTelegram, ChatID: "1", URL: "https://x"}, "url не используется"},
+		{Config{Name: "m", Type: TypeMattermost, URL: "ftp://x"}, "http://"},
+		{Config{Name: "m", Type: TypeMattermost, URL: "https://x", Headers: map[string]string{"a": "b"}}, "headers"},
+		{Config{Name: "e", Type: "email"}, "неизвестный тип"},
+		{Config{Name: "s", Type: TypeSlack, URL: "https://x", Secret: "k"}, "secret"},
+		{Config{Name: "tg", Type: TypeTelegram, ChatID: "1", Template: "{}"}, "template"},
+		{Config{Name: "w", Type: TypeWebhook, URL: "https://x", Retries: &tooManyRetries}, "retries"},
+		{Config{Name: "w", Type: TypeWebhook, URL: "https://x", TimeoutSeconds: 301}, "timeout_seconds"},
+		{Config{Name: "w", Type: TypeWebhook, URL: "https://x", Secret: "k", Template: `{"s": {{json .Subject}}}`, TimeoutSeconds: 5}, ""},
+		{Config{Name: "mx", Type: TypeMatrix, URL: "https://matrix.example.com", RoomID: "!room:example.com", Token: "t"}, ""},
+		{Config{Name: "mx", Type: TypeMatrix, URL: "https://matrix.example.com", RoomID: "#ops:example.com", Token: "t"}, "room_id"},
+		{Config{Name: "mx", Type: TypeMatrix, URL: "https://matrix.example.com", RoomID: "!room:example.com"}, "token"},
+		{Config{Name: "n", Type: TypeNtfy, URL: "https://ntfy.sh", Topic: "ops", Priorities: map[string]int{"high": 4}}, ""},
+		{Config{Name: "n", Type: TypeNtfy, URL: "https://ntfy.sh"}, "topic"},
+		{Config{Name: "n", Type: TypeNtfy, URL: "https://ntfy.sh", Topic: "ops", Priorities: map[string]int{"high": 8}}, "от 1 до 5"},
+		{Config{Name: "n", Type: TypeNtfy, URL: "https://ntfy.sh", Topic: "ops", Priorities: map[string]int{"urgent": 5}}, "неизвестная важность"},
+		{Config{Name: "n", Type: TypeNtfy, URL: "https://ntfy.sh", Topic: "ops", Password: "p"}, "username"},
+		{Config{Name: "n", Type: TypeNtfy, URL: "https://ntfy.sh", Topic: "ops", Token: "t", Username: "u"}, "token или username"},
+		{Config{Name: "g", Type: TypeGotify, URL: "https://push.example.com", Token: "t", Priorities: map[string]int{"high": 10}}, ""},
+		{Config{Name: "g", Type: TypeGotify, URL: "https://push.example.com"}, "token"},
+		{Config{Name: "s", Type: TypeSlack, URL: "https://x", Priorities: map[string]int{"high": 1}}, "priorities"},
+		{Config{Name: "s", Type: TypeSlack, URL: "https://x", Token: "t"}, "token"},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.Validate()
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%+v: %v", tt.cfg, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%+v: err = %v, want %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"otn/mailsource"
+)
+
+// Приоритеты push-уведомлений по умолчанию для важности письма. У ntfy
+// приоритеты от 1 до 5, у Gotify - от 0 до 10
+var (
+	ntfyPriorities   = map[string]int{"low": 2, "normal": 3, "high": 5}
+	gotifyPriorities = map[string]int{"low": 2, "normal": 5, "high": 8}
+)
+
+// Проверяет priorities: ключи - важность письма, значения - в диапазоне сервиса
+func validatePriorities(c Config) error {
+	if len(c.Priorities) == 0 {
+		return nil
+	}
+	var min, max int
+	switch c.Type {
+	case TypeNtfy:
+		min, max = 1, 5
+	case TypeGotify:
+		min, max = 0, 10
+	default:
+		return fmt.Errorf("priorities используются только для каналов ntfy и gotify")
+	}
+	for key, value := range c.Priorities {
+		if key != "low" && key != "normal" && key != "high" {
+			return fmt.Errorf("неизвестная важность в priorities: %q (допустимы: low, normal, high)", key)
+		}
+		if value < min || value > max {
+			return fmt.Errorf("приоритет %s для %s должен быть в диапазоне от %d до %d", key, c.Type, min, max)
+		}
+	}
+	return nil
+}
+
+// Приоритет для важности письма: из настроек канала или по умолчанию
+func priority(c Config, defaults map[string]int, importance mailsource.Importance) int {
+	name := ImportanceName(importance)
+	if p, ok := c.Priorities[name]; ok {
+		return p
+	}
+	return defaults[name]
+}
+
+// Заголовок push-уведомления: тема письма, для важных писем с отметкой
+func pushTitle(n Notification) string {
+	title := n.Subject
+	if title == "" {
+		title = "(без темы)"
+	}
+	if n.Importance == mailsource.ImportanceHigh {
+		title = "❗ " + title
+	}
+	return title
+}
+
+// Ntfy - канал push-уведомлений ntfy (ntfy.sh или свой сервер)
+type Ntfy struct {
+	cfg    Config
+	client *http.Client
+	Retry
+
+	// Format формирует текст уведомления; по умолчанию FormatPlain
+	Format func(n Notification) string
+}
+
+// NewNtfy создает канал ntfy
+func NewNtfy(cfg Config, client *http.Client) *Ntfy {
+	return &Ntfy{cfg: cfg, client: client, Retry: newRetry(cfg), Format: FormatPlain}
+}
+
+// Name возвращает имя канала
+func (t *Ntfy) Name() string {
+	return t.cfg.Name
+}
+
+// Notify публикует уведомление в тему. Используется публикация JSON в корень
+// сервера: так заголовок и текст не ограничены кодировкой HTTP-заголовков
+func (t *Ntfy) Notify(ctx context.Context, n Notification) error {
+	payload := map[string]interface{}{
+		"topic":    t.cfg.Topic,
+		"title":    pushTitle(n),
+		"message":  t.Format(n),
+		"priority": priority(t.cfg, ntfyPriorities, n.Importance),
+		"tags":     []string{"email"},
+	}
+
+	header := make(http.Header)
+	switch {
+	case t.cfg.Token != "":
+		header.Set("Authorization", "Bearer "+t.cfg.Token)
+	case t.cfg.Username != "":
+		credentials := base64.StdEncoding.EncodeToString([]byte(t.cfg.Username + ":" + t.cfg.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	endpoint := strings.TrimSuffix(t.cfg.URL, "/") + "/"
+	err := t.do(ctx, func() error {
+		_, err := postJSON(ctx, t.client, endpoint, header, payload)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки в ntfy: %w", err)
+	}
+	return nil
+}
+
+// Gotify - канал push-уведомлений Gotify
+type Gotify struct {
+	cfg    Config
+	client *http.Client
+	Retry
+
+	// Format формирует текст уведомления; по умолчанию FormatPlain
+	Format func(n Notification) string
+}
+
+// NewGotify создает канал Gotify
+func NewGotify(cfg Config, client *http.Client) *Gotify {
+	return &Gotify{cfg: cfg, client: client, Retry: newRetry(cfg), Format: FormatPlain}
+}
+
+// Name возвращает имя канала
+func (g *Gotify) Name() string {
+	return g.cfg.Name
+}
+
+// Notify отправляет сообщение от имени приложения Gotify. Токен приложения
+// передается заголовком, чтобы он не попал в журналы прокси вместе с адресом
+func (g *Gotify) Notify(ctx context.Context, n Notification) error {
+	payload := map[string]interface{}{
+		"title":    pushTitle(n),
+		"message":  g.Format(n),
+		"priority": priority(g.cfg, gotifyPriorities, n.Importance),
+	}
+	header := http.Header{"X-Gotify-Key": {g.cfg.Token}}
+
+	endpoint := strings.TrimSuffix(g.cfg.URL, "/") + "/message"
+	err := g.do(ctx, func() error {
+		_, err := postJSON(ctx, g.client, endpoint, header, payload)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки в Gotify: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"otn/mailsource"
+)
+
+func TestMatrix(t *testing.T) {
+	var (
+		mutex  sync.Mutex
+		method string
+		path   string
+		auth   string
+		event  map[string]interface{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		method, path, auth = r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&event)
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer srv.Close()
+
+	cfg := Config{Name: "mx", Type: TypeMatrix, URL: srv.URL + "/", RoomID: "!ops:example.com", Token: "syt_token"}
+	n, err := New(cfg, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification := testNotification()
+	if err := n.Notify(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	wantPath := "/_matrix/client/v3/rooms/%21ops:example.com/send/m.room.message/" + transactionID(notification)
+	if method != http.MethodPut || path != wantPath {
+		t.Errorf("запрос %s %s, want PUT %s", method, path, wantPath)
+	}
+	if auth != "Bearer syt_token" {
+		t.Errorf("Authorization = %q", auth)
+	}
+	if event["msgtype"] != "m.text" || event["format"] != "org.matrix.custom.html" {
+		t.Errorf("событие = %v", event)
+	}
+	html := event["formatted_body"].(string)
+	for _, want := range []string{"<b>Тема:</b> ❗ Проблема: db1 &lt;недоступен&gt;<br>", "<blockquote>Сервер *db1* недоступен<br>с 12:30</blockquote>"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("formatted_body не содержит %q:\n%s", want, html)
+		}
+	}
+	if strings.Contains(html, "\n") {
+		t.Errorf("в formatted_body остались переводы строк:\n%s", html)
+	}
+	if body := event["body"].(string); !strings.Contains(body, "Тема: ❗ Проблема: db1 <недоступен>\n") {
+		t.Errorf("body:\n%s", body)
+	}
+}
+
+func TestMatrixLowImportanceIsNotice(t *testing.T) {
+	var msgtype string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&event)
+		msgtype, _ = event["msgtype"].(string)
+	}))
+	defer srv.Close()
+
+	n, _ := New(Config{Name: "mx", Type: TypeMatrix, URL: srv.URL, RoomID: "!ops:example.com", Token: "t"}, srv.Client())
+	notification := testNotification()
+	notification.Importance = mailsource.ImportanceLow
+	if err := n.Notify(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+	if msgtype != "m.notice" {
+		t.Errorf("msgtype = %q, want m.notice", msgtype)
+	}
+}
+
+func TestMatrixRateLimited(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusTooManyRequests, http.StatusOK)
+	n, _ := New(Config{Name: "mx", Type: TypeMatrix, URL: srv.URL, RoomID: "!ops:example.com", Token: "t"}, srv.Client())
+	m := n.(*Matrix)
+	m.RetryDelay = time.Millisecond
+
+	if err := m.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.requests() != 2 {
+		t.Errorf("запросов %d, want 2", srv.requests())
+	}
+	// Повтор идет с тем же телом, а идентификатор транзакции в адресе не меняется
+	if string(srv.bodies[0]) != string(srv.bodies[1]) {
+		t.Error("повторный запрос отличается от первого")
+	}
+}
+
+func TestMatrixRetryAfterBody(t *testing.T) {
+	err := matrixRetryAfter(&httpError{Status: http.StatusTooManyRequests, Body: `{"errcode":"M_LIMIT_EXCEEDED","retry_after_ms":1500}`})
+	if got := err.(*httpError).RetryAfter; got != 1500*time.Millisecond {
+		t.Errorf("RetryAfter = %v", got)
+	}
+}
+
+func TestTransactionID(t *testing.T) {
+	a := testNotification()
+	b := testNotification()
+	if transactionID(a) != transactionID(b) {
+		t.Error("для одного письма идентификатор транзакции должен совпадать")
+	}
+	b.ID = "other"
+	if transactionID(a) == transactionID(b) {
+		t.Error("для разных писем идентификаторы транзакции должны различаться")
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, `{"id":"abc"}`)
+
+	cfg := Config{Name: "push", Type: TypeNtfy, URL: srv.URL, Topic: "oncall", Token: "tk_123"}
+	n, err := New(cfg, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.path != "/" || req.header.Get("Authorization") != "Bearer tk_123" {
+		t.Errorf("запрос %s, Authorization %q", req.path, req.header.Get("Authorization"))
+	}
+	if req.body["topic"] != "oncall" || req.body["title"] != "❗ Проблема: db1 <недоступен>" || req.body["priority"] != float64(5) {
+		t.Errorf("тело = %v", req.body)
+	}
+	if message := req.body["message"].(string); !strings.Contains(message, "Отправитель: Zabbix <zabbix@example.com>") || !strings.Contains(message, "Сервер *db1* недоступен") {
+		t.Errorf("message:\n%s", message)
+	}
+}
+
+func TestNtfyBasicAuthAndPriorities(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, `{}`)
+
+	cfg := Config{Name: "push", Type: TypeNtfy, URL: srv.URL, Topic: "oncall", Username: "otn", Password: "pw",
+		Priorities: map[string]int{"normal": 1}}
+	n, _ := New(cfg, srv.Client())
+	notification := testNotification()
+	notification.Importance = mailsource.ImportanceNormal
+	if err := n.Notify(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("otn:pw"))
+	if got := req.header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+	if req.body["priority"] != float64(1) {
+		t.Errorf("priority = %v, want 1", req.body["priority"])
+	}
+}
+
+func TestGotify(t *testing.T) {
+	srv, requests := newServer(t, http.StatusOK, `{"id":1}`)
+
+	n, err := New(Config{Name: "gotify", Type: TypeGotify, URL: srv.URL + "/gotify/", Token: "AppToken"}, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	notification := testNotification()
+	notification.Importance = mailsource.ImportanceLow
+	if err := n.Notify(context.Background(), notification); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (*requests)[0]
+	if req.path != "/gotify/message" || req.header.Get("X-Gotify-Key") != "AppToken" {
+		t.Errorf("запрос %s, X-Gotify-Key %q", req.path, req.header.Get("X-Gotify-Key"))
+	}
+	if req.body["title"] != "Проблема: db1 <недоступен>" || req.body["priority"] != float64(2) {
+		t.Errorf("тело = %v", req.body)
+	}
+}
+
+func TestTelegramRetries(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusTooManyRequests, http.StatusOK)
+	n, _ := New(Config{Name: "oncall", Type: TypeTelegram, ChatID: "-100123", BotToken: "123:abc"}, srv.Client())
+	tg := n.(*Telegram)
+	tg.apiURL = srv.URL
+	tg.RetryDelay = time.Millisecond
+
+	if err := tg.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.requests() != 2 {
+		t.Errorf("запросов %d, want 2", srv.requests())
+	}
+}
+
+func TestTelegramLimit(t *testing.T) {
+	srv, _ := newServer(t, http.StatusOK, `{"ok":true}`)
+	n, _ := New(Config{Name: "oncall", Type: TypeTelegram, ChatID: "-100123", BotToken: "123:abc"}, srv.Client())
+	tg := n.(*Telegram)
+	tg.apiURL = srv.URL
+
+	var chats []string
+	tg.Limit = func(ctx context.Context, chatID string) error {
+		chats = append(chats, chatID)
+		return nil
+	}
+	if err := tg.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if len(chats) != 1 || chats[0] != "-100123" {
+		t.Errorf("Limit вызван для %v", chats)
+	}
+}
+
+func TestTelegramFlood(t *testing.T) {
+	srv, _ := newServer(t, http.StatusTooManyRequests,
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`)
+	retries := 0
+	n, _ := New(Config{Name: "oncall", Type: TypeTelegram, ChatID: "-100123", BotToken: "123:abc", Retries: &retries}, srv.Client())
+	tg := n.(*Telegram)
+	tg.apiURL = srv.URL
+
+	var (
+		chat string
+		wait time.Duration
+	)
+	tg.Flood = func(chatID string, d time.Duration) {
+		chat, wait = chatID, d
+	}
+	err := tg.Notify(context.Background(), testNotification())
+	if err == nil {
+		t.Fatal("ожидалась ошибка")
+	}
+	if chat != "-100123" || wait != 7*time.Second {
+		t.Errorf("Flood(%q, %v), want -100123 и 7s", chat, wait)
+	}
+	if Permanent(err) {
+		t.Error("ответ 429 не должен считаться окончательным")
+	}
+	if got := RetryAfter(err); got != 7*time.Second {
+		t.Errorf("RetryAfter = %v, want 7s", got)
+	}
+}
@@ -0,0 +1,107 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Повторы доставки по умолчанию: число повторов и пауза перед первым из них
+const (
+	DefaultRetries    = 2
+	DefaultRetryDelay = time.Second
+)
+
+// Наибольшая пауза между попытками
+const maxRetryDelay = time.Minute
+
+// Retry - повторы доставки, общие для всех каналов
+type Retry struct {
+	Retries    int           // Повторы после временных ошибок
+	RetryDelay time.Duration // Пауза перед первым повтором
+}
+
+func newRetry(cfg Config) Retry {
+	r := Retry{Retries: DefaultRetries, RetryDelay: DefaultRetryDelay}
+	if cfg.Retries != nil {
+		r.Retries = *cfg.Retries
+	}
+	return r
+}
+
+// Выполняет send и повторяет его не более Retries раз после временных ошибок.
+// Пауза перед повтором удваивается, начиная с RetryDelay; если сервер указал
+// Retry-After, ждем не меньше
+func (r Retry) do(ctx context.Context, send func() error) error {
+	delay := r.RetryDelay
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil || attempt >= r.Retries || !temporary(err) {
+			return err
+		}
+
+		wait := delay
+		var httpErr *httpError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > wait {
+			wait = httpErr.RetryAfter
+		}
+		if wait > maxRetryDelay {
+			wait = maxRetryDelay
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// Временная ошибка: сбой соединения, ответ 429 или 5xx. Остальные ответы
+// не изменятся от повтора
+func temporary(err error) bool {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status == http.StatusTooManyRequests || httpErr.Status >= 500
+	}
+	var reqErr *requestError
+	return errors.As(err, &reqErr)
+}
+
+// Permanent сообщает, что канал отклонил уведомление ответом 4xx, кроме 429:
+// повтор того же запроса не поможет ни сейчас, ни позже
+func Permanent(err error) bool {
+	var httpErr *httpError
+	return errors.As(err, &httpErr) && httpErr.Status >= 400 && httpErr.Status < 500 &&
+		httpErr.Status != http.StatusTooManyRequests
+}
+
+// RetryAfter возвращает паузу, которую канал попросил выдержать перед
+// повтором (Retry-After или retry_after в теле ответа 429), либо 0
+func RetryAfter(err error) time.Duration {
+	var httpErr *httpError
+	if errors.As(err, &httpErr) && temporary(err) {
+		return httpErr.RetryAfter
+	}
+	return 0
+}
+
+// Разбирает заголовок Retry-After: число секунд или дата
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
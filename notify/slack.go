@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// IncomingWebhook - канал во входящий вебхук Slack или Mattermost. Оба
+// принимают JSON с полем text, но разметка текста у них разная
+type IncomingWebhook struct {
+	cfg    Config
+	client *http.Client
+	Retry
+
+	// Format формирует текст уведомления; по умолчанию FormatSlack или FormatMattermost
+	Format func(n Notification) string
+}
+
+// NewIncomingWebhook создает канал Slack или Mattermost
+func NewIncomingWebhook(cfg Config, client *http.Client) *IncomingWebhook {
+	format := FormatSlack
+	if cfg.Type == TypeMattermost {
+		format = FormatMattermost
+	}
+	return &IncomingWebhook{cfg: cfg, client: client, Retry: newRetry(cfg), Format: format}
+}
+
+// Name возвращает имя канала
+func (w *IncomingWebhook) Name() string {
+	return w.cfg.Name
+}
+
+// Notify отправляет уведомление во входящий вебхук
+func (w *IncomingWebhook) Notify(ctx context.Context, n Notification) error {
+	payload := map[string]interface{}{"text": w.Format(n)}
+	if w.cfg.Channel != "" {
+		payload["channel"] = w.cfg.Channel
+	}
+	if w.cfg.Username != "" {
+		payload["username"] = w.cfg.Username
+	}
+	if w.cfg.IconURL != "" {
+		payload["icon_url"] = w.cfg.IconURL
+	}
+
+	err := w.do(ctx, func() error {
+		_, err := postJSON(ctx, w.client, w.cfg.URL, nil, payload)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки в %s: %w", w.cfg.Type, err)
+	}
+	return nil
+}
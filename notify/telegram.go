@@ -0,0 +1,93 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Адрес Bot API по умолчанию
+const telegramAPIURL = "https://api.telegram.org"
+
+// Telegram - канал в чат Telegram через Bot API
+type Telegram struct {
+	cfg    Config
+	client *http.Client
+	apiURL string
+	Retry
+
+	// Format формирует текст уведомления; по умолчанию FormatTelegram
+	Format func(n Notification) string
+
+	// Limit, если задан, вызывается перед каждой попыткой отправки и ждет,
+	// пока ограничение частоты сообщений в чат chatID позволит отправить
+	Limit func(ctx context.Context, chatID string) error
+
+	// Flood, если задан, вызывается после ответа 429 с паузой, которую
+	// указал Telegram (parameters.retry_after)
+	Flood func(chatID string, wait time.Duration)
+}
+
+// NewTelegram создает канал Telegram
+func NewTelegram(cfg Config, client *http.Client) *Telegram {
+	return &Telegram{cfg: cfg, client: client, apiURL: telegramAPIURL, Retry: newRetry(cfg), Format: FormatTelegram}
+}
+
+// Name возвращает имя канала
+func (t *Telegram) Name() string {
+	return t.cfg.Name
+}
+
+// Notify отправляет уведомление методом sendMessage
+func (t *Telegram) Notify(ctx context.Context, n Notification) error {
+	params := map[string]interface{}{
+		"chat_id":    t.cfg.ChatID,
+		"text":       t.Format(n),
+		"parse_mode": "HTML",
+	}
+	url := fmt.Sprintf("%s/bot%s/sendMessage", strings.TrimSuffix(t.apiURL, "/"), t.cfg.BotToken)
+	err := t.do(ctx, func() error {
+		if t.Limit != nil {
+			if err := t.Limit(ctx, t.cfg.ChatID); err != nil {
+				return err
+			}
+		}
+		_, err := postJSON(ctx, t.client, url, nil, params)
+		err = telegramRetryAfter(err)
+		var httpErr *httpError
+		if t.Flood != nil && errors.As(err, &httpErr) && httpErr.Status == http.StatusTooManyRequests {
+			wait := httpErr.RetryAfter
+			if wait <= 0 {
+				wait = time.Second
+			}
+			t.Flood(t.cfg.ChatID, wait)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки в Telegram: %w", err)
+	}
+	return nil
+}
+
+// Telegram сообщает паузу после ответа 429 в теле (parameters.retry_after,
+// в секундах), а не только в заголовке Retry-After
+func telegramRetryAfter(err error) error {
+	var httpErr *httpError
+	if !errors.As(err, &httpErr) || httpErr.Status != http.StatusTooManyRequests || httpErr.RetryAfter > 0 {
+		return err
+	}
+	var body struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if json.Unmarshal([]byte(httpErr.Body), &body) == nil && body.Parameters.RetryAfter > 0 {
+		httpErr.RetryAfter = time.Duration(body.Parameters.RetryAfter) * time.Second
+	}
+	return err
+}
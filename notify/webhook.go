@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Заголовок с подписью тела запроса вебхука
+const SignatureHeader = "X-OTN-Signature"
+
+// Webhook - канал, который отправляет уведомление JSON-объектом на произвольный адрес
+type Webhook struct {
+	cfg      Config
+	client   *http.Client
+	template *template.Template // nil - тело по умолчанию (Payload)
+	Retry
+}
+
+// NewWebhook создает канал webhook
+func NewWebhook(cfg Config, client *http.Client) (*Webhook, error) {
+	w := &Webhook{cfg: cfg, client: client, Retry: newRetry(cfg)}
+	if cfg.Template != "" {
+		tmpl, err := parseTemplate(cfg.Template)
+		if err != nil {
+			return nil, err
+		}
+		w.template = tmpl
+	}
+	return w, nil
+}
+
+// Name возвращает имя канала
+func (w *Webhook) Name() string {
+	return w.cfg.Name
+}
+
+// Вложение в теле вебхука
+type webhookAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+}
+
+// Тело запроса вебхука. Эти же поля доступны в шаблоне: {{.Subject}}, {{json .Body}}
+type webhookPayload struct {
+	ID          string              `json:"id"`
+	Folder      string              `json:"folder"`
+	SenderName  string              `json:"sender_name"`
+	SenderEmail string              `json:"sender_email"`
+	Subject     string              `json:"subject"`
+	Body        string              `json:"body"`
+	Importance  string              `json:"importance"` // low, normal, high
+	Received    *time.Time          `json:"received,omitempty"`
+	Attachments []webhookAttachment `json:"attachments"`
+}
+
+// Payload возвращает уведомление в виде тела запроса вебхука
+func Payload(n Notification) interface{} {
+	return newPayload(n)
+}
+
+func newPayload(n Notification) webhookPayload {
+	p := webhookPayload{
+		ID:          n.ID,
+		Folder:      n.Folder,
+		SenderName:  n.SenderName,
+		SenderEmail: n.SenderEmail,
+		Subject:     n.Subject,
+		Body:        n.Body,
+		Importance:  ImportanceName(n.Importance),
+		Attachments: []webhookAttachment{},
+	}
+	if !n.Received.IsZero() {
+		p.Received = &n.Received
+	}
+	for _, a := range n.Attachments {
+		p.Attachments = append(p.Attachments, webhookAttachment{Name: a.Name, ContentType: a.ContentType, Size: a.Size})
+	}
+	return p
+}
+
+// Разбирает шаблон тела запроса и проверяет, что он дает JSON. Функция json
+// кодирует значение в JSON, например {"title": {{json .Subject}}}
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(v); err != nil {
+				return "", err
+			}
+			return strings.TrimSuffix(buf.String(), "\n"), nil
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка в шаблоне: %v", err)
+	}
+
+	sample := Notification{Subject: "Тест", Received: time.Now()}
+	if _, err := render(tmpl, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// Заполняет шаблон уведомлением
+func render(tmpl *template.Template, n Notification) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newPayload(n)); err != nil {
+		return nil, fmt.Errorf("ошибка в шаблоне: %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("шаблон дает некорректный JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign возвращает подпись тела запроса: sha256=<HMAC-SHA256 в hex>
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify отправляет уведомление на адрес вебхука
+func (w *Webhook) Notify(ctx context.Context, n Notification) error {
+	var (
+		body []byte
+		err  error
+	)
+	if w.template != nil {
+		body, err = render(w.template, n)
+	} else {
+		body, err = json.Marshal(Payload(n))
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка формирования вебхука: %v", err)
+	}
+
+	header := make(http.Header)
+	for key, value := range w.cfg.Headers {
+		header.Set(key, value)
+	}
+	if w.cfg.Secret != "" {
+		header.Set(SignatureHeader, Sign(w.cfg.Secret, body))
+	}
+
+	err = w.do(ctx, func() error {
+		_, err := send(ctx, w.client, http.MethodPost, w.cfg.URL, header, body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка отправки вебхука: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Тестовый сервер, который отвечает статусами из statuses по очереди
+// (последний - на все остальные запросы) и запоминает тела запросов
+type sequenceServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func newSequenceServer(t *testing.T, statuses ...int) *sequenceServer {
+	t.Helper()
+	s := &sequenceServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		s.mutex.Lock()
+		status := s.statuses[0]
+		if len(s.statuses) > 1 {
+			s.statuses = s.statuses[1:]
+		}
+		s.bodies = append(s.bodies, body)
+		s.headers = append(s.headers, r.Header)
+		s.mutex.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *sequenceServer) requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.bodies)
+}
+
+func newTestWebhook(t *testing.T, cfg Config, client *http.Client) *Webhook {
+	t.Helper()
+	cfg.Name, cfg.Type = "hook", TypeWebhook
+	n, err := New(cfg, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := n.(*Webhook)
+	w.RetryDelay = time.Millisecond
+	return w
+}
+
+func TestWebhookSignature(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusOK)
+	w := newTestWebhook(t, Config{URL: srv.URL, Secret: "s3cret"}, srv.Client())
+
+	if err := w.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(srv.bodies[0])
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := srv.headers[0].Get(SignatureHeader); got != want {
+		t.Errorf("%s = %q, want %q", SignatureHeader, got, want)
+	}
+}
+
+func TestWebhookNoSignatureWithoutSecret(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusOK)
+	w := newTestWebhook(t, Config{URL: srv.URL}, srv.Client())
+
+	if err := w.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.headers[0].Get(SignatureHeader); got != "" {
+		t.Errorf("без secret подписи быть не должно: %q", got)
+	}
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusOK)
+	tmpl := `{"title": {{json .Subject}}, "severity": "{{.Importance}}", "source": {{json .Folder}}, "files": {{len .Attachments}}}`
+	w := newTestWebhook(t, Config{URL: srv.URL, Template: tmpl}, srv.Client())
+
+	if err := w.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	want := `{"title": "Проблема: db1 <недоступен>", "severity": "high", "source": "Zabbix", "files": 1}`
+	if got := string(srv.bodies[0]); got != want {
+		t.Errorf("тело запроса:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWebhookTemplateInvalid(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{`{"title": {{.Subject}}}`, "некорректный JSON"},
+		{`{"title": {{json .Subjekt}}}`, "ошибка в шаблоне"},
+		{`{"title": {{json .Subject}`, "ошибка в шаблоне"},
+	}
+	for _, tt := range tests {
+		err := Config{Name: "hook", Type: TypeWebhook, URL: "https://x", Template: tt.template}.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.template, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK)
+	w := newTestWebhook(t, Config{URL: srv.URL, Secret: "k"}, srv.Client())
+
+	if err := w.Notify(context.Background(), testNotification()); err != nil {
+		t.Fatal(err)
+	}
+	if srv.requests() != 3 {
+		t.Errorf("запросов %d, want 3", srv.requests())
+	}
+	// Повтор отправляет то же тело с той же подписью
+	if string(srv.bodies[0]) != string(srv.bodies[2]) || srv.headers[0].Get(SignatureHeader) != srv.headers[2].Get(SignatureHeader) {
+		t.Error("повторный запрос отличается от первого")
+	}
+}
+
+func TestWebhookRetriesExhausted(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusServiceUnavailable)
+	retries := 1
+	w := newTestWebhook(t, Config{URL: srv.URL, Retries: &retries}, srv.Client())
+
+	err := w.Notify(context.Background(), testNotification())
+	if err == nil || !strings.Contains(err.Error(), "HTTP 503") {
+		t.Errorf("err = %v", err)
+	}
+	if Permanent(err) {
+		t.Error("ответ 503 не должен считаться окончательным")
+	}
+	if srv.requests() != 2 {
+		t.Errorf("запросов %d, want 2", srv.requests())
+	}
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	srv := newSequenceServer(t, http.StatusUnauthorized, http.StatusOK)
+	w := newTestWebhook(t, Config{URL: srv.URL}, srv.Client())
+
+	err := w.Notify(context.Background(), testNotification())
+	if err == nil {
+		t.Fatal("ожидалась ошибка")
+	}
+	if !Permanent(err) {
+		t.Errorf("ответ 401 должен считаться окончательным: %v", err)
+	}
+	if srv.requests() != 1 {
+		t.Errorf("запросов %d, want 1", srv.requests())
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	retries := 0
+	w := newTestWebhook(t, Config{URL: srv.URL, TimeoutSeconds: 1, Retries: &retries}, srv.Client())
+	// Общий клиент не меняется: время ожидания задается копии
+	if srv.Client().Timeout != 0 {
+		t.Fatal("время ожидания изменено у общего клиента")
+	}
+
+	started := time.Now()
+	err := w.Notify(context.Background(), testNotification())
+	if err == nil {
+		t.Fatal("ожидалась ошибка")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("запрос длился %v при timeout_seconds 1", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if got := retryAfter("3"); got != 3*time.Second {
+		t.Errorf("retryAfter(3) = %v", got)
+	}
+	if got := retryAfter(""); got != 0 {
+		t.Errorf("retryAfter('') = %v", got)
+	}
+	if got := retryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); got < 59*time.Minute {
+		t.Errorf("retryAfter(дата) = %v", got)
+	}
+}
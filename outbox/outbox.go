@@ -0,0 +1,303 @@
+// Пакет outbox - очередь исходящих уведомлений на диске. Каждый элемент
+// хранится в отдельном файле каталога очереди, поэтому очередь переживает
+// перезапуск программы, а поврежденный файл не мешает остальным.
+//
+// Элементы одного получателя (Dest) выдаются строго по порядку: пока первый
+// элемент ждет повтора, следующие за ним не отправляются. Элементы других
+// получателей при этом продолжают уходить. Элемент, который доставить
+// невозможно, переносится в файл *.failed и больше не задерживает очередь.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Расширение файлов элементов, поврежденных и недоставленных файлов
+const (
+	itemExt   = ".json"
+	brokenExt = ".broken"
+	failedExt = ".failed"
+)
+
+// Item - элемент очереди
+type Item struct {
+	ID          string          `json:"-"`    // Имя файла без расширения
+	Dest        string          `json:"dest"` // Получатель: элементы одного получателя отправляются по порядку
+	Created     time.Time       `json:"created"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// Entry - новый элемент для Push
+type Entry struct {
+	Dest string
+	Data interface{} // Кодируется в JSON
+}
+
+// Queue - очередь в каталоге на диске
+type Queue struct {
+	dir string
+
+	mutex  sync.Mutex
+	items  []*Item // По порядку добавления
+	seq    uint64
+	signal chan struct{}
+}
+
+// Open открывает очередь в каталоге dir, создавая его при необходимости.
+// Файлы, которые не удалось прочитать, переименовываются в *.broken
+func Open(dir string, logf func(format string, args ...interface{})) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("ошибка создания каталога очереди: %v", err)
+	}
+	q := &Queue{dir: dir, signal: make(chan struct{}, 1)}
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"+itemExt))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	for _, path := range names {
+		id := strings.TrimSuffix(filepath.Base(path), itemExt)
+		item, err := readItem(path)
+		if err != nil {
+			if logf != nil {
+				logf("Поврежденный файл очереди %s: %v", filepath.Base(path), err)
+			}
+			os.Rename(path, strings.TrimSuffix(path, itemExt)+brokenExt)
+			continue
+		}
+		item.ID = id
+		q.items = append(q.items, item)
+		if seq, err := strconv.ParseUint(id, 10, 64); err == nil && seq > q.seq {
+			q.seq = seq
+		}
+	}
+
+	// Незаконченные записи (*.tmp) остались от сбоя во время Push
+	tmp, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	for _, path := range tmp {
+		os.Remove(path)
+	}
+	return q, nil
+}
+
+func readItem(path string) (*Item, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var item Item
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Записывает элемент через временный файл, чтобы на диске не осталось
+// половины файла при сбое
+func (q *Queue) writeItem(item *Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(q.dir, item.ID+itemExt)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Push добавляет элементы в очередь и возвращает их идентификаторы.
+// Добавляются либо все элементы, либо ни одного
+func (q *Queue) Push(entries ...Entry) ([]string, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	added := make([]*Item, 0, len(entries))
+	rollback := func() {
+		for _, item := range added {
+			os.Remove(filepath.Join(q.dir, item.ID+itemExt))
+		}
+	}
+
+	for _, entry := range entries {
+		data, err := json.Marshal(entry.Data)
+		if err != nil {
+			rollback()
+			return nil, fmt.Errorf("ошибка маршалинга элемента очереди: %v", err)
+		}
+		q.seq++
+		item := &Item{ID: fmt.Sprintf("%020d", q.seq), Dest: entry.Dest, Created: now, Data: data}
+		if err := q.writeItem(item); err != nil {
+			rollback()
+			return nil, fmt.Errorf("ошибка записи в очередь: %v", err)
+		}
+		added = append(added, item)
+	}
+
+	ids := make([]string, 0, len(added))
+	for _, item := range added {
+		ids = append(ids, item.ID)
+	}
+	q.items = append(q.items, added...)
+	q.notify()
+	return ids, nil
+}
+
+// Будит ожидающего в Wait
+func (q *Queue) notify() {
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Wait возвращает канал, в который приходит сигнал при добавлении элементов
+func (q *Queue) Wait() <-chan struct{} {
+	return q.signal
+}
+
+// Next возвращает первый элемент, который можно отправить в момент now:
+// его время повтора наступило, а у получателя нет более ранних элементов.
+// Если такого элемента нет, wait - время до ближайшего повтора (0 - очередь пуста)
+func (q *Queue) Next(now time.Time) (item Item, wait time.Duration, ok bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	blocked := make(map[string]bool)
+	for _, it := range q.items {
+		if blocked[it.Dest] {
+			continue
+		}
+		blocked[it.Dest] = true
+		if !it.NextAttempt.After(now) {
+			return *it, 0, true
+		}
+		if d := it.NextAttempt.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return Item{}, wait, false
+}
+
+// Done удаляет доставленный элемент
+func (q *Queue) Done(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, it := range q.items {
+		if it.ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	if err := os.Remove(filepath.Join(q.dir, id+itemExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Fail удаляет элемент из очереди, сохраняя его вместе с ошибкой в файл
+// *.failed: такие элементы при запуске не читаются
+func (q *Queue) Fail(id string, cause error) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for i, it := range q.items {
+		if it.ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			if cause != nil {
+				it.LastError = cause.Error()
+			}
+			if err := q.writeItem(it); err != nil {
+				return err
+			}
+			path := filepath.Join(q.dir, id)
+			return os.Rename(path+itemExt, path+failedExt)
+		}
+	}
+	return fmt.Errorf("элемент %s не найден в очереди", id)
+}
+
+// Retry откладывает элемент на delay и запоминает ошибку
+func (q *Queue) Retry(id string, delay time.Duration, cause error) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, it := range q.items {
+		if it.ID == id {
+			it.Attempts++
+			it.NextAttempt = time.Now().Add(delay)
+			if cause != nil {
+				it.LastError = cause.Error()
+			}
+			return q.writeItem(it)
+		}
+	}
+	return fmt.Errorf("элемент %s не найден в очереди", id)
+}
+
+// Postpone откладывает элемент на delay, не считая это неудачной попыткой.
+// Отсрочка не записывается на диск: после перезапуска элемент доступен сразу
+func (q *Queue) Postpone(id string, delay time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for _, it := range q.items {
+		if it.ID == id {
+			it.NextAttempt = time.Now().Add(delay)
+			return
+		}
+	}
+}
+
+// Items возвращает копии элементов очереди по порядку добавления
+func (q *Queue) Items() []Item {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	items := make([]Item, 0, len(q.items))
+	for _, it := range q.items {
+		items = append(items, *it)
+	}
+	return items
+}
+
+// Len возвращает число элементов в очереди
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.items)
+}
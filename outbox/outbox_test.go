@@ -0,0 +1,197 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type payload struct {
+	Text string `json:"text"`
+}
+
+func openQueue(t *testing.T, dir string) *Queue {
+	t.Helper()
+	q, err := Open(dir, t.Logf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+func text(t *testing.T, item Item) string {
+	t.Helper()
+	var p payload
+	if err := json.Unmarshal(item.Data, &p); err != nil {
+		t.Fatal(err)
+	}
+	return p.Text
+}
+
+func TestPushNextDone(t *testing.T) {
+	q := openQueue(t, t.TempDir())
+
+	ids, err := q.Push(Entry{Dest: "a", Data: payload{"1"}}, Entry{Dest: "b", Data: payload{"2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || q.Len() != 2 {
+		t.Fatalf("ids = %v, Len = %d", ids, q.Len())
+	}
+	select {
+	case <-q.Wait():
+	default:
+		t.Error("Push не разбудил Wait")
+	}
+
+	for _, want := range []string{"1", "2"} {
+		item, _, ok := q.Next(time.Now())
+		if !ok || text(t, item) != want {
+			t.Fatalf("Next = %v, %v, want %s", item, ok, want)
+		}
+		if err := q.Done(item.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, wait, ok := q.Next(time.Now()); ok || wait != 0 {
+		t.Errorf("пустая очередь: ok = %v, wait = %v", ok, wait)
+	}
+}
+
+func TestSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir)
+	ids, _ := q.Push(Entry{Dest: "a", Data: payload{"1"}})
+	q.Push(Entry{Dest: "a", Data: payload{"2"}})
+	if err := q.Retry(ids[0], time.Hour, fmt.Errorf("HTTP 502")); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openQueue(t, dir)
+	if q.Len() != 2 {
+		t.Fatalf("после перезапуска Len = %d, want 2", q.Len())
+	}
+	if items := q.Items(); len(items) != 2 || text(t, items[0]) != "1" || text(t, items[1]) != "2" {
+		t.Errorf("Items = %+v", items)
+	}
+	// Первый элемент отложен, второй того же получателя ждет его
+	if _, wait, ok := q.Next(time.Now()); ok || wait < 59*time.Minute {
+		t.Errorf("ok = %v, wait = %v", ok, wait)
+	}
+	item, _, ok := q.Next(time.Now().Add(2 * time.Hour))
+	if !ok || text(t, item) != "1" || item.Attempts != 1 || item.LastError != "HTTP 502" {
+		t.Errorf("Next = %+v", item)
+	}
+
+	// Новые элементы получают номера после загруженных
+	newIDs, _ := q.Push(Entry{Dest: "b", Data: payload{"3"}})
+	if newIDs[0] <= ids[0] {
+		t.Errorf("новый id %s не больше %s", newIDs[0], ids[0])
+	}
+}
+
+func TestPerDestinationOrder(t *testing.T) {
+	q := openQueue(t, t.TempDir())
+	ids, _ := q.Push(
+		Entry{Dest: "slow", Data: payload{"s1"}},
+		Entry{Dest: "slow", Data: payload{"s2"}},
+		Entry{Dest: "fast", Data: payload{"f1"}},
+	)
+	q.Retry(ids[0], time.Minute, nil)
+
+	// Отложенный элемент не задерживает другого получателя, но задерживает своего
+	item, _, ok := q.Next(time.Now())
+	if !ok || text(t, item) != "f1" {
+		t.Fatalf("Next = %v, want f1", text(t, item))
+	}
+	q.Done(item.ID)
+	if _, wait, ok := q.Next(time.Now()); ok || wait <= 0 || wait > time.Minute {
+		t.Errorf("ok = %v, wait = %v", ok, wait)
+	}
+}
+
+func TestPostpone(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir)
+	ids, _ := q.Push(Entry{Dest: "a", Data: payload{"1"}})
+	q.Postpone(ids[0], time.Minute)
+
+	if _, wait, ok := q.Next(time.Now()); ok || wait <= 0 {
+		t.Errorf("ok = %v, wait = %v", ok, wait)
+	}
+	item, _, ok := q.Next(time.Now().Add(2 * time.Minute))
+	if !ok || item.Attempts != 0 {
+		t.Errorf("Next = %+v, %v", item, ok)
+	}
+
+	// Отсрочка не переживает перезапуск
+	q = openQueue(t, dir)
+	if _, _, ok := q.Next(time.Now()); !ok {
+		t.Error("после перезапуска элемент должен быть доступен")
+	}
+}
+
+func TestBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir)
+	q.Push(Entry{Dest: "a", Data: payload{"ok"}})
+
+	os.WriteFile(filepath.Join(dir, "00000000000000000005.json"), []byte("{broken"), 0644)
+	os.WriteFile(filepath.Join(dir, "00000000000000000006.json.tmp"), []byte("{"), 0644)
+
+	q = openQueue(t, dir)
+	if q.Len() != 1 {
+		t.Errorf("Len = %d, want 1", q.Len())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000005.broken")); err != nil {
+		t.Errorf("поврежденный файл не переименован: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "00000000000000000006.json.tmp")); !os.IsNotExist(err) {
+		t.Error("временный файл не удален")
+	}
+}
+
+func TestFail(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir)
+	ids, _ := q.Push(Entry{Dest: "a", Data: payload{"1"}}, Entry{Dest: "a", Data: payload{"2"}})
+
+	if err := q.Fail(ids[0], fmt.Errorf("HTTP 400")); err != nil {
+		t.Fatal(err)
+	}
+	// Следующий элемент того же получателя больше не ждет первый
+	if item, _, ok := q.Next(time.Now()); !ok || text(t, item) != "2" {
+		t.Fatalf("Next = %v, %v, want 2", item, ok)
+	}
+
+	failed, err := readItem(filepath.Join(dir, ids[0]+failedExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed.LastError != "HTTP 400" || failed.Dest != "a" {
+		t.Errorf("сохранено %+v", failed)
+	}
+	if q = openQueue(t, dir); q.Len() != 1 {
+		t.Errorf("после перезапуска Len = %d, want 1", q.Len())
+	}
+}
+
+func TestPushAllOrNothing(t *testing.T) {
+	dir := t.TempDir()
+	q := openQueue(t, dir)
+
+	_, err := q.Push(Entry{Dest: "a", Data: payload{"1"}}, Entry{Dest: "b", Data: func() {}})
+	if err == nil {
+		t.Fatal("ожидалась ошибка маршалинга")
+	}
+	if q.Len() != 0 {
+		t.Errorf("Len = %d, want 0", q.Len())
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 0 {
+		t.Errorf("остались файлы: %v", files)
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"golang.org/x/sys/windows"
+
+	"otn/mailsource"
+)
+
+// Интерфейсы событий Outlook
+var (
+	iidApplicationEvents11 = ole.NewGUID("{0006302C-0000-0000-C000-000000000046}")
+	iidItemsEvents         = ole.NewGUID("{00063077-0000-0000-C000-000000000046}")
+)
+
+const (
+	dispidNewMailEx = 0xFAB5 // ApplicationEvents_11.NewMailEx(EntryIDCollection)
+	dispidItemAdd   = 0xF001 // ItemsEvents.ItemAdd(Item)
+
+	eventQueueSize      = 1000             // Емкость очереди событий
+	eventHealthCheck    = 30 * time.Second // Как часто проверять, что Outlook жив
+	defaultReconcileSec = 300              // Интервал сверки опросом в режиме событий по умолчанию
+)
+
+// Запрос внеочередной сверки опросом (например, при переполнении очереди событий)
+var reconcileNow = make(chan struct{}, 1)
+
+func requestReconcile() {
+	select {
+	case reconcileNow <- struct{}{}:
+	default:
+	}
+}
+
+// Запускает режим событий: подписку на события Outlook и обработку очереди.
+// Опрос папок в mainLogic при этом выполняет периодическую сверку. Возвращает
+// ошибку подписки: подписку восстанавливает superviseSource, а письма,
+// пришедшие за это время, подбирает сверка
+func runEventMode(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := mailsource.NewQueue(eventQueueSize)
+	safeGo(func() {
+		processEvents(ctx, queue)
+	})
+
+	source := outlookEventSource{}
+	if err := source.Run(ctx, queue); err != nil {
+		return fmt.Errorf("ошибка подписки на события %s: %w", source.Name(), err)
+	}
+	return nil
+}
+
+// Обрабатывает события о новых письмах из очереди
+func processEvents(ctx context.Context, queue *mailsource.Queue) {
+	for {
+		ev, err := queue.Next(ctx)
+		if err != nil {
+			return
+		}
+		if queue.TakeOverflow() {
+			logMessage("Очередь событий переполнена, будет выполнена сверка опросом")
+			requestReconcile()
+		}
+
+		folderConfig, _ := findFolderConfig(ev.Folder)
+		if state.isPaused(ev.Folder) || state.isPaused(folderConfig.Name) {
+			continue // Письмо будет отправлено сверкой после /resume
+		}
+
+		err = withOutlook(func(ns *ole.IDispatch) error {
+			itemVar, err := oleutil.CallMethod(ns, "GetItemFromID", ev.ID)
+			if err != nil {
+				return fmt.Errorf("письмо не найдено: %v", err)
+			}
+			item := itemVar.ToIDispatch()
+			defer item.Release()
+
+			processEmail(item, ev.Folder)
+			return nil
+		})
+		if err != nil {
+			logMessage("Ошибка обработки события о письме в папке %s: %v", ev.Folder, err)
+		}
+	}
+}
+
+// Источник событий Outlook: Application.NewMailEx для папки «Входящие» и
+// Items.ItemAdd для остальных отслеживаемых папок
+type outlookEventSource struct{}
+
+func (outlookEventSource) Name() string { return "outlook" }
+
+// Run подписывается на события и обрабатывает сообщения Windows в своем потоке,
+// пока не отменен ctx или Outlook не перестал отвечать
+func (outlookEventSource) Run(ctx context.Context, queue *mailsource.Queue) (err error) {
+	// События COM доставляются в поток, который подписался, через очередь сообщений
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("ошибка Outlook: %v", r)
+		}
+	}()
+
+	if err := ole.CoInitializeEx(0, ole.COINIT_APARTMENTTHREADED); err == nil {
+		defer ole.CoUninitialize()
+	}
+
+	sub, err := subscribeOutlookEvents(queue)
+	if err != nil {
+		return err
+	}
+	defer sub.close()
+
+	logMessage("Подписка на события Outlook: NewMailEx и ItemAdd для папок: %d", len(sub.items))
+	return sub.pump(ctx)
+}
+
+// Активная подписка на события
+type outlookSubscription struct {
+	outlook, ns *ole.IDispatch
+	queue       *mailsource.Queue
+	watched     map[string]string // EntryID папки -> путь для уведомлений
+
+	connections []sinkConnection
+	items       []*ole.IDispatch // Коллекции Items должны жить, пока действует подписка
+}
+
+type sinkConnection struct {
+	point  *ole.IConnectionPoint
+	cookie uint32
+	sink   *eventSink
+}
+
+func subscribeOutlookEvents(queue *mailsource.Queue) (*outlookSubscription, error) {
+	outlook, ns, err := initializeOutlook()
+	if err != nil {
+		return nil, err
+	}
+	sub := &outlookSubscription{
+		outlook: outlook,
+		ns:      ns,
+		queue:   queue,
+		watched: make(map[string]string),
+	}
+
+	inboxID := ""
+	if inboxVar, err := oleutil.CallMethod(ns, "GetDefaultFolder", 6); err == nil {
+		inbox := inboxVar.ToIDispatch()
+		inboxID = oleutil.MustGetProperty(inbox, "EntryID").ToString()
+		inbox.Release()
+	}
+
+	folders := getTargetFolders(ns)
+	for name, folder := range folders {
+		entryID := oleutil.MustGetProperty(folder, "EntryID").ToString()
+		sub.watched[entryID] = name
+
+		// Письма во «Входящие» приходят событием NewMailEx
+		if entryID != inboxID {
+			items := oleutil.MustGetProperty(folder, "Items").ToIDispatch()
+			folderName := name
+			if err := sub.advise(items, iidItemsEvents, func(dispid int32, args []ole.VARIANT) {
+				if dispid == dispidItemAdd && len(args) == 1 {
+					sub.onItemAdd(folderName, &args[0])
+				}
+			}); err != nil {
+				logMessage("Не удалось подписаться на новые письма в папке %s: %v", name, err)
+				items.Release()
+			} else {
+				sub.items = append(sub.items, items)
+			}
+		}
+		folder.Release()
+	}
+
+	if err := sub.advise(outlook, iidApplicationEvents11, func(dispid int32, args []ole.VARIANT) {
+		if dispid == dispidNewMailEx && len(args) == 1 {
+			sub.onNewMailEx(args[0].ToString())
+		}
+	}); err != nil {
+		sub.close()
+		return nil, fmt.Errorf("ошибка подписки на NewMailEx: %v", err)
+	}
+	return sub, nil
+}
+
+func (s *outlookSubscription) advise(source *ole.IDispatch, iid *ole.GUID, handler func(int32, []ole.VARIANT)) error {
+	unknown, err := source.QueryInterface(ole.IID_IConnectionPointContainer)
+	if err != nil {
+		return err
+	}
+	container := (*ole.IConnectionPointContainer)(unsafe.Pointer(unknown))
+	defer container.Release()
+
+	var point *ole.IConnectionPoint
+	if err := container.FindConnectionPoint(iid, &point); err != nil {
+		return err
+	}
+
+	sink := newEventSink(iid, handler)
+	cookie, err := point.Advise((*ole.IUnknown)(unsafe.Pointer(sink)))
+	if err != nil {
+		point.Release()
+		return err
+	}
+	s.connections = append(s.connections, sinkConnection{point: point, cookie: cookie, sink: sink})
+	return nil
+}
+
+// NewMailEx передает EntryID новых писем через запятую. Письмо отправляется
+// в очередь, только если оно лежит в отслеживаемой папке
+func (s *outlookSubscription) onNewMailEx(entryIDs string) {
+	for _, id := range strings.Split(entryIDs, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		itemVar, err := oleutil.CallMethod(s.ns, "GetItemFromID", id)
+		if err != nil {
+			continue
+		}
+		item := itemVar.ToIDispatch()
+		if folder, ok := s.parentFolder(item); ok {
+			s.push(folder, id)
+		}
+		item.Release()
+	}
+}
+
+func (s *outlookSubscription) onItemAdd(folder string, arg *ole.VARIANT) {
+	if arg.VT != ole.VT_DISPATCH {
+		return
+	}
+	// Параметр события принадлежит Outlook, освобождать его не нужно
+	item := arg.ToIDispatch()
+	if idVar, err := oleutil.GetProperty(item, "EntryID"); err == nil {
+		s.push(folder, idVar.ToString())
+	}
+}
+
+func (s *outlookSubscription) parentFolder(item *ole.IDispatch) (string, bool) {
+	parentVar, err := oleutil.GetProperty(item, "Parent")
+	if err != nil {
+		return "", false
+	}
+	parent := parentVar.ToIDispatch()
+	defer parent.Release()
+
+	idVar, err := oleutil.GetProperty(parent, "EntryID")
+	if err != nil {
+		return "", false
+	}
+	folder, ok := s.watched[idVar.ToString()]
+	return folder, ok
+}
+
+func (s *outlookSubscription) push(folder, entryID string) {
+	logDebug("Событие о новом письме в папке %s", folder)
+	s.queue.Push(mailsource.Event{Source: "outlook", Folder: folder, ID: entryID})
+}
+
+func (s *outlookSubscription) close() {
+	for _, c := range s.connections {
+		c.point.Unadvise(c.cookie)
+		c.point.Release()
+	}
+	s.connections = nil
+	releaseObjects(s.items...)
+	s.items = nil
+	releaseObjects(s.ns, s.outlook)
+}
+
+// Функции user32 для обработки сообщений Windows
+var (
+	user32                        = windows.NewLazySystemDLL("user32.dll")
+	procPeekMessageW              = user32.NewProc("PeekMessageW")
+	procTranslateMessage          = user32.NewProc("TranslateMessage")
+	procDispatchMessageW          = user32.NewProc("DispatchMessageW")
+	procMsgWaitForMultipleObjects = user32.NewProc("MsgWaitForMultipleObjects")
+)
+
+const (
+	pmRemove   = 0x0001
+	qsAllInput = 0x04FF
+)
+
+type winMsg struct {
+	hwnd     uintptr
+	message  uint32
+	wParam   uintptr
+	lParam   uintptr
+	time     uint32
+	pt       struct{ x, y int32 }
+	lPrivate uint32
+}
+
+// Обрабатывает сообщения Windows, через которые COM доставляет события,
+// и периодически проверяет, что Outlook доступен
+func (s *outlookSubscription) pump(ctx context.Context) error {
+	var msg winMsg
+	lastCheck := time.Now()
+
+	for ctx.Err() == nil {
+		// Ждем сообщений не дольше 500 мс, чтобы вовремя заметить отмену ctx
+		procMsgWaitForMultipleObjects.Call(0, 0, 0, 500, qsAllInput)
+		for {
+			r, _, _ := procPeekMessageW.Call(uintptr(unsafe.Pointer(&msg)), 0, 0, 0, pmRemove)
+			if r == 0 {
+				break
+			}
+			procTranslateMessage.Call(uintptr(unsafe.Pointer(&msg)))
+			procDispatchMessageW.Call(uintptr(unsafe.Pointer(&msg)))
+		}
+
+		if time.Since(lastCheck) >= eventHealthCheck {
+			lastCheck = time.Now()
+			if _, err := oleutil.GetProperty(s.ns, "CurrentProfileName"); err != nil {
+				return fmt.Errorf("Outlook не отвечает: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Приемник событий COM: минимальная реализация IDispatch, которая передает
+// номер события и его параметры в обработчик
+type eventSink struct {
+	vtbl    *eventSinkVtbl
+	ref     int32
+	iid     *ole.GUID
+	handler func(dispid int32, args []ole.VARIANT)
+}
+
+type eventSinkVtbl struct {
+	queryInterface   uintptr
+	addRef           uintptr
+	release          uintptr
+	getTypeInfoCount uintptr
+	getTypeInfo      uintptr
+	getIDsOfNames    uintptr
+	invoke           uintptr
+}
+
+// Раскладка DISPPARAMS (в go-ole поля структуры не экспортируются)
+type dispParams struct {
+	rgvarg            *ole.VARIANT
+	rgdispidNamedArgs uintptr
+	cArgs             uint32
+	cNamedArgs        uint32
+}
+
+// Таблица методов создается один раз: число обратных вызовов syscall ограничено
+var sinkVtbl = &eventSinkVtbl{
+	queryInterface:   syscall.NewCallback(sinkQueryInterface),
+	addRef:           syscall.NewCallback(sinkAddRef),
+	release:          syscall.NewCallback(sinkRelease),
+	getTypeInfoCount: syscall.NewCallback(sinkGetTypeInfoCount),
+	getTypeInfo:      syscall.NewCallback(sinkNotImplemented),
+	getIDsOfNames:    syscall.NewCallback(sinkNotImplemented),
+	invoke:           syscall.NewCallback(sinkInvoke),
+}
+
+func newEventSink(iid *ole.GUID, handler func(int32, []ole.VARIANT)) *eventSink {
+	return &eventSink{vtbl: sinkVtbl, ref: 1, iid: iid, handler: handler}
+}
+
+func sinkQueryInterface(this *eventSink, iid *ole.GUID, object **eventSink) uintptr {
+	if ole.IsEqualGUID(iid, ole.IID_IUnknown) || ole.IsEqualGUID(iid, ole.IID_IDispatch) || ole.IsEqualGUID(iid, this.iid) {
+		atomic.AddInt32(&this.ref, 1)
+		*object = this
+		return ole.S_OK
+	}
+	*object = nil
+	return ole.E_NOINTERFACE
+}
+
+func sinkAddRef(this *eventSink) uintptr {
+	return uintptr(atomic.AddInt32(&this.ref, 1))
+}
+
+func sinkRelease(this *eventSink) uintptr {
+	return uintptr(atomic.AddInt32(&this.ref, -1))
+}
+
+func sinkGetTypeInfoCount(this *eventSink, count *uint32) uintptr {
+	*count = 0
+	return ole.S_OK
+}
+
+func sinkNotImplemented(this *eventSink, a, b, c, d, e uintptr) uintptr {
+	return ole.E_NOTIMPL
+}
+
+func sinkInvoke(this *eventSink, dispid uintptr, riid, lcid, flags uintptr, params *dispParams, result, excepInfo, argErr uintptr) uintptr {
+	defer func() {
+		if r := recover(); r != nil {
+			logMessage("Ошибка обработки события Outlook: %v", r)
+		}
+	}()
+
+	var args []ole.VARIANT
+	if params != nil && params.cArgs > 0 {
+		// Параметры передаются в обратном порядке
+		args = unsafe.Slice(params.rgvarg, params.cArgs)
+	}
+	this.handler(int32(dispid), args)
+	return ole.S_OK
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+
+	"otn/folderref"
+)
+
+// Дерево папок Outlook для поиска папок по ссылкам (пакет folderref)
+type outlookTree struct {
+	ns *ole.IDispatch
+}
+
+type outlookStore struct {
+	d *ole.IDispatch
+}
+
+type outlookFolder struct {
+	d *ole.IDispatch
+}
+
+func (t outlookTree) Stores() ([]folderref.Store, error) {
+	storesVar, err := oleutil.GetProperty(t.ns, "Stores")
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка хранилищ: %v", err)
+	}
+	stores := storesVar.ToIDispatch()
+	defer stores.Release()
+
+	count := int(oleutil.MustGetProperty(stores, "Count").Val)
+	result := make([]folderref.Store, 0, count)
+	for i := 1; i <= count; i++ {
+		storeVar, err := oleutil.CallMethod(stores, "Item", i)
+		if err != nil {
+			continue
+		}
+		result = append(result, outlookStore{storeVar.ToIDispatch()})
+	}
+	return result, nil
+}
+
+func (t outlookTree) DefaultFolder(id int) (folderref.Folder, error) {
+	folderVar, err := oleutil.CallMethod(t.ns, "GetDefaultFolder", id)
+	if err != nil {
+		return nil, err
+	}
+	return outlookFolder{folderVar.ToIDispatch()}, nil
+}
+
+func (s outlookStore) DisplayName() string {
+	return oleutil.MustGetProperty(s.d, "DisplayName").ToString()
+}
+
+func (s outlookStore) Root() (folderref.Folder, error) {
+	rootVar, err := oleutil.CallMethod(s.d, "GetRootFolder")
+	if err != nil {
+		return nil, err
+	}
+	return outlookFolder{rootVar.ToIDispatch()}, nil
+}
+
+func (s outlookStore) DefaultFolder(id int) (folderref.Folder, error) {
+	folderVar, err := oleutil.CallMethod(s.d, "GetDefaultFolder", id)
+	if err != nil {
+		return nil, err
+	}
+	return outlookFolder{folderVar.ToIDispatch()}, nil
+}
+
+func (s outlookStore) Release() { s.d.Release() }
+
+func (f outlookFolder) Name() string {
+	return oleutil.MustGetProperty(f.d, "Name").ToString()
+}
+
+func (f outlookFolder) Subfolders() ([]folderref.Folder, error) {
+	foldersVar, err := oleutil.GetProperty(f.d, "Folders")
+	if err != nil {
+		return nil, err
+	}
+	folders := foldersVar.ToIDispatch()
+	defer folders.Release()
+
+	count := int(oleutil.MustGetProperty(folders, "Count").Val)
+	result := make([]folderref.Folder, 0, count)
+	for i := 1; i <= count; i++ {
+		result = append(result, outlookFolder{oleutil.MustCallMethod(folders, "Item", i).ToIDispatch()})
+	}
+	return result, nil
+}
+
+func (f outlookFolder) Release() { f.d.Release() }
+
+// Находит папку по ссылке: пути, имени хранилища, специальной папке (@inbox) или имени
+func getFolder(ns *ole.IDispatch, name string) (*ole.IDispatch, error) {
+	folder, err := folderref.Resolve(outlookTree{ns}, name)
+	if err != nil {
+		return nil, err
+	}
+	return folder.(outlookFolder).d, nil
+}
+
+// Находит папку из конфигурации. Если указан entry_id, папка берется по идентификатору,
+// а при неудаче - по имени
+func getConfiguredFolder(ns *ole.IDispatch, folderCfg Folder) (*ole.IDispatch, error) {
+	if folderCfg.EntryID != "" {
+		folderVar, err := oleutil.CallMethod(ns, "GetFolderFromID", folderCfg.EntryID, folderCfg.StoreID)
+		if err == nil {
+			return folderVar.ToIDispatch(), nil
+		}
+		logMessage("Папка %s не найдена по entry_id, поиск по имени: %v", folderCfg.Name, err)
+	}
+	return getFolder(ns, folderCfg.Name)
+}
@@ -0,0 +1,201 @@
+// Пакет ratelimit ограничивает частоту сообщений по алгоритму token bucket:
+// общий лимит на все сообщения и свои лимиты для каждого ключа (чата).
+//
+// Лимитер не отклоняет сообщения, а резервирует их: Reserve сразу забирает
+// токен и возвращает, сколько нужно подождать перед отправкой. Поэтому
+// сообщения в один чат уходят равномерно и по порядку, а не пачкой с
+// последующими ошибками 429.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Rate - не больше Count сообщений за Per. Count - также наибольшая пачка
+// сообщений, которую можно отправить без пауз
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// Лимит не задан
+func (r Rate) zero() bool {
+	return r.Count <= 0 || r.Per <= 0
+}
+
+// Корзина токенов. tokens может быть отрицательным: это уже выданные
+// резервы, которые еще ждут своей очереди. last может быть в будущем:
+// корзина приостановлена и начнет пополняться с этого момента
+type bucket struct {
+	rate   float64 // Токенов в секунду
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(r Rate, now time.Time) *bucket {
+	return &bucket{
+		rate:   float64(r.Count) / r.Per.Seconds(),
+		burst:  float64(r.Count),
+		tokens: float64(r.Count),
+		last:   now,
+	}
+}
+
+// Пополняет корзину за время, прошедшее с прошлого обращения
+func (b *bucket) advance(now time.Time) {
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// Время до появления токена
+func (b *bucket) delay(now time.Time) time.Duration {
+	b.advance(now)
+	var wait time.Duration
+	if b.last.After(now) {
+		wait = b.last.Sub(now)
+	}
+	if b.tokens < 1 {
+		wait += time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	}
+	return wait
+}
+
+// Приостанавливает корзину до until: новые резервы получат время после
+// паузы и пойдут с обычной частотой, а не пачкой
+func (b *bucket) pause(now, until time.Time) {
+	b.advance(now)
+	if !until.After(b.last) {
+		return
+	}
+	if b.tokens > 1 {
+		b.tokens = 1
+	}
+	b.last = until
+}
+
+// Limiter - общий лимит и лимиты по ключам
+type Limiter struct {
+	mutex  sync.Mutex
+	global *bucket
+	rates  func(key string) []Rate
+	keys   map[string][]*bucket
+}
+
+// New создает лимитер с общим лимитом global (нулевой Rate - без общего
+// лимита). rates возвращает лимиты ключа; он вызывается при первом
+// обращении к ключу
+func New(global Rate, rates func(key string) []Rate) *Limiter {
+	l := &Limiter{rates: rates, keys: make(map[string][]*bucket)}
+	if !global.zero() {
+		l.global = newBucket(global, time.Now())
+	}
+	return l
+}
+
+// Корзины, которые участвуют в отправке по ключу
+func (l *Limiter) buckets(key string, now time.Time) []*bucket {
+	keyBuckets, ok := l.keys[key]
+	if !ok {
+		if l.rates != nil {
+			for _, r := range l.rates(key) {
+				if !r.zero() {
+					keyBuckets = append(keyBuckets, newBucket(r, now))
+				}
+			}
+		}
+		l.keys[key] = keyBuckets
+	}
+	if l.global == nil {
+		return keyBuckets
+	}
+	return append([]*bucket{l.global}, keyBuckets...)
+}
+
+// Наибольшая пауза по корзинам
+func maxDelay(buckets []*bucket, now time.Time) time.Duration {
+	var wait time.Duration
+	for _, b := range buckets {
+		if d := b.delay(now); d > wait {
+			wait = d
+		}
+	}
+	return wait
+}
+
+// Reserve резервирует отправку сообщения по ключу key и возвращает паузу,
+// после которой его можно отправить
+func (l *Limiter) Reserve(key string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	buckets := l.buckets(key, now)
+	wait := maxDelay(buckets, now)
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return wait
+}
+
+// Delay возвращает паузу, которую получило бы сообщение по ключу key,
+// ничего не резервируя
+func (l *Limiter) Delay(key string, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return maxDelay(l.buckets(key, now), now)
+}
+
+// Wait резервирует отправку по ключу key и ждет своей очереди или отмены ctx
+func (l *Limiter) Wait(ctx context.Context, key string) error {
+	wait := l.Reserve(key, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Pause запрещает отправку по ключу key на время d, например после ответа
+// 429 с указанной сервером паузой. Пустой key приостанавливает все отправки
+func (l *Limiter) Pause(key string, d time.Duration, now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var buckets []*bucket
+	if key == "" {
+		if l.global != nil {
+			buckets = []*bucket{l.global}
+		}
+	} else {
+		l.buckets(key, now)
+		buckets = l.keys[key]
+	}
+	for _, b := range buckets {
+		b.pause(now, now.Add(d))
+	}
+}
+
+// Waits возвращает текущую паузу для каждого ключа, по которому уже были отправки
+func (l *Limiter) Waits(now time.Time) map[string]time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	waits := make(map[string]time.Duration, len(l.keys))
+	for key := range l.keys {
+		waits[key] = maxDelay(l.buckets(key, now), now)
+	}
+	return waits
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func perSecond(key string) []Rate {
+	return []Rate{{Count: 1, Per: time.Second}}
+}
+
+func TestReserveSpacing(t *testing.T) {
+	l := New(Rate{}, perSecond)
+	now := time.Now()
+
+	// Сообщения в один чат расходятся на секунду, другой чат не ждет
+	for i, want := range []time.Duration{0, time.Second, 2 * time.Second} {
+		if got := l.Reserve("a", now); got != want {
+			t.Errorf("резерв %d: %v, want %v", i, got, want)
+		}
+	}
+	if got := l.Reserve("b", now); got != 0 {
+		t.Errorf("другой чат: %v, want 0", got)
+	}
+
+	// Через полторы секунды первое сообщение ушло, очередь сдвинулась
+	if got := l.Reserve("a", now.Add(1500*time.Millisecond)); got != 1500*time.Millisecond {
+		t.Errorf("после паузы: %v", got)
+	}
+}
+
+func TestGlobalLimit(t *testing.T) {
+	l := New(Rate{Count: 2, Per: time.Second}, perSecond)
+	now := time.Now()
+
+	l.Reserve("a", now)
+	l.Reserve("b", now)
+	if got := l.Reserve("c", now); got != 500*time.Millisecond {
+		t.Errorf("третий чат: %v, want 500ms", got)
+	}
+}
+
+func TestGroupLimit(t *testing.T) {
+	l := New(Rate{}, func(key string) []Rate {
+		return []Rate{{Count: 1, Per: time.Second}, {Count: 3, Per: time.Minute}}
+	})
+	now := time.Now()
+
+	// Три сообщения раз в секунду, четвертое ждет пополнения минутного лимита
+	for i := 0; i < 3; i++ {
+		l.Reserve("-100", now.Add(time.Duration(i)*time.Second))
+	}
+	got := l.Reserve("-100", now.Add(3*time.Second))
+	if want := 17 * time.Second; got < want-time.Millisecond || got > want+time.Millisecond {
+		t.Errorf("четвертое сообщение: %v, want %v", got, want)
+	}
+}
+
+func TestDelayDoesNotReserve(t *testing.T) {
+	l := New(Rate{}, perSecond)
+	now := time.Now()
+
+	if got := l.Delay("a", now); got != 0 {
+		t.Errorf("Delay = %v", got)
+	}
+	l.Reserve("a", now)
+	for i := 0; i < 2; i++ {
+		if got := l.Delay("a", now); got != time.Second {
+			t.Errorf("Delay = %v, want 1s", got)
+		}
+	}
+	waits := l.Waits(now)
+	if len(waits) != 1 || waits["a"] != time.Second {
+		t.Errorf("Waits = %v", waits)
+	}
+}
+
+func TestPause(t *testing.T) {
+	l := New(Rate{Count: 30, Per: time.Second}, perSecond)
+	now := time.Now()
+
+	l.Pause("a", 10*time.Second, now)
+	if got := l.Reserve("a", now); got != 10*time.Second {
+		t.Errorf("после Pause: %v, want 10s", got)
+	}
+	// После паузы сообщения снова идут раз в секунду, а не пачкой
+	if got := l.Reserve("a", now); got != 11*time.Second {
+		t.Errorf("второе сообщение: %v, want 11s", got)
+	}
+	if got := l.Reserve("b", now); got != 0 {
+		t.Errorf("другой чат: %v, want 0", got)
+	}
+
+	l.Pause("", 5*time.Second, now)
+	if got := l.Reserve("b", now); got != 5*time.Second {
+		t.Errorf("общая пауза: %v, want 5s", got)
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	l := New(Rate{}, func(key string) []Rate {
+		return []Rate{{Count: 1, Per: time.Hour}}
+	})
+	if err := l.Wait(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx, "a"); err == nil {
+		t.Error("ожидалась ошибка отмены")
+	}
+}
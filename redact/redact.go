@@ -0,0 +1,139 @@
+// Пакет redact скрывает чувствительные данные (номера карт, IBAN, телефоны,
+// адреса почты, пароли) в тексте перед отправкой во внешний мессенджер.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rule - пользовательское правило: регулярное выражение и строка замены.
+// В замене можно ссылаться на группы: "$1".
+type Rule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Config - настройки скрытия данных
+type Config struct {
+	Presets []string `json:"presets"` // Встроенные наборы: "card", "iban", "phone", "email"
+	Rules   []Rule   `json:"rules"`   // Пользовательские правила, применяются первыми
+}
+
+type compiledRule struct {
+	re          *regexp.Regexp
+	replacement string
+	check       func(match string) bool // Дополнительная проверка совпадения
+}
+
+// Встроенные наборы правил
+var presets = map[string]compiledRule{
+	"iban": {
+		re:          regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,4})?\b`),
+		replacement: "[IBAN скрыт]",
+	},
+	"card": {
+		re:          regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		replacement: "[номер карты скрыт]",
+		check:       luhnValid,
+	},
+	"email": {
+		re:          regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		replacement: "[email скрыт]",
+	},
+	"phone": {
+		re:          regexp.MustCompile(`(?:\+\d{1,3}|\b8)[ (.-]*\d{3,4}[ ).-]*\d{2,3}[ .-]*\d{2}[ .-]*\d{2}\b`),
+		replacement: "[телефон скрыт]",
+	},
+}
+
+// Порядок применения встроенных наборов: номера карт и IBAN раньше телефонов,
+// чтобы длинные номера не распознавались как телефоны
+var presetOrder = []string{"iban", "card", "email", "phone"}
+
+// Redactor - подготовленный набор правил
+type Redactor struct {
+	rules []compiledRule
+}
+
+// New компилирует правила. Неизвестные наборы и некорректные выражения - ошибка.
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{}
+
+	for i, rule := range cfg.Rules {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("некорректное выражение в правиле %d: %v", i, err)
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = "***"
+		}
+		r.rules = append(r.rules, compiledRule{re: re, replacement: replacement})
+	}
+
+	enabled := make(map[string]bool, len(cfg.Presets))
+	for _, name := range cfg.Presets {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := presets[name]; !ok {
+			return nil, fmt.Errorf("неизвестный набор правил: %s (допустимы: card, iban, phone, email)", name)
+		}
+		enabled[name] = true
+	}
+	for _, name := range presetOrder {
+		if enabled[name] {
+			r.rules = append(r.rules, presets[name])
+		}
+	}
+
+	return r, nil
+}
+
+// Redact применяет правила к тексту и возвращает результат и число замен.
+func (r *Redactor) Redact(text string) (string, int) {
+	if r == nil || text == "" {
+		return text, 0
+	}
+
+	count := 0
+	for _, rule := range r.rules {
+		rule := rule
+		text = rule.re.ReplaceAllStringFunc(text, func(match string) string {
+			if rule.check != nil && !rule.check(match) {
+				return match
+			}
+			count++
+			// Expand нужен для поддержки ссылок на группы в замене
+			submatches := rule.re.FindStringSubmatchIndex(match)
+			if submatches == nil {
+				return rule.replacement
+			}
+			return string(rule.re.ExpandString(nil, rule.replacement, match, submatches))
+		})
+	}
+	return text, count
+}
+
+// Проверка номера карты по алгоритму Луна
+func luhnValid(number string) bool {
+	sum, digits := 0, 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		digits++
+		double = !double
+	}
+	return digits >= 13 && sum%10 == 0
+}
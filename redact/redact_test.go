@@ -0,0 +1,142 @@
+package redact
+
+import "testing"
+
+func newRedactor(t *testing.T, cfg Config) *Redactor {
+	t.Helper()
+	r, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestPresets(t *testing.T) {
+	tests := []struct {
+		preset string
+		text   string
+		want   string
+	}{
+		// IBAN
+		{"iban", "Счет DE89 3704 0044 0532 0130 00 для оплаты", "Счет [IBAN скрыт] для оплаты"},
+		{"iban", "IBAN: GB82WEST12345698765432", "IBAN: [IBAN скрыт]"},
+		{"iban", "Заказ XY12 ABCD готов", "Заказ XY12 ABCD готов"},
+		{"iban", "de89 3704 0044 0532 0130 00", "de89 3704 0044 0532 0130 00"},
+
+		// Номера карт: только прошедшие проверку по алгоритму Луна
+		{"card", "Карта 4111 1111 1111 1111 оплачена", "Карта [номер карты скрыт] оплачена"},
+		{"card", "Карта 5500-0000-0000-0004", "Карта [номер карты скрыт]"},
+		{"card", "Карта 4111111111111111.", "Карта [номер карты скрыт]."},
+		{"card", "Заявка 4111 1111 1111 1112", "Заявка 4111 1111 1111 1112"},
+		{"card", "Счет 1234567890", "Счет 1234567890"},
+
+		// Телефоны
+		{"phone", "Звоните +7 (495) 123-45-67 днем", "Звоните [телефон скрыт] днем"},
+		{"phone", "Тел. 8 800 555 35 35", "Тел. [телефон скрыт]"},
+		{"phone", "Тел.: +1 212 555 12 34", "Тел.: [телефон скрыт]"},
+		{"phone", "Версия 8.1, сборка 12345", "Версия 8.1, сборка 12345"},
+		{"phone", "Заказ 78001234567", "Заказ 78001234567"},
+
+		// Адреса почты
+		{"email", "Пишите ivan.petrov+ops@mail.example.com.", "Пишите [email скрыт]."},
+		{"email", "Адрес user@localhost и @example.com", "Адрес user@localhost и @example.com"},
+	}
+
+	for _, tt := range tests {
+		r := newRedactor(t, Config{Presets: []string{tt.preset}})
+		got, count := r.Redact(tt.text)
+		if got != tt.want {
+			t.Errorf("%s: Redact(%q) = %q, want %q", tt.preset, tt.text, got, tt.want)
+		}
+		wantCount := 1
+		if tt.want == tt.text {
+			wantCount = 0
+		}
+		if count != wantCount {
+			t.Errorf("%s: Redact(%q): замен %d, want %d", tt.preset, tt.text, count, wantCount)
+		}
+	}
+}
+
+func TestPresetOrder(t *testing.T) {
+	// Номер карты не должен частично скрываться как телефон
+	r := newRedactor(t, Config{Presets: []string{"phone", "card", "email", "iban"}})
+	got, count := r.Redact("Карта 4111 1111 1111 1111, тел. +7 495 123-45-67, почта a@example.com")
+	want := "Карта [номер карты скрыт], тел. [телефон скрыт], почта [email скрыт]"
+	if got != want || count != 3 {
+		t.Errorf("Redact = %q (%d), want %q (3)", got, count, want)
+	}
+}
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rule
+		text  string
+		want  string
+	}{
+		{"замена", []Rule{{Pattern: `(?i)пароль:\s*\S+`, Replacement: "пароль: ***"}},
+			"Логин admin, Пароль: Qwerty123", "Логин admin, пароль: ***"},
+		{"группа", []Rule{{Pattern: `(логин|login):\s*\S+`, Replacement: "$1: [скрыт]"}},
+			"login: admin\nлогин: root", "login: [скрыт]\nлогин: [скрыт]"},
+		{"пустая замена", []Rule{{Pattern: `token=\w+`}},
+			"https://example.com/?token=abc123&x=1", "https://example.com/?***&x=1"},
+		{"нет совпадений", []Rule{{Pattern: `secret-\d+`}},
+			"secret-код не указан", "secret-код не указан"},
+	}
+
+	for _, tt := range tests {
+		r := newRedactor(t, Config{Rules: tt.rules})
+		if got, _ := r.Redact(tt.text); got != tt.want {
+			t.Errorf("%s: Redact(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRulesBeforePresets(t *testing.T) {
+	r := newRedactor(t, Config{
+		Presets: []string{"email"},
+		Rules:   []Rule{{Pattern: `[a-z]+@corp\.example\.com`, Replacement: "[сотрудник]"}},
+	})
+	got, count := r.Redact("ivan@corp.example.com, partner@example.org")
+	if want := "[сотрудник], [email скрыт]"; got != want || count != 2 {
+		t.Errorf("Redact = %q (%d), want %q (2)", got, count, want)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(Config{Presets: []string{"passport"}}); err == nil {
+		t.Error("неизвестный набор должен быть ошибкой")
+	}
+	if _, err := New(Config{Rules: []Rule{{Pattern: `(`}}}); err == nil {
+		t.Error("некорректное выражение должно быть ошибкой")
+	}
+	if _, err := New(Config{Presets: []string{" Card "}}); err != nil {
+		t.Errorf("имя набора без учета регистра и пробелов: %v", err)
+	}
+}
+
+func TestNilRedactor(t *testing.T) {
+	var r *Redactor
+	if got, count := r.Redact("4111 1111 1111 1111"); got != "4111 1111 1111 1111" || count != 0 {
+		t.Errorf("Redact = %q, %d", got, count)
+	}
+}
+
+func TestLuhn(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"79927398713", false}, // Верная контрольная сумма, но слишком короткий номер
+		{"4111111111111112", false},
+		{"6011 0009 9013 9424", true},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.number); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
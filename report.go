@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-ole/go-ole"
+	"github.com/go-ole/go-ole/oleutil"
+	"github.com/scjalliance/comshim"
+
+	"otn/cron"
+)
+
+// Настройки периодической сводки
+type ReportConfig struct {
+	Name        string   `json:"name"`
+	Schedule    string   `json:"schedule"`     // Расписание в формате cron, например "0 9 * * 1-5"
+	PeriodHours int      `json:"period_hours"` // За какой период строить сводку (по умолчанию 24 часа)
+	ChatID      string   `json:"chat_id"`      // Куда отправлять (по умолчанию default_chat_id)
+	TopSenders  int      `json:"top_senders"`  // Сколько отправителей показывать (по умолчанию 5)
+	Folders     []string `json:"folders"`      // Ограничить сводку папками (по умолчанию все)
+}
+
+const (
+	defaultReportPeriodHours = 24
+	defaultReportTopSenders  = 5
+	maxReportUnreadLines     = 20
+)
+
+// Проверка настроек сводок
+func validateReports() error {
+	for i, report := range config.Reports {
+		if _, err := cron.Parse(report.Schedule); err != nil {
+			return fmt.Errorf("Некорректное расписание в сводке %d: %v", i, err)
+		}
+		if report.PeriodHours < 0 || report.PeriodHours > 24*31 {
+			return fmt.Errorf("PeriodHours в сводке %d должно быть в диапазоне от 0 до 744", i)
+		}
+		if report.TopSenders < 0 || report.TopSenders > 50 {
+			return fmt.Errorf("TopSenders в сводке %d должно быть в диапазоне от 0 до 50", i)
+		}
+		if !isValidChatID(report.ChatID) {
+			return fmt.Errorf("Некорректный ChatID в сводке %d", i)
+		}
+	}
+	return nil
+}
+
+// Планировщик сводок: раз в минуту проверяет расписания
+func runReports(ctx context.Context) {
+	if len(config.Reports) == 0 {
+		return
+	}
+
+	schedules := make([]*cron.Schedule, len(config.Reports))
+	for i, report := range config.Reports {
+		schedules[i], _ = cron.Parse(report.Schedule) // Расписания уже проверены в validateConfig
+	}
+
+	logMessage("Планировщик сводок запущен, сводок: %d", len(config.Reports))
+
+	for {
+		// Ждем начала следующей минуты
+		now := time.Now()
+		next := now.Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+
+		for i, report := range config.Reports {
+			if schedules[i].Matches(next) {
+				report := report
+				safeGo(func() {
+					sendReport(report, next)
+				})
+			}
+		}
+	}
+}
+
+// Строит и отправляет сводку
+func sendReport(report ReportConfig, now time.Time) {
+	if history == nil {
+		return
+	}
+
+	period := report.PeriodHours
+	if period == 0 {
+		period = defaultReportPeriodHours
+	}
+
+	records := history.between(now.Add(-time.Duration(period)*time.Hour), now)
+	if len(report.Folders) > 0 {
+		records = filterRecordsByFolder(records, report.Folders)
+	}
+
+	unread := unreadRecords(records)
+	message := formatReport(report, period, records, unread)
+
+	chatID := report.ChatID
+	if chatID == "" || chatID == "0" {
+		chatID = config.Telegram.DefaultChatID
+	}
+
+	if _, err := sendTelegramMessage(message, chatID); err != nil {
+		logMessage("Ошибка отправки сводки '%s': %v", report.Name, err)
+	} else {
+		logMessage("Сводка '%s' отправлена", report.Name)
+	}
+}
+
+func filterRecordsByFolder(records []HistoryRecord, folders []string) []HistoryRecord {
+	allowed := make(map[string]bool, len(folders))
+	for _, f := range folders {
+		allowed[f] = true
+	}
+
+	var result []HistoryRecord
+	for _, rec := range records {
+		if allowed[rec.Folder] {
+			result = append(result, rec)
+		}
+	}
+	return result
+}
+
+func formatReport(report ReportConfig, period int, records []HistoryRecord, unread []HistoryRecord) string {
+	var msg strings.Builder
+
+	title := report.Name
+	if title == "" {
+		title = "Сводка"
+	}
+	if config.Telegram.UseEmojis {
+		msg.WriteString("📊 ")
+	}
+	msg.WriteString(fmt.Sprintf("<b>%s</b> за %d ч\n", html.EscapeString(title), period))
+	msg.WriteString(fmt.Sprintf("Всего писем: %d\n", len(records)))
+
+	if len(records) == 0 {
+		return msg.String()
+	}
+
+	// Количество писем по папкам
+	byFolder := make(map[string]int)
+	bySender := make(map[string]int)
+	for _, rec := range records {
+		byFolder[rec.Folder]++
+		bySender[rec.Sender]++
+	}
+
+	msg.WriteString("\n")
+	if config.Telegram.UseEmojis {
+		msg.WriteString("📥 ")
+	}
+	msg.WriteString("<b>По папкам:</b>\n")
+	for _, entry := range sortCounts(byFolder) {
+		msg.WriteString(fmt.Sprintf("• %s: %d\n", html.EscapeString(entry.key), entry.count))
+	}
+
+	// Топ отправителей
+	top := report.TopSenders
+	if top == 0 {
+		top = defaultReportTopSenders
+	}
+	senders := sortCounts(bySender)
+	if len(senders) > top {
+		senders = senders[:top]
+	}
+
+	msg.WriteString("\n")
+	if config.Telegram.UseEmojis {
+		msg.WriteString("👤 ")
+	}
+	msg.WriteString("<b>Топ отправителей:</b>\n")
+	for i, entry := range senders {
+		msg.WriteString(fmt.Sprintf("%d. %s — %d\n", i+1, html.EscapeString(entry.key), entry.count))
+	}
+
+	// Непрочитанные письма
+	msg.WriteString("\n")
+	if config.Telegram.UseEmojis {
+		msg.WriteString("📬 ")
+	}
+	msg.WriteString(fmt.Sprintf("<b>Не прочитано:</b> %d\n", len(unread)))
+	for i, rec := range unread {
+		if i == maxReportUnreadLines {
+			msg.WriteString(fmt.Sprintf("… и еще %d\n", len(unread)-maxReportUnreadLines))
+			break
+		}
+		msg.WriteString(fmt.Sprintf("• [%s] %s — %s\n",
+			html.EscapeString(rec.Folder),
+			html.EscapeString(rec.Sender),
+			html.EscapeString(truncateByRunes(rec.Subject, 80))))
+	}
+
+	return truncateByRunes(msg.String(), 4000)
+}
+
+type countEntry struct {
+	key   string
+	count int
+}
+
+// Сортирует счетчики по убыванию, при равенстве - по имени
+func sortCounts(counts map[string]int) []countEntry {
+	entries := make([]countEntry, 0, len(counts))
+	for k, v := range counts {
+		entries = append(entries, countEntry{k, v})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
+
+// Проверяет в Outlook, какие из писем все еще не прочитаны
+func unreadRecords(records []HistoryRecord) (unread []HistoryRecord) {
+	if len(records) == 0 {
+		return nil
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			logMessage("Ошибка проверки непрочитанных писем: %v", r)
+		}
+	}()
+
+	comshim.Add(1)
+	defer comshim.Done()
+
+	outlook, ns, err := initializeOutlook()
+	if err != nil {
+		logMessage("Ошибка инициализации Outlook для сводки: %v", err)
+		return nil
+	}
+	defer releaseObjects(outlook, ns)
+
+	for _, rec := range records {
+		if rec.EntryID == "" {
+			continue
+		}
+		if isItemUnread(ns, rec.EntryID) {
+			unread = append(unread, rec)
+		}
+	}
+	return unread
+}
+
+func isItemUnread(ns *ole.IDispatch, entryID string) bool {
+	itemVar, err := oleutil.CallMethod(ns, "GetItemFromID", entryID)
+	if err != nil {
+		return false // Письмо удалено или перемещено в другое хранилище
+	}
+	item := itemVar.ToIDispatch()
+	defer item.Release()
+
+	unreadVar, err := oleutil.GetProperty(item, "UnRead")
+	if err != nil {
+		return false
+	}
+	return unreadVar.Value() == true
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"otn/mailsource/graphsource"
+	"otn/mailsource/imapsource"
+)
+
+// Типы источников писем
+const (
+	sourceOutlook = "outlook"
+	sourceIMAP    = "imap"
+	sourceGraph   = "graph"
+	sourceDrop    = "drop"
+)
+
+// Пауза перед перезапуском упавшего источника: удваивается после каждого
+// сбоя подряд до sourceRestartMax
+const (
+	sourceRestartMin = 5 * time.Second
+	sourceRestartMax = 5 * time.Minute
+)
+
+// SourceConfig - источник писем из раздела sources
+type SourceConfig struct {
+	Name                string              `json:"name"`
+	Type                string              `json:"type"`                  // outlook, imap, graph, drop
+	Enabled             *bool               `json:"enabled"`               // По умолчанию true
+	PollIntervalSeconds int                 `json:"poll_interval_seconds"` // 0 - интервал по умолчанию для типа источника
+	Profile             string              `json:"profile"`               // outlook: профиль, с которым запускается Outlook
+	IMAP                *imapsource.Config  `json:"imap"`                  // imap: учетная запись (name берется из источника)
+	Graph               *graphsource.Config `json:"graph"`                 // graph: почтовый ящик (name берется из источника)
+	Folders             []Folder            `json:"folders"`
+}
+
+func (c SourceConfig) enabled() bool {
+	return c.Enabled == nil || *c.Enabled
+}
+
+// Источник писем после разбора настроек. Папки верхнего уровня без раздела
+// sources попадают в неявные источники: outlook, imap:<учетная запись>,
+// graph:<учетная запись> и drop
+type configuredSource struct {
+	name     string
+	kind     string
+	enabled  bool
+	interval time.Duration // 0 - интервал по умолчанию
+	profile  string
+	account  string // Учетная запись IMAP или Graph
+}
+
+var (
+	configuredSources []*configuredSource
+	outlookSourceName string // Имя источника Outlook (он может быть только один)
+)
+
+func findSource(name string) *configuredSource {
+	for _, src := range configuredSources {
+		if src.name == name {
+			return src
+		}
+	}
+	return nil
+}
+
+// Разбирает раздел sources: папки источников добавляются в config.Folders, а
+// учетные записи - в imap_accounts и graph_accounts. Затем каждой папке
+// назначается источник, папки выключенных источников не отслеживаются
+func setupSources() error {
+	configuredSources = nil
+	outlookSourceName = ""
+	imapOwners := make(map[string]string)  // Учетная запись IMAP -> источник
+	graphOwners := make(map[string]string) // Учетная запись Graph -> источник
+
+	addSource := func(name, kind string, enabled bool) (*configuredSource, error) {
+		for _, src := range configuredSources {
+			if strings.EqualFold(src.name, name) {
+				return nil, fmt.Errorf("Повторяющееся имя источника: %s", name)
+			}
+		}
+		src := &configuredSource{name: name, kind: kind, enabled: enabled}
+		configuredSources = append(configuredSources, src)
+		return src, nil
+	}
+
+	var folders []Folder
+	for i, cfg := range config.Sources {
+		if cfg.Name == "" || len(cfg.Name) > 100 {
+			return fmt.Errorf("Имя источника %d должно быть текстом длиной от 1 до 100 символов", i)
+		}
+		switch cfg.Type {
+		case sourceOutlook, sourceIMAP, sourceGraph, sourceDrop:
+		default:
+			return fmt.Errorf("Неизвестный тип источника %s: %q (допустимы: %s, %s, %s, %s)",
+				cfg.Name, cfg.Type, sourceOutlook, sourceIMAP, sourceGraph, sourceDrop)
+		}
+		if cfg.PollIntervalSeconds < 0 || cfg.PollIntervalSeconds > 86400 {
+			return fmt.Errorf("poll_interval_seconds источника %s должно быть в диапазоне от 0 до 86400", cfg.Name)
+		}
+		switch {
+		case cfg.Profile != "" && cfg.Type != sourceOutlook:
+			return fmt.Errorf("profile используется только для источника outlook (%s)", cfg.Name)
+		case (cfg.IMAP != nil) != (cfg.Type == sourceIMAP):
+			return fmt.Errorf("Блок imap нужен только источнику с type: imap (%s)", cfg.Name)
+		case (cfg.Graph != nil) != (cfg.Type == sourceGraph):
+			return fmt.Errorf("Блок graph нужен только источнику с type: graph (%s)", cfg.Name)
+		case cfg.Type == sourceOutlook && outlookSourceName != "":
+			return fmt.Errorf("Источник outlook может быть только один")
+		}
+
+		src, err := addSource(cfg.Name, cfg.Type, cfg.enabled())
+		if err != nil {
+			return err
+		}
+		src.interval = time.Duration(cfg.PollIntervalSeconds) * time.Second
+		src.profile = cfg.Profile
+
+		switch cfg.Type {
+		case sourceOutlook:
+			outlookSourceName = cfg.Name
+			// Опрос Outlook выполняет общий цикл, его интервал задает check_interval_seconds
+			if cfg.PollIntervalSeconds > 1000 {
+				return fmt.Errorf("poll_interval_seconds источника outlook должно быть в диапазоне от 0 до 1000")
+			}
+			if cfg.PollIntervalSeconds > 0 && src.enabled {
+				config.CheckIntervalSeconds = cfg.PollIntervalSeconds
+			}
+		case sourceIMAP:
+			src.account = cfg.Name
+			imapOwners[cfg.Name] = cfg.Name
+			if src.enabled {
+				account := *cfg.IMAP
+				account.Name = cfg.Name
+				config.IMAPAccounts = append(config.IMAPAccounts, account)
+			}
+		case sourceGraph:
+			src.account = cfg.Name
+			graphOwners[cfg.Name] = cfg.Name
+			if src.enabled {
+				account := *cfg.Graph
+				account.Name = cfg.Name
+				config.GraphAccounts = append(config.GraphAccounts, account)
+			}
+		}
+
+		for _, folder := range cfg.Folders {
+			if folder.IMAPAccount != "" || folder.GraphAccount != "" {
+				return fmt.Errorf("imap_account и graph_account не указываются в папках источника %s", cfg.Name)
+			}
+			switch cfg.Type {
+			case sourceIMAP:
+				folder.IMAPAccount = cfg.Name
+			case sourceGraph:
+				folder.GraphAccount = cfg.Name
+			case sourceDrop:
+				if folder.DropDir == "" {
+					return fmt.Errorf("В папке %s источника %s не указан drop_dir", folder.Name, cfg.Name)
+				}
+			case sourceOutlook:
+				if folder.DropDir != "" {
+					return fmt.Errorf("drop_dir не указывается в папках источника outlook (%s)", cfg.Name)
+				}
+			}
+			folder.source = cfg.Name
+			folders = append(folders, folder)
+		}
+	}
+
+	// Папки верхнего уровня относятся к источнику по способу получения писем
+	for _, folder := range config.Folders {
+		var name, kind string
+		switch {
+		case folder.IMAPAccount != "":
+			name, kind = imapOwners[folder.IMAPAccount], sourceIMAP
+			if name == "" {
+				name = "imap:" + folder.IMAPAccount
+			}
+		case folder.GraphAccount != "":
+			name, kind = graphOwners[folder.GraphAccount], sourceGraph
+			if name == "" {
+				name = "graph:" + folder.GraphAccount
+			}
+		case folder.DropDir != "":
+			name, kind = sourceDrop, sourceDrop
+		default:
+			name, kind = outlookSourceName, sourceOutlook
+			if name == "" {
+				name = sourceOutlook
+				outlookSourceName = name
+			}
+		}
+
+		src := findSource(name)
+		if src == nil {
+			var err error
+			if src, err = addSource(name, kind, true); err != nil {
+				return err
+			}
+			src.account = folder.IMAPAccount + folder.GraphAccount
+		} else if src.kind != kind {
+			return fmt.Errorf("Имя %s уже занято источником типа %s", name, src.kind)
+		}
+		folder.source = name
+		folders = append(folders, folder)
+	}
+
+	// Папки выключенных источников не отслеживаются
+	config.Folders = config.Folders[:0]
+	for _, folder := range folders {
+		if findSource(folder.source).enabled {
+			config.Folders = append(config.Folders, folder)
+		}
+	}
+	return nil
+}
+
+// Запускает все включенные источники, у которых есть папки
+func startSources(ctx context.Context) {
+	for _, src := range configuredSources {
+		stats.addSource(src.name, src.kind, src.enabled)
+		if !src.enabled {
+			logMessage("Источник %s выключен", src.name)
+			continue
+		}
+		if len(src.folders()) == 0 {
+			logMessage("У источника %s нет папок", src.name)
+			continue
+		}
+
+		switch src.kind {
+		case sourceOutlook:
+			superviseSource(ctx, src, mainLogic)
+			if config.EventMode {
+				superviseSource(ctx, src, runEventMode)
+			}
+		case sourceIMAP:
+			superviseSource(ctx, src, src.runIMAP)
+		case sourceGraph:
+			superviseSource(ctx, src, src.runGraph)
+		case sourceDrop:
+			superviseSource(ctx, src, src.runDrop)
+		}
+	}
+}
+
+// Папки источника
+func (src *configuredSource) folders() []Folder {
+	var folders []Folder
+	for _, folder := range config.Folders {
+		if folder.source == src.name {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
+
+// Выполняет работу источника в отдельной горутине. После паники или ошибки
+// работа перезапускается, поэтому сбой одного источника не мешает остальным
+func superviseSource(ctx context.Context, src *configuredSource, run func(ctx context.Context) error) {
+	safeGo(func() {
+		delay := sourceRestartMin
+		for ctx.Err() == nil {
+			stats.setSourceStatus(src.name, sourceRunning)
+			started := time.Now()
+			err := runRecovered(ctx, run)
+			if ctx.Err() != nil {
+				break
+			}
+			if err == nil {
+				err = fmt.Errorf("работа источника неожиданно завершилась")
+			}
+
+			// Источник долго проработал без сбоев: пауза снова минимальная
+			if time.Since(started) > sourceRestartMax {
+				delay = sourceRestartMin
+			}
+			stats.sourceFailed(src.name, err)
+			stats.setSourceStatus(src.name, sourceRestarting)
+			logMessage("Источник %s: %v. Перезапуск через %v", src.name, err, delay)
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			if delay *= 2; delay > sourceRestartMax {
+				delay = sourceRestartMax
+			}
+		}
+		stats.setSourceStatus(src.name, sourceStopped)
+	})
+}
+
+// Выполняет run и превращает панику в ошибку
+func runRecovered(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			handlePanic(r)
+			err = fmt.Errorf("паника: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+// Журнал ошибок источника: сообщения пишутся в общий журнал и считаются ошибками источника
+func (src *configuredSource) errorf(format string, args ...interface{}) {
+	logMessage(format, args...)
+	stats.sourceFailed(src.name, fmt.Errorf(format, args...))
+}
+
+// Включен ли источник Outlook и есть ли у него папки: иначе к Outlook
+// обращаться незачем
+func outlookEnabled() bool {
+	src := findSource(outlookSourceName)
+	return src != nil && src.enabled && len(src.folders()) > 0
+}
+
+// Профиль, с которым запускается Outlook
+func outlookProfile() string {
+	if src := findSource(outlookSourceName); src != nil {
+		return src.profile
+	}
+	return ""
+}
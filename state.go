@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"otn/mailsource/graphsource"
+)
+
+// Состояние, которое должно переживать перезапуск программы
+type persistentState struct {
+	mutex sync.Mutex
+	path  string
+
+	// Отправленные уведомления о проблемах, ключ - ключ корреляции оповещения
+	Alerts map[string]SentMessage `json:"alerts"`
+	// Первое уведомление в каждой переписке, ключ - чат и идентификатор переписки
+	Threads map[string]SentMessage `json:"threads"`
+	// Письма, по которым отправлены уведомления с кнопками, ключ - чат и message_id
+	Notifications map[string]NotifiedMail `json:"notifications"`
+
+	// Пересылка приостановлена командой /pause (глобально или для папок)
+	Paused        bool            `json:"paused"`
+	PausedFolders map[string]bool `json:"paused_folders"`
+	// Папки, уведомления из которых не отправляются до указанного времени (/mute)
+	Muted map[string]time.Time `json:"muted"`
+	// Время получения последнего обработанного письма по папкам (detection: received_time)
+	Watermarks map[string]time.Time `json:"watermarks"`
+	// EntryID писем, полученных в ту же секунду, что и отметка, и уже обработанных
+	WatermarkIDs map[string][]string `json:"watermark_ids"`
+	// Последний обработанный UID по почтовым ящикам IMAP, ключ - учетная запись и ящик
+	IMAPUIDs map[string]IMAPPosition `json:"imap_uids"`
+	// Ссылки на следующую разностную выборку Graph по папкам, ключ - учетная запись и ID папки
+	GraphDeltas map[string]string `json:"graph_deltas"`
+	// Отметки последних отправленных писем Graph по папкам, ключ тот же
+	GraphMarks map[string]graphsource.Mark `json:"graph_marks"`
+}
+
+// Позиция в почтовом ящике IMAP. UID действительны только при неизменном UIDVALIDITY
+type IMAPPosition struct {
+	Validity uint32 `json:"validity"`
+	UID      uint32 `json:"uid"`
+}
+
+// Письмо, на которое ссылается уведомление
+type NotifiedMail struct {
+	EntryID string    `json:"entry_id"`
+	Folder  string    `json:"folder"`
+	Time    time.Time `json:"time"`
+}
+
+// Отправленное в Telegram сообщение
+type SentMessage struct {
+	ChatID    string    `json:"chat_id"`
+	MessageID int       `json:"message_id"`
+	Text      string    `json:"text,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+var state = &persistentState{
+	Alerts:        make(map[string]SentMessage),
+	Threads:       make(map[string]SentMessage),
+	Notifications: make(map[string]NotifiedMail),
+	PausedFolders: make(map[string]bool),
+	Muted:         make(map[string]time.Time),
+	Watermarks:    make(map[string]time.Time),
+	WatermarkIDs:  make(map[string][]string),
+	IMAPUIDs:      make(map[string]IMAPPosition),
+	GraphDeltas:   make(map[string]string),
+	GraphMarks:    make(map[string]graphsource.Mark),
+}
+
+// Сколько хранить сведения об отправленных сообщениях
+const stateRetention = 30 * 24 * time.Hour
+
+// Загружает состояние из файла
+func initState(filename string) error {
+	state.path = dataFilePath(filename)
+
+	data, err := os.ReadFile(state.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("ошибка чтения файла состояния: %v", err)
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return fmt.Errorf("ошибка разбора файла состояния: %v", err)
+	}
+	if state.Alerts == nil {
+		state.Alerts = make(map[string]SentMessage)
+	}
+	if state.Threads == nil {
+		state.Threads = make(map[string]SentMessage)
+	}
+
+	if state.Notifications == nil {
+		state.Notifications = make(map[string]NotifiedMail)
+	}
+	if state.PausedFolders == nil {
+		state.PausedFolders = make(map[string]bool)
+	}
+	if state.Muted == nil {
+		state.Muted = make(map[string]time.Time)
+	}
+	if state.Watermarks == nil {
+		state.Watermarks = make(map[string]time.Time)
+	}
+	if state.WatermarkIDs == nil {
+		state.WatermarkIDs = make(map[string][]string)
+	}
+	if state.IMAPUIDs == nil {
+		state.IMAPUIDs = make(map[string]IMAPPosition)
+	}
+	if state.GraphDeltas == nil {
+		state.GraphDeltas = make(map[string]string)
+	}
+	if state.GraphMarks == nil {
+		state.GraphMarks = make(map[string]graphsource.Mark)
+	}
+
+	// Удаляем устаревшие записи
+	pruneMessages(state.Alerts)
+	pruneMessages(state.Threads)
+	cutoff := time.Now().Add(-stateRetention)
+	for key, mail := range state.Notifications {
+		if mail.Time.Before(cutoff) {
+			delete(state.Notifications, key)
+		}
+	}
+	return nil
+}
+
+func pruneMessages(messages map[string]SentMessage) {
+	cutoff := time.Now().Add(-stateRetention)
+	for key, msg := range messages {
+		if msg.Time.Before(cutoff) {
+			delete(messages, key)
+		}
+	}
+}
+
+// Сохраняет состояние в файл. Вызывается с захваченным мьютексом
+func (s *persistentState) saveLocked() {
+	if s.path == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		logMessage("Ошибка сохранения состояния: %v", err)
+		return
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		logMessage("Ошибка сохранения состояния: %v", err)
+		return
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		logMessage("Ошибка сохранения состояния: %v", err)
+	}
+}
+
+// Запоминает уведомление о проблеме
+func (s *persistentState) rememberAlert(key string, msg SentMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Alerts[key] = msg
+	s.saveLocked()
+}
+
+// Возвращает уведомление о проблеме
+func (s *persistentState) lookupAlert(key string) (SentMessage, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg, ok := s.Alerts[key]
+	return msg, ok
+}
+
+// Удаляет уведомление о проблеме после того, как оно было закрыто
+func (s *persistentState) forgetAlert(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.Alerts[key]; ok {
+		delete(s.Alerts, key)
+		s.saveLocked()
+	}
+}
+
+// Возвращает первое уведомление переписки
+func (s *persistentState) lookupThread(key string) (SentMessage, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	msg, ok := s.Threads[key]
+	return msg, ok
+}
+
+// Запоминает первое уведомление переписки
+func (s *persistentState) rememberThread(key string, msg SentMessage) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Threads[key] = msg
+	s.saveLocked()
+}
+
+func notificationKey(chatID string, messageID int) string {
+	return fmt.Sprintf("%s:%d", chatID, messageID)
+}
+
+// Запоминает письмо, на которое ссылается уведомление
+func (s *persistentState) rememberNotification(chatID string, messageID int, mail NotifiedMail) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Notifications[notificationKey(chatID, messageID)] = mail
+	s.saveLocked()
+}
+
+// Возвращает письмо, на которое ссылается уведомление
+func (s *persistentState) lookupNotification(chatID string, messageID int) (NotifiedMail, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	mail, ok := s.Notifications[notificationKey(chatID, messageID)]
+	return mail, ok
+}
+
+// Приостанавливает или возобновляет пересылку. Пустое имя папки - для всех папок
+func (s *persistentState) setPaused(folder string, paused bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if folder == "" {
+		s.Paused = paused
+		if !paused {
+			s.PausedFolders = make(map[string]bool)
+		}
+	} else if paused {
+		s.PausedFolders[folder] = true
+	} else {
+		delete(s.PausedFolders, folder)
+	}
+	s.saveLocked()
+}
+
+// Проверяет, приостановлена ли пересылка из папки
+func (s *persistentState) isPaused(folder string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.Paused || s.PausedFolders[folder]
+}
+
+// Отключает уведомления из папки до указанного времени (нулевое время - включает)
+func (s *persistentState) setMuted(folder string, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if until.IsZero() {
+		delete(s.Muted, folder)
+	} else {
+		s.Muted[folder] = until
+	}
+	s.saveLocked()
+}
+
+// Проверяет, отключены ли уведомления из папки
+func (s *persistentState) isMuted(folder string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	until, ok := s.Muted[folder]
+	return ok && time.Now().Before(until)
+}
+
+// Возвращает состояние папки: приостановлена ли она отдельно и до какого времени отключена
+func (s *persistentState) folderStatus(folder string) (bool, time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.PausedFolders[folder], s.Muted[folder]
+}
+
+// Возвращает отметку времени последнего обработанного письма папки и EntryID
+// обработанных писем, полученных в ту же секунду
+func (s *persistentState) watermark(folder string) (time.Time, []string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	t, ok := s.Watermarks[folder]
+	return t, s.WatermarkIDs[folder], ok
+}
+
+// Сохраняет отметку времени последнего обработанного письма папки
+func (s *persistentState) setWatermark(folder string, t time.Time, ids []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Watermarks[folder] = t
+	if len(ids) > 0 {
+		s.WatermarkIDs[folder] = ids
+	} else {
+		delete(s.WatermarkIDs, folder)
+	}
+	s.saveLocked()
+}
+
+// LoadUID возвращает последний обработанный UID почтового ящика IMAP
+func (s *persistentState) LoadUID(key string) (uint32, uint32, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pos, ok := s.IMAPUIDs[key]
+	return pos.Validity, pos.UID, ok
+}
+
+// SaveUID сохраняет последний обработанный UID почтового ящика IMAP
+func (s *persistentState) SaveUID(key string, validity, uid uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.IMAPUIDs[key] = IMAPPosition{Validity: validity, UID: uid}
+	s.saveLocked()
+}
+
+// LoadDelta возвращает ссылку на следующую разностную выборку папки Graph
+func (s *persistentState) LoadDelta(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.GraphDeltas[key]
+}
+
+// SaveDelta сохраняет ссылку на следующую разностную выборку папки Graph
+func (s *persistentState) SaveDelta(key, deltaLink string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if deltaLink == "" {
+		delete(s.GraphDeltas, key)
+	} else {
+		s.GraphDeltas[key] = deltaLink
+	}
+	s.saveLocked()
+}
+
+// LoadMark возвращает отметку последнего отправленного письма папки Graph
+func (s *persistentState) LoadMark(key string) graphsource.Mark {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.GraphMarks[key]
+}
+
+// SaveMark сохраняет отметку последнего отправленного письма папки Graph
+func (s *persistentState) SaveMark(key string, mark graphsource.Mark) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.GraphMarks[key] = mark
+	s.saveLocked()
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Статистика работы программы для команды /status
+type runtimeStats struct {
+	mutex sync.Mutex
+
+	StartTime     time.Time
+	LastPoll      time.Time // Время последнего успешного цикла проверки почты
+	Forwarded     int       // Отправлено уведомлений
+	SendFailures  int       // Ошибок отправки в Telegram
+	PollFailures  int       // Ошибок работы с Outlook
+	LastError     string
+	LastErrorTime time.Time
+
+	// Счетчики по папкам (для подпапок recursive: true - по их путям)
+	ForwardedByFolder    map[string]int
+	SendFailuresByFolder map[string]int
+
+	// Состояние и счетчики источников писем по их именам
+	Sources map[string]sourceStats
+}
+
+// Состояния источника писем
+const (
+	sourceRunning    = "running"    // Работает
+	sourceRestarting = "restarting" // Упал и ждет перезапуска
+	sourceStopped    = "stopped"    // Остановлен при завершении программы
+	sourceDisabled   = "disabled"   // Выключен в настройках (enabled: false)
+)
+
+// Состояние и счетчики одного источника писем
+type sourceStats struct {
+	Kind          string
+	Status        string
+	Since         time.Time // Когда источник перешел в текущее состояние
+	Restarts      int
+	Forwarded     int
+	SendFailures  int
+	Errors        int // Ошибок получения писем
+	LastError     string
+	LastErrorTime time.Time
+}
+
+var stats = &runtimeStats{
+	StartTime:            time.Now(),
+	ForwardedByFolder:    make(map[string]int),
+	SendFailuresByFolder: make(map[string]int),
+	Sources:              make(map[string]sourceStats),
+}
+
+func (s *runtimeStats) pollDone() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.LastPoll = time.Now()
+}
+
+func (s *runtimeStats) forwarded(source, folder string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.Forwarded++
+	s.ForwardedByFolder[folder]++
+	if src, ok := s.Sources[source]; ok {
+		src.Forwarded++
+		s.Sources[source] = src
+	}
+}
+
+func (s *runtimeStats) sendFailed(source, folder string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.SendFailures++
+	s.SendFailuresByFolder[folder]++
+	s.LastError = err.Error()
+	s.LastErrorTime = time.Now()
+	if src, ok := s.Sources[source]; ok {
+		src.SendFailures++
+		s.Sources[source] = src
+	}
+}
+
+func (s *runtimeStats) pollFailed(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.PollFailures++
+	s.LastError = err.Error()
+	s.LastErrorTime = time.Now()
+}
+
+// Регистрирует источник писем
+func (s *runtimeStats) addSource(name, kind string, enabled bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status := sourceStopped
+	if !enabled {
+		status = sourceDisabled
+	}
+	s.Sources[name] = sourceStats{Kind: kind, Status: status, Since: time.Now()}
+}
+
+// Меняет состояние источника. При перезапуске увеличивается счетчик перезапусков
+func (s *runtimeStats) setSourceStatus(name, status string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	src, ok := s.Sources[name]
+	if !ok || src.Status == status {
+		return
+	}
+	if status == sourceRunning && src.Status == sourceRestarting {
+		src.Restarts++
+	}
+	src.Status = status
+	src.Since = time.Now()
+	s.Sources[name] = src
+}
+
+// Запоминает ошибку получения писем из источника
+func (s *runtimeStats) sourceFailed(name string, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	src, ok := s.Sources[name]
+	if !ok {
+		return
+	}
+	src.Errors++
+	src.LastError = err.Error()
+	src.LastErrorTime = time.Now()
+	s.Sources[name] = src
+}
+
+// Возвращает копию статистики
+func (s *runtimeStats) snapshot() runtimeStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return runtimeStats{
+		StartTime:     s.StartTime,
+		LastPoll:      s.LastPoll,
+		Forwarded:     s.Forwarded,
+		SendFailures:  s.SendFailures,
+		PollFailures:  s.PollFailures,
+		LastError:     s.LastError,
+		LastErrorTime: s.LastErrorTime,
+
+		ForwardedByFolder:    copyCounts(s.ForwardedByFolder),
+		SendFailuresByFolder: copyCounts(s.SendFailuresByFolder),
+
+		Sources: copySources(s.Sources),
+	}
+}
+
+func copyCounts(m map[string]int) map[string]int {
+	result := make(map[string]int, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
+
+func copySources(m map[string]sourceStats) map[string]sourceStats {
+	result := make(map[string]sourceStats, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"otn/ratelimit"
+)
+
+// Ограничения Telegram по умолчанию: около 30 сообщений в секунду на бота,
+// одно сообщение в секунду в чат и 20 сообщений в минуту в группу
+const (
+	defaultGlobalPerSecond = 25
+	defaultChatPerSecond   = 1
+	defaultGroupPerMinute  = 20
+)
+
+// Ограничение частоты сообщений Telegram
+type RateLimitConfig struct {
+	GlobalPerSecond int `json:"global_per_second"` // Всего сообщений бота в секунду
+	ChatPerSecond   int `json:"chat_per_second"`   // Сообщений в один чат в секунду
+	GroupPerMinute  int `json:"group_per_minute"`  // Сообщений в одну группу или канал в минуту
+}
+
+// Ограничение частоты сообщений основного бота по chat_id
+var telegramLimiter *ratelimit.Limiter
+
+// Проверяет раздел rate_limit
+func validateRateLimit() error {
+	if config.RateLimit == nil {
+		return nil
+	}
+	if config.RateLimit.GlobalPerSecond < 0 || config.RateLimit.ChatPerSecond < 0 || config.RateLimit.GroupPerMinute < 0 {
+		return fmt.Errorf("rate_limit: значения не могут быть отрицательными")
+	}
+	return nil
+}
+
+// Создает лимитер по настройкам rate_limit (нулевые значения - по умолчанию)
+func initTelegramLimiter() {
+	cfg := RateLimitConfig{}
+	if config.RateLimit != nil {
+		cfg = *config.RateLimit
+	}
+	if cfg.GlobalPerSecond == 0 {
+		cfg.GlobalPerSecond = defaultGlobalPerSecond
+	}
+	if cfg.ChatPerSecond == 0 {
+		cfg.ChatPerSecond = defaultChatPerSecond
+	}
+	if cfg.GroupPerMinute == 0 {
+		cfg.GroupPerMinute = defaultGroupPerMinute
+	}
+
+	telegramLimiter = ratelimit.New(ratelimit.Rate{Count: cfg.GlobalPerSecond, Per: time.Second}, func(chatID string) []ratelimit.Rate {
+		rates := []ratelimit.Rate{{Count: cfg.ChatPerSecond, Per: time.Second}}
+		if isGroupChat(chatID) {
+			rates = append(rates, ratelimit.Rate{Count: cfg.GroupPerMinute, Per: time.Minute})
+		}
+		return rates
+	})
+}
+
+// Группы, супергруппы и каналы: отрицательный chat_id или @имя канала
+func isGroupChat(chatID string) bool {
+	return strings.HasPrefix(chatID, "-") || strings.HasPrefix(chatID, "@")
+}
+
+// Ждет, пока ограничение частоты позволит отправить сообщение в чат
+func waitTelegramLimit(chatID string) {
+	if telegramLimiter == nil {
+		return
+	}
+	if wait := telegramLimiter.Reserve(chatID, time.Now()); wait > 0 {
+		logDebug("Ожидание лимита Telegram для чата %s: %v", chatID, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// Резервирует отправку уведомления из очереди в чат Telegram и возвращает,
+// сколько уведомление должно ждать своей очереди (0 для других получателей)
+func reserveTelegramLimit(dest string) time.Duration {
+	chatID, ok := strings.CutPrefix(dest, "telegram:")
+	if !ok || telegramLimiter == nil {
+		return 0
+	}
+	return telegramLimiter.Reserve(chatID, time.Now())
+}
+
+// Вызывает метод Bot API, который пишет в чат chatID, с учетом ограничения
+// частоты. reserved - отправку уже зарезервировала очередь уведомлений: такой
+// запрос не ждет лимита и не задерживает остальные чаты и каналы. Если
+// Telegram все же ответил 429, чат приостанавливается на указанное в ответе время
+func postChatJSON(chatID, url string, data interface{}, reserved bool) ([]byte, error) {
+	if !reserved {
+		waitTelegramLimit(chatID)
+	}
+	body, err := postJSON(url, data)
+	if wait := floodWait(err); wait > 0 {
+		pauseTelegramChat(chatID, wait)
+	}
+	return body, err
+}
+
+// Приостанавливает отправку в чат после ответа 429. Уведомления в этот чат
+// ждут в очереди, не задерживая остальные
+func pauseTelegramChat(chatID string, wait time.Duration) {
+	if telegramLimiter == nil {
+		return
+	}
+	logMessage("Telegram ограничил частоту сообщений в чат %s: пауза %v", chatID, wait)
+	telegramLimiter.Pause(chatID, wait, time.Now())
+}
+
+// Пауза из ответа 429 Bot API (parameters.retry_after, в секундах)
+func floodWait(err error) time.Duration {
+	var statusErr *telegramStatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusTooManyRequests {
+		return 0
+	}
+	var response struct {
+		Parameters struct {
+			RetryAfter int `json:"retry_after"`
+		} `json:"parameters"`
+	}
+	if json.Unmarshal([]byte(statusErr.Body), &response) != nil || response.Parameters.RetryAfter <= 0 {
+		return time.Second
+	}
+	return time.Duration(response.Parameters.RetryAfter) * time.Second
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Входящие обновления Telegram (только используемые поля)
+type tgUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type tgChat struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Username string `json:"username"`
+}
+
+type tgMessage struct {
+	MessageID      int        `json:"message_id"`
+	From           *tgUser    `json:"from"`
+	Chat           tgChat     `json:"chat"`
+	Text           string     `json:"text"`
+	ReplyToMessage *tgMessage `json:"reply_to_message"`
+	ReplyMarkup    *struct {
+		InlineKeyboard [][]inlineButton `json:"inline_keyboard"`
+	} `json:"reply_markup"`
+}
+
+type tgCallbackQuery struct {
+	ID      string     `json:"id"`
+	From    tgUser     `json:"from"`
+	Message *tgMessage `json:"message"`
+	Data    string     `json:"data"`
+}
+
+type tgUpdate struct {
+	UpdateID      int              `json:"update_id"`
+	Message       *tgMessage       `json:"message"`
+	CallbackQuery *tgCallbackQuery `json:"callback_query"`
+}
+
+// Время ожидания long polling в секундах. Telegram держит запрос открытым
+// до появления обновлений, поэтому getUpdates идет через updatesClient
+const updatesPollTimeout = 25
+
+// Клиент для getUpdates: тот же прокси, что у httpClient, но таймауты
+// рассчитаны на long polling
+var updatesClient *http.Client
+
+// Копия client с таймаутами длиннее updatesPollTimeout. Транспорт
+// клонируется, поэтому настройки прокси сохраняются
+func longPollClient(client *http.Client) *http.Client {
+	timeout := (updatesPollTimeout + 15) * time.Second
+	poll := *client
+	poll.Timeout = timeout
+	if transport, ok := client.Transport.(*http.Transport); ok {
+		transport = transport.Clone()
+		transport.ResponseHeaderTimeout = timeout
+		poll.Transport = transport
+	}
+	return &poll
+}
+
+// Префикс callback_data кнопок программы
+const callbackPrefix = "otn:"
+
+// Кнопки действий под уведомлением
+var actionButtons = map[string]struct {
+	label string
+	emoji string
+	done  string
+}{
+	"read":    {"Прочитано", "✉️", "Отмечено прочитанным"},
+	"flag":    {"Флажок", "🚩", "Отмечено флажком"},
+	"archive": {"В архив", "🗄", "Перемещено в архив"},
+}
+
+// Порядок кнопок в строке
+var actionButtonOrder = []string{"read", "flag", "archive"}
+
+// Проверка настроек кнопок папки
+func validateButtons(folder *Folder) error {
+	for _, name := range folder.Buttons {
+		if _, ok := actionButtons[name]; !ok {
+			return fmt.Errorf("неизвестная кнопка %q (допустимы: read, flag, archive)", name)
+		}
+		if name == "archive" && folder.ArchiveFolder == "" {
+			return fmt.Errorf("для кнопки archive нужно указать archive_folder")
+		}
+	}
+	if len(folder.Buttons) > 0 && len(config.Telegram.AllowedUserIDs) == 0 {
+		return fmt.Errorf("для кнопок нужно указать telegram.allowed_user_ids")
+	}
+	return nil
+}
+
+// Формирует строку кнопок для уведомления
+func actionKeyboard(buttons []string) [][]inlineButton {
+	if len(buttons) == 0 {
+		return nil
+	}
+
+	enabled := make(map[string]bool, len(buttons))
+	for _, name := range buttons {
+		enabled[name] = true
+	}
+
+	var row []inlineButton
+	for _, name := range actionButtonOrder {
+		if !enabled[name] {
+			continue
+		}
+		spec := actionButtons[name]
+		text := spec.label
+		if config.Telegram.UseEmojis {
+			text = spec.emoji + " " + text
+		}
+		row = append(row, inlineButton{Text: text, CallbackData: callbackPrefix + name})
+	}
+	return [][]inlineButton{row}
+}
+
+// Цикл получения обновлений Telegram (нажатия кнопок и команды)
+func runUpdates(ctx context.Context) {
+	if len(config.Telegram.AllowedUserIDs) == 0 {
+		return
+	}
+
+	logMessage("Запущена обработка обновлений Telegram")
+
+	offset := 0
+	for ctx.Err() == nil {
+		updates, err := getUpdates(offset)
+		if err != nil {
+			logMessage("Ошибка получения обновлений Telegram: %v", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			handleUpdate(update)
+		}
+	}
+}
+
+func getUpdates(offset int) ([]tgUpdate, error) {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/getUpdates", config.Telegram.BotToken)
+	body, err := postJSONWith(updatesClient, url, map[string]interface{}{
+		"offset":          offset,
+		"timeout":         updatesPollTimeout,
+		"allowed_updates": []string{"message", "callback_query"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Result []tgUpdate `json:"result"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("ошибка декодирования ответа: %v", err)
+	}
+	return response.Result, nil
+}
+
+func handleUpdate(update tgUpdate) {
+	defer func() {
+		if r := recover(); r != nil {
+			handlePanic(r)
+		}
+	}()
+
+	if update.CallbackQuery != nil {
+		handleCallback(update.CallbackQuery)
+	}
+	if update.Message != nil {
+		handleMessage(update.Message)
+	}
+}
+
+// Обработка входящего сообщения: команды в личном чате и ответы на уведомления
+func handleMessage(msg *tgMessage) {
+	if msg.From == nil || msg.Text == "" {
+		return
+	}
+
+	isCommand := strings.HasPrefix(msg.Text, "/") && msg.Chat.Type == "private"
+	isReply := msg.ReplyToMessage != nil && !strings.HasPrefix(msg.Text, "/")
+	if !isCommand && !isReply {
+		return
+	}
+
+	if !isAllowedUser(msg.From.ID) {
+		logMessage("Сообщение от неразрешенного пользователя %s (ID %d)", userDisplayName(*msg.From), msg.From.ID)
+		return
+	}
+
+	if isCommand {
+		handleCommand(msg)
+	} else {
+		handleReply(msg)
+	}
+}
+
+// Ответ в Telegram на уведомление отправляется ответом на письмо через Outlook
+func handleReply(msg *tgMessage) {
+	mail, _, ok := findNotifiedMail(msg.ReplyToMessage)
+	if !ok {
+		return // Ответ не на уведомление программы
+	}
+
+	folderConfig, _ := findFolderConfig(mail.Folder)
+	if !folderConfig.AllowReply {
+		return
+	}
+
+	who := userDisplayName(*msg.From)
+	err := mailSource.ReplyAll(mail.EntryID, msg.Text)
+
+	writeAudit(AuditRecord{
+		UserID:  msg.From.ID,
+		User:    who,
+		Action:  "reply",
+		Folder:  mail.Folder,
+		EntryID: mail.EntryID,
+		Result:  auditResult(err),
+	})
+
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	if err != nil {
+		logMessage("Ошибка отправки ответа на письмо (%s): %v", who, err)
+		sendTelegramReply("Не удалось отправить ответ: "+html.EscapeString(err.Error()), chatID, msg.MessageID)
+		return
+	}
+
+	logMessage("Ответ на письмо из папки %s отправлен из Telegram: %s", mail.Folder, who)
+	text := "Ответ отправлен по почте"
+	if config.Telegram.UseEmojis {
+		text = "✉️ " + text
+	}
+	sendTelegramReply(text, chatID, msg.MessageID)
+}
+
+func isAllowedUser(id int64) bool {
+	for _, allowed := range config.Telegram.AllowedUserIDs {
+		if allowed == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Имя пользователя для отображения в чате и логах
+func userDisplayName(u tgUser) string {
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if u.Username != "" {
+		if name == "" {
+			return "@" + u.Username
+		}
+		return name + " (@" + u.Username + ")"
+	}
+	if name == "" {
+		return strconv.FormatInt(u.ID, 10)
+	}
+	return name
+}
+
+// Ищет письмо по сообщению: чат в конфигурации может быть указан как ID или как @имя
+func findNotifiedMail(msg *tgMessage) (NotifiedMail, string, bool) {
+	chatID := strconv.FormatInt(msg.Chat.ID, 10)
+	if mail, ok := state.lookupNotification(chatID, msg.MessageID); ok {
+		return mail, chatID, true
+	}
+	if msg.Chat.Username != "" {
+		chatID = "@" + msg.Chat.Username
+		if mail, ok := state.lookupNotification(chatID, msg.MessageID); ok {
+			return mail, chatID, true
+		}
+	}
+	return NotifiedMail{}, "", false
+}
+
+// Обработка нажатия кнопки под уведомлением
+func handleCallback(cb *tgCallbackQuery) {
+	if !strings.HasPrefix(cb.Data, callbackPrefix) || cb.Message == nil {
+		answerCallbackQuery(cb.ID, "")
+		return
+	}
+
+	if !isAllowedUser(cb.From.ID) {
+		logMessage("Нажатие кнопки от неразрешенного пользователя %s (ID %d)", userDisplayName(cb.From), cb.From.ID)
+		answerCallbackQuery(cb.ID, "Нет доступа")
+		return
+	}
+
+	action := strings.TrimPrefix(cb.Data, callbackPrefix)
+	spec, ok := actionButtons[action]
+	if !ok {
+		answerCallbackQuery(cb.ID, "")
+		return
+	}
+
+	mail, chatID, ok := findNotifiedMail(cb.Message)
+	if !ok {
+		answerCallbackQuery(cb.ID, "Письмо не найдено")
+		return
+	}
+
+	var err error
+	switch action {
+	case "read":
+		err = mailSource.MarkRead(mail.EntryID)
+	case "flag":
+		err = mailSource.Flag(mail.EntryID)
+	case "archive":
+		folderConfig, _ := findFolderConfig(mail.Folder)
+		var newEntryID string
+		newEntryID, err = mailSource.Move(mail.EntryID, folderConfig.ArchiveFolder)
+		if err == nil && newEntryID != "" {
+			mail.EntryID = newEntryID
+			state.rememberNotification(chatID, cb.Message.MessageID, mail)
+		}
+	}
+
+	who := userDisplayName(cb.From)
+	writeAudit(AuditRecord{
+		UserID:  cb.From.ID,
+		User:    who,
+		Action:  action,
+		Folder:  mail.Folder,
+		EntryID: mail.EntryID,
+		Result:  auditResult(err),
+	})
+
+	if err != nil {
+		logMessage("Ошибка действия %s по кнопке (%s): %v", action, who, err)
+		answerCallbackQuery(cb.ID, "Ошибка: "+truncateByRunes(err.Error(), 150))
+		return
+	}
+
+	logMessage("%s: %s (папка %s)", spec.done, who, mail.Folder)
+	answerCallbackQuery(cb.ID, spec.done)
+
+	// Убираем нажатую кнопку и показываем, кто выполнил действие
+	var keyboard [][]inlineButton
+	if cb.Message.ReplyMarkup != nil {
+		for _, row := range cb.Message.ReplyMarkup.InlineKeyboard {
+			var kept []inlineButton
+			for _, button := range row {
+				if button.CallbackData != cb.Data {
+					kept = append(kept, button)
+				}
+			}
+			if len(kept) > 0 {
+				keyboard = append(keyboard, kept)
+			}
+		}
+	}
+	status := spec.done + ": " + who + ", " + time.Now().Format("15:04 02.01")
+	if config.Telegram.UseEmojis {
+		status = "✔️ " + status
+	}
+	keyboard = append(keyboard, []inlineButton{{Text: status, CallbackData: callbackPrefix + "done"}})
+
+	if err := editTelegramReplyMarkup(chatID, cb.Message.MessageID, keyboard); err != nil {
+		logMessage("Ошибка обновления кнопок: %v", err)
+	}
+}
+
+func answerCallbackQuery(callbackID, text string) {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/answerCallbackQuery", config.Telegram.BotToken)
+	params := map[string]interface{}{"callback_query_id": callbackID}
+	if text != "" {
+		params["text"] = text
+	}
+	if _, err := postJSON(url, params); err != nil {
+		logMessage("Ошибка ответа на нажатие кнопки: %v", err)
+	}
+}
+
+func editTelegramReplyMarkup(chatID string, messageID int, keyboard [][]inlineButton) error {
+	url := fmt.Sprintf("https://api.telegram.org/bot%s/editMessageReplyMarkup", config.Telegram.BotToken)
+	_, err := postChatJSON(chatID, url, map[string]interface{}{
+		"chat_id":      chatID,
+		"message_id":   messageID,
+		"reply_markup": map[string]interface{}{"inline_keyboard": keyboard},
+	}, false)
+	return err
+}